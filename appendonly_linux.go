@@ -0,0 +1,66 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const appendOnlySupported = true
+
+const fsAppendFL = 0x20 // FS_APPEND_FL
+
+// The FS_IOC_GETFLAGS or, with set, FS_IOC_SETFLAGS ioctl, _IOR('f', 1,
+// long) and _IOW('f', 2, long), whose direction bits vary by architecture.
+func flagsIoctl(set bool) uintptr {
+	read, write, shift := uintptr(2), uintptr(1), 30
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "ppc64", "ppc64le":
+		read, write, shift = 2, 4, 29
+	}
+	dir, nr := read, uintptr(1)
+	if set {
+		dir, nr = write, 2
+	}
+	return dir<<shift | unsafe.Sizeof(uintptr(0))<<16 | 'f'<<8 | nr
+}
+
+func appendOnly(f *os.File) error {
+	var flags int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), flagsIoctl(false), uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return errno
+	}
+	if flags&fsAppendFL != 0 {
+		return nil
+	}
+	flags |= fsAppendFL
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), flagsIoctl(true), uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// An Archiver ships rolled files somewhere more durable than the local
+// disk. Archive is called once for each rolled file, in order, from the
+// writer's rotation goroutine; a slow archiver delays later rotations but
+// never blocks Write. The file is not touched by the writer afterwards, so
+// an archiver may remove it once it has been copied.
+//
+// The s3archive package provides an implementation for S3-compatible
+// object stores.
+type Archiver interface {
+	Archive(ctx context.Context, path string) error
+}
+
+// ArchiverFunc adapts an ordinary function to the Archiver interface.
+type ArchiverFunc func(ctx context.Context, path string) error
+
+func (f ArchiverFunc) Archive(ctx context.Context, path string) error {
+	return f(ctx, path)
+}
+
+// ReplicatedArchiver hands each file to every destination concurrently, for
+// example S3 buckets in two regions, and fails unless all of them succeed.
+// The file is only considered shipped, and removed if Remove is set, once
+// every copy has been made, so destinations must not remove it themselves.
+type ReplicatedArchiver struct {
+	Destinations []Archiver
+	Remove       bool
+}
+
+func (r *ReplicatedArchiver) Archive(ctx context.Context, path string) error {
+	errs := make([]error, len(r.Destinations))
+	var wg sync.WaitGroup
+	for i, a := range r.Destinations {
+		wg.Add(1)
+		go func(i int, a Archiver) {
+			defer wg.Done()
+			if err := a.Archive(ctx, path); err != nil {
+				errs[i] = fmt.Errorf("destination %d: %w", i, err)
+			}
+		}(i, a)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if r.Remove {
+		return os.Remove(path)
+	}
+	return nil
+}
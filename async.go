@@ -0,0 +1,619 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy selects what Write does when the Config.Async queue is
+// full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the record being written.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops queued records, oldest first, to make room.
+	OverflowDropOldest
+	// OverflowBlock waits for room, so nothing is lost but Write may wait
+	// on the disk after all. It ignores Config.MaxWriteLatency.
+	OverflowBlock
+	// OverflowSpill appends records to Config.AsyncOverflowFile until the
+	// queue catches up, so nothing is lost and Write does not wait on the
+	// log, at the cost of a slower path for those records.
+	OverflowSpill
+)
+
+func (o OverflowPolicy) MarshalText() ([]byte, error) {
+	switch o {
+	case OverflowDropNewest:
+		return []byte("drop-newest"), nil
+	case OverflowDropOldest:
+		return []byte("drop-oldest"), nil
+	case OverflowBlock:
+		return []byte("block"), nil
+	case OverflowSpill:
+		return []byte("spill"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown overflow policy %d", int(o))
+}
+
+func (o *OverflowPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "drop-newest", "":
+		*o = OverflowDropNewest
+	case "drop-oldest":
+		*o = OverflowDropOldest
+	case "block":
+		*o = OverflowBlock
+	case "spill":
+		*o = OverflowSpill
+	default:
+		return fmt.Errorf("rollinglog: unknown overflow policy %q", text)
+	}
+	return nil
+}
+
+// The queue between Write and the file with Config.Async. What happens to
+// a record that does not fit is up to Config.AsyncOverflow.
+type asyncQueue struct {
+	rf     *Writer
+	bound  time.Duration
+	limit  int64
+	ch     chan []byte
+	urgent chan []byte   // with Config.AsyncPriority, written out first
+	free   chan []byte   // buffers of records written out, for reuse
+	queued atomic.Int64  // bytes in ch
+	room   chan struct{} // signalled as records leave ch
+	closed atomic.Bool
+	done   chan struct{}
+	quit   chan struct{}
+	abort  chan struct{} // closed to give up draining
+	spill  *spillFile    // with Config.AsyncSpill
+
+	overflow   *overflowFile // with OverflowSpill
+	overflowed chan struct{} // signalled as records go to overflow
+
+	dropped        atomic.Int64 // bytes not yet added to Stats.Lost
+	droppedRecords atomic.Int64
+	droppedBytes   atomic.Int64
+	slow           atomic.Int64
+	maxLatency     atomic.Int64 // nanoseconds
+
+	sumMu   sync.Mutex
+	summary dropSummary // with Config.DropSummary
+}
+
+func newAsyncQueue(rf *Writer) (*asyncQueue, error) {
+	limit, slots := rf.config.RealTimeBuffer, rf.config.AsyncQueue
+	if limit == 0 {
+		limit = 4 << 20
+	}
+	if slots == 0 {
+		slots = 4096
+	}
+	q := &asyncQueue{
+		rf:    rf,
+		bound: rf.config.MaxWriteLatency,
+		limit: int64(limit),
+		ch:    make(chan []byte, slots),
+		free:  make(chan []byte, slots),
+		room:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+		quit:  make(chan struct{}),
+		abort: make(chan struct{}),
+	}
+	if rf.config.AsyncPriority != nil {
+		q.urgent = make(chan []byte, slots)
+	}
+	if p := rf.config.AsyncSpill; p != "" {
+		if err := rf.recoverSpill(p); err != nil {
+			rf.logf("%w", err)
+		}
+		// room for a full queue, the record being written out and each
+		// record's length and time, so placeholders always fit
+		var err error
+		if q.spill, err = createSpill(p, 2*limit+(4+spillStamp)*(slots+2), rf.config.Mode); err != nil {
+			return nil, err
+		}
+	}
+	if rf.config.AsyncOverflow == OverflowSpill {
+		var err error
+		if q.overflow, err = createOverflow(rf.config.AsyncOverflowFile, rf.config.Mode); err != nil {
+			if q.spill != nil {
+				q.spill.close()
+			}
+			return nil, err
+		}
+		q.overflowed = make(chan struct{}, 1)
+	}
+	go q.flush()
+	return q, nil
+}
+
+// Append the records a previous process left in the spill file at p to
+// the files of the periods they were written in, before anything else is
+// written.
+func (rf *Writer) recoverSpill(p string) error {
+	records, err := readSpill(p)
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	for _, record := range records {
+		t := record.t
+		if rf.config.Rollover != RolloverDated {
+			t = time.Time{} // only dated files are named for their period
+		}
+		rf.writeAt(record.p, t)
+	}
+	if len(records) > 0 {
+		rf.journal.event(journalInfo, p, "recovered %d records from %s", len(records), p)
+	}
+	return err
+}
+
+func (q *asyncQueue) write(p []byte) (int, error) {
+	start := time.Now()
+	if q.closed.Load() {
+		return 0, ErrClosed
+	}
+
+	switch {
+	case q.urgent != nil && q.rf.config.AsyncPriority(p):
+		if !q.enqueueUrgent(p) {
+			return 0, ErrClosed
+		}
+	case q.rf.config.AsyncOverflow == OverflowBlock:
+		if !q.enqueueBlocking(q.ch, p) {
+			return 0, ErrClosed
+		}
+	case q.rf.config.AsyncOverflow == OverflowDropOldest:
+		q.enqueueDropOldest(p)
+	case q.overflow != nil:
+		q.enqueueOverflow(p)
+	default:
+		if !q.enqueue(q.ch, p) {
+			q.drop(p, "queue full")
+		}
+	}
+
+	if q.bound <= 0 {
+		return len(p), nil
+	}
+	took := time.Since(start)
+	if took > q.bound {
+		q.slow.Add(1)
+	}
+	for {
+		max := q.maxLatency.Load()
+		if int64(took) <= max || q.maxLatency.CompareAndSwap(max, int64(took)) {
+			break
+		}
+	}
+	return len(p), nil
+}
+
+// Queue a copy of p on lane if it fits without waiting.
+func (q *asyncQueue) enqueue(lane chan []byte, p []byte) bool {
+	n := int64(len(p))
+	if q.queued.Add(n) > q.limit {
+		q.queued.Add(-n)
+		return false
+	}
+	if !q.send(lane, p, false) {
+		q.queued.Add(-n)
+		return false
+	}
+	return true
+}
+
+// Queue a copy of p, dropping the oldest records to make room.
+func (q *asyncQueue) enqueueDropOldest(p []byte) {
+	for !q.enqueue(q.ch, p) {
+		select {
+		case old := <-q.ch:
+			q.queued.Add(-int64(len(old)))
+			q.drop(old, "displaced")
+			if q.spill != nil {
+				q.spill.pop()
+			}
+			q.recycle(old)
+		default:
+			// too large for the queue on its own
+			q.drop(p, "too large")
+			return
+		}
+	}
+}
+
+// Queue a copy of p, or append it to the overflow file if the queue is
+// full or records are waiting there already.
+func (q *asyncQueue) enqueueOverflow(p []byte) {
+	o := q.overflow
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.records.Load() == 0 && q.enqueue(q.ch, p) {
+		return
+	}
+	if err := o.push(p); err != nil {
+		q.rf.logf("writing overflow file: %w", err)
+		q.drop(p, "overflow failed")
+		return
+	}
+	select {
+	case q.overflowed <- struct{}{}:
+	default:
+	}
+}
+
+// Queue a copy of p with Config.AsyncPriority, making room by dropping
+// other records, oldest first, before AsyncOverflow applies. Returns false
+// if the writer is closed while waiting for room.
+func (q *asyncQueue) enqueueUrgent(p []byte) bool {
+	for !q.enqueue(q.urgent, p) {
+		if len(q.urgent) < cap(q.urgent) {
+			// out of bytes rather than slots
+			select {
+			case old := <-q.ch:
+				q.queued.Add(-int64(len(old)))
+				q.drop(old, "displaced")
+				q.recycle(old)
+				continue
+			default:
+			}
+		}
+		switch q.rf.config.AsyncOverflow {
+		case OverflowBlock:
+			return q.enqueueBlocking(q.urgent, p)
+		case OverflowSpill:
+			// in order with the other records rather than ahead of them
+			q.enqueueOverflow(p)
+			return true
+		case OverflowDropOldest:
+			select {
+			case old := <-q.urgent:
+				q.queued.Add(-int64(len(old)))
+				q.drop(old, "displaced")
+				q.recycle(old)
+				continue
+			default:
+			}
+		}
+		// too large for the queue on its own, or full of records as urgent
+		q.drop(p, "queue full")
+		return true
+	}
+	return true
+}
+
+// Queue a copy of p on lane, waiting for room. A record larger than the
+// whole queue waits for it to empty. Returns false if the writer is closed
+// meanwhile.
+func (q *asyncQueue) enqueueBlocking(lane chan []byte, p []byte) bool {
+	n := int64(len(p))
+	for {
+		if queued := q.queued.Add(n); queued <= q.limit || queued == n {
+			break
+		}
+		q.queued.Add(-n)
+		select {
+		case <-q.room:
+		case <-q.quit:
+			return false
+		}
+	}
+	if !q.send(lane, p, true) {
+		q.queued.Add(-n)
+		return false
+	}
+	return true
+}
+
+// Put a copy of p on lane. With wait it waits for a free slot until the
+// writer is closed.
+func (q *asyncQueue) send(lane chan []byte, p []byte, wait bool) bool {
+	var buf []byte
+	select {
+	case buf = <-q.free:
+	default:
+		if !wait && len(lane) == cap(lane) {
+			return false // no slot to copy p for
+		}
+	}
+	p = append(buf, p...)
+	for !q.trySend(lane, p) {
+		if !wait {
+			q.recycle(p)
+			return false
+		}
+		select {
+		case <-q.room:
+		case <-q.quit:
+			return false
+		}
+	}
+	return true
+}
+
+// Put p on lane if there is a free slot, and in the spill file in the
+// same order.
+func (q *asyncQueue) trySend(lane chan []byte, p []byte) bool {
+	s := q.spill
+	if s == nil {
+		select {
+		case lane <- p:
+			return true
+		default:
+			return false
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buf == nil {
+		return false // closed
+	}
+	mark := s.push(p, q.rf.clock.Now())
+	select {
+	case q.ch <- p:
+		return true
+	default:
+		s.unpush(mark)
+		return false
+	}
+}
+
+// Count p as dropped for reason.
+func (q *asyncQueue) drop(p []byte, reason string) {
+	q.dropRecords(1, int64(len(p)), reason)
+}
+
+// Count records totalling n bytes as dropped for reason.
+func (q *asyncQueue) dropRecords(records, n int64, reason string) {
+	if records == 0 {
+		return
+	}
+	q.dropped.Add(n)
+	q.droppedRecords.Add(records)
+	q.droppedBytes.Add(n)
+	if q.rf.config.DropSummary > 0 {
+		q.sumMu.Lock()
+		first := q.summary.records == 0
+		q.summary.addRecords(reason, records, n, q.rf.clock.Now())
+		q.sumMu.Unlock()
+		if first {
+			q.rf.poke() // for step to write the line should the queue go quiet
+		}
+	}
+}
+
+// When the line of Config.DropSummary is due, or zero if no records have
+// been dropped since the last.
+func (q *asyncQueue) summaryDue() time.Time {
+	q.sumMu.Lock()
+	defer q.sumMu.Unlock()
+	if q.summary.records == 0 {
+		return time.Time{}
+	}
+	return q.summary.first.Add(q.rf.config.DropSummary)
+}
+
+// Write the line of Config.DropSummary if it is due, or with force if any
+// records have been dropped since the last. Called with rf.mu held.
+func (q *asyncQueue) summarize(force bool) {
+	rf := q.rf
+	if rf.config.DropSummary <= 0 {
+		return
+	}
+	q.sumMu.Lock()
+	defer q.sumMu.Unlock()
+	d := &q.summary
+	if d.records == 0 || !force && rf.clock.Now().Sub(d.first) < rf.config.DropSummary {
+		return
+	}
+	if _, err := rf.write(d.line()); err == nil {
+		d.reset()
+	}
+}
+
+// Write out buffered records, those of the urgent lane first, until
+// stopped, then drain what is left.
+func (q *asyncQueue) flush() {
+	defer close(q.done)
+	for {
+		select {
+		case p := <-q.urgent:
+			q.writeOut(p)
+			continue
+		default:
+		}
+		select {
+		case p := <-q.urgent:
+			q.writeOut(p)
+		case p := <-q.ch:
+			q.writeOut(p)
+		case <-q.overflowed:
+			q.drainOverflow()
+		case <-q.quit:
+			for {
+				select {
+				case <-q.abort:
+					return
+				default:
+				}
+				select {
+				case p := <-q.urgent:
+					q.writeOut(p)
+					continue
+				default:
+				}
+				select {
+				case p := <-q.ch:
+					q.writeOut(p)
+				default:
+					if q.overflow == nil || q.overflow.records.Load() == 0 {
+						return
+					}
+					q.drainOverflow()
+				}
+			}
+		}
+	}
+}
+
+// Write out the records of the overflow file, after those queued before
+// them, until it is empty.
+func (q *asyncQueue) drainOverflow() {
+	for {
+		select {
+		case p := <-q.urgent:
+			q.writeOut(p)
+			continue
+		case p := <-q.ch:
+			q.writeOut(p)
+			continue
+		case <-q.abort:
+			return
+		default:
+		}
+		var buf []byte
+		select {
+		case buf = <-q.free:
+		default:
+		}
+		p, ok, err := q.overflow.pop(buf)
+		if err != nil {
+			q.rf.logf("%w", err)
+			records, n := q.overflow.discard()
+			q.dropRecords(records, n, "overflow failed")
+			return
+		}
+		if !ok {
+			if buf != nil {
+				q.recycle(buf)
+			}
+			return
+		}
+		q.writeRecord(p)
+	}
+}
+
+// Write out p, which has left the queue.
+func (q *asyncQueue) writeOut(p []byte) {
+	q.queued.Add(-int64(len(p)))
+	select {
+	case q.room <- struct{}{}:
+	default:
+	}
+	q.writeRecord(p)
+}
+
+// Write p to the log, then reuse its buffer.
+func (q *asyncQueue) writeRecord(p []byte) {
+	rf := q.rf
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	rf.write(p)
+	if q.spill != nil {
+		q.spill.pop()
+	}
+	q.summarize(false)
+	if d := q.dropped.Swap(0); d > 0 {
+		rf.lose(int(d))
+	}
+	q.recycle(p)
+}
+
+// Keep the buffer of p, which has left the queue, for another record.
+func (q *asyncQueue) recycle(p []byte) {
+	if cap(p) > maxFilterBuf {
+		return
+	}
+	select {
+	case q.free <- p[:0]:
+	default:
+	}
+}
+
+// Stop accepting records and wait for the buffer to be written out.
+func (q *asyncQueue) stop() {
+	q.stopContext(context.Background())
+}
+
+// Stop accepting records and write out the buffer until ctx is done,
+// dropping what is left then. Returns the number of bytes dropped.
+func (q *asyncQueue) stopContext(ctx context.Context) int64 {
+	if q.closed.Swap(true) {
+		<-q.done
+		return 0
+	}
+	close(q.quit)
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		close(q.abort)
+		<-q.done
+	}
+
+	var left int64
+	// records given up on stay in the spill file for the next process
+	for _, lane := range []chan []byte{q.urgent, q.ch} {
+		for drained := lane == nil; !drained; {
+			select {
+			case p := <-lane:
+				q.queued.Add(-int64(len(p)))
+				q.drop(p, "shutdown")
+				left += int64(len(p))
+			default:
+				drained = true
+			}
+		}
+	}
+	if q.overflow != nil {
+		records, n := q.overflow.discard()
+		q.dropRecords(records, n, "shutdown")
+		left += n
+		if err := q.overflow.close(); err != nil {
+			q.rf.logf("closing overflow file: %w", err)
+		}
+	}
+	q.rf.mu.Lock()
+	q.summarize(true)
+	q.rf.mu.Unlock()
+	if q.spill != nil {
+		if err := q.spill.close(); err != nil {
+			q.rf.logf("closing spill file: %w", err)
+		}
+	}
+	if d := q.dropped.Swap(0); d > 0 {
+		q.rf.mu.Lock()
+		q.rf.lose(int(d))
+		q.rf.mu.Unlock()
+	}
+	return left
+}
@@ -0,0 +1,217 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+	"github.com/mendsley/rollinglog/rollinglogtest"
+)
+
+// A MemFS whose files hold every write until gate is closed, to fill up
+// an Async queue. Writes are signalled on entered as they start.
+type gatedFS struct {
+	*rollinglogtest.MemFS
+	gate    chan struct{}
+	entered chan struct{}
+}
+
+func (fsys gatedFS) OpenFile(name string, flag int, perm os.FileMode) (rollinglog.File, error) {
+	f, err := fsys.MemFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return gatedFile{f, fsys}, nil
+}
+
+type gatedFile struct {
+	rollinglog.File
+	fsys gatedFS
+}
+
+func (f gatedFile) Write(p []byte) (int, error) {
+	select {
+	case f.fsys.entered <- struct{}{}:
+	default:
+	}
+	<-f.fsys.gate
+	return f.File.Write(p)
+}
+
+func TestAsyncPriority(t *testing.T) {
+	fsys := gatedFS{rollinglogtest.NewMemFS(), make(chan struct{}), make(chan struct{}, 1)}
+	w := rollinglog.NewMust(rollinglog.Config{
+		FilepathPattern: "logs/{2006-01-02}.log",
+		FS:              fsys,
+		Async:           true,
+		RealTimeBuffer:  20,
+		AsyncPriority: func(p []byte) bool {
+			return rollinglog.ClassifyLevel(p) >= slog.LevelError
+		},
+	})
+	io.WriteString(w, "bulk 1\n")
+	select {
+	case <-fsys.entered: // held in the write to the file
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queue is not written out")
+	}
+	io.WriteString(w, "bulk 2\n")
+	io.WriteString(w, "bulk 3\n")
+	io.WriteString(w, "bulk 4\n") // the queue is full
+	io.WriteString(w, "ERROR x\n")
+	close(fsys.gate)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := fsys.Files()[0]
+	rollinglogtest.ExpectFile(t, fsys.MemFS, name, "bulk 1\nERROR x\nbulk 3\n")
+	if dropped := w.Stats().Dropped; dropped != 2 {
+		t.Errorf("dropped %d records, want 2", dropped)
+	}
+}
+
+func TestAsyncOverflowSpill(t *testing.T) {
+	fsys := gatedFS{rollinglogtest.NewMemFS(), make(chan struct{}), make(chan struct{}, 1)}
+	overflow := filepath.Join(t.TempDir(), "overflow")
+	w := rollinglog.NewMust(rollinglog.Config{
+		FilepathPattern:   "logs/{2006-01-02}.log",
+		FS:                fsys,
+		Async:             true,
+		AsyncQueue:        2,
+		AsyncOverflow:     rollinglog.OverflowSpill,
+		AsyncOverflowFile: overflow,
+	})
+	var want strings.Builder
+	line := func(i int) {
+		s := fmt.Sprintf("record %d\n", i)
+		io.WriteString(w, s)
+		want.WriteString(s)
+	}
+	line(0)
+	<-fsys.entered
+	for i := 1; i < 100; i++ {
+		line(i)
+	}
+	// Stats would wait for the write held up
+	if fi, err := os.Stat(overflow); err != nil || fi.Size() == 0 {
+		t.Errorf("nothing went to the overflow file: %v", err)
+	}
+	close(fsys.gate)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rollinglogtest.ExpectFile(t, fsys.MemFS, fsys.Files()[0], want.String())
+	if s := w.Stats(); s.Overflowed != 0 || s.Dropped != 0 {
+		t.Errorf("after Close: %d records in the overflow file and %d dropped", s.Overflowed, s.Dropped)
+	}
+	if _, err := os.Stat(overflow); !os.IsNotExist(err) {
+		t.Errorf("the overflow file was left behind: %v", err)
+	}
+}
+
+func TestAsyncDropSummary(t *testing.T) {
+	fsys := gatedFS{rollinglogtest.NewMemFS(), make(chan struct{}), make(chan struct{}, 1)}
+	w := rollinglog.NewMust(rollinglog.Config{
+		FilepathPattern: "logs/{2006-01-02}.log",
+		FS:              fsys,
+		Async:           true,
+		AsyncQueue:      2,
+		DropSummary:     time.Hour,
+	})
+	io.WriteString(w, "bulk 1\n")
+	<-fsys.entered
+	io.WriteString(w, "bulk 2\n")
+	io.WriteString(w, "bulk 3\n")
+	io.WriteString(w, "bulk 4\n") // the queue is full
+	close(fsys.gate)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fsys.ReadFile(fsys.Files()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) != 5 || string(bytes.Join(lines[:3], nil)) != "bulk 1\nbulk 2\nbulk 3\n" {
+		t.Fatalf("got %q", data)
+	}
+	if m := summaryLine.FindSubmatch(lines[3]); m == nil || string(m[1]) != "1 record" || string(m[3]) != "queue full 1" {
+		t.Errorf("got summary %q", lines[3])
+	}
+}
+
+func TestAsyncDropSummaryWhenQuiet(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := rollinglogtest.NewClock(start)
+	fsys := gatedFS{rollinglogtest.NewMemFS(), make(chan struct{}), make(chan struct{}, 1)}
+	config := rollinglog.Config{
+		FilepathPattern: "logs/{2006-01-02}.log",
+		FS:              fsys,
+		Async:           true,
+		AsyncQueue:      2,
+		DropSummary:     time.Minute,
+	}
+	rec := rollinglogtest.Record(&config, clock)
+	w := rollinglog.NewMust(config)
+	defer w.Close()
+	io.WriteString(w, "bulk 1\n")
+	<-fsys.entered
+	io.WriteString(w, "bulk 2\n")
+	io.WriteString(w, "bulk 3\n")
+	io.WriteString(w, "bulk 4\n") // the queue is full
+	close(fsys.gate)
+	for {
+		if data, _ := fsys.ReadFile("logs/2024-01-01.log"); bytes.HasSuffix(data, []byte("bulk 3\n")) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// no more records come to carry the summary
+	rollinglogtest.ExpectNoRotationUntil(t, rec, start.Add(time.Minute))
+	data, err := fsys.ReadFile("logs/2024-01-01.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) != 5 {
+		t.Fatalf("got %q before Close", data)
+	}
+	if m := summaryLine.FindSubmatch(lines[3]); m == nil || string(m[1]) != "1 record" {
+		t.Errorf("got summary %q", lines[3])
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// A Backoff says how work that failed in the background is retried: the
+// wait after the first failure is Base, doubling with each further failure
+// up to Ceiling (no limit if zero). Jitter, from 0 to 1, is the fraction
+// of each wait that is randomized, so that writers that failed together do
+// not retry together. After MaxAttempts attempts in all the work is given
+// up; zero retries without limit. A Backoff with no Base is not used.
+type Backoff struct {
+	MaxAttempts int           `json:"max_attempts" yaml:"max_attempts"`
+	Base        time.Duration `json:"base" yaml:"base"`
+	Ceiling     time.Duration `json:"ceiling" yaml:"ceiling"`
+	Jitter      float64       `json:"jitter" yaml:"jitter"`
+}
+
+// Reports whether the policy is set.
+func (b *Backoff) enabled() bool {
+	return b.Base > 0
+}
+
+// Delay returns how long to wait after failed attempt n, counting from 1,
+// before the next one; false means attempt n was the last.
+func (b *Backoff) Delay(n int) (time.Duration, bool) {
+	if !b.enabled() || (b.MaxAttempts > 0 && n >= b.MaxAttempts) {
+		return 0, false
+	}
+	d := b.Base
+	for i := 1; i < n && (b.Ceiling == 0 || d < b.Ceiling) && d < time.Duration(1)<<61; i++ {
+		d *= 2
+	}
+	if b.Ceiling > 0 && d > b.Ceiling {
+		d = b.Ceiling
+	}
+	if b.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * b.Jitter * float64(d))
+	}
+	return d, true
+}
+
+// Retry fn, whose first attempt failed with err, until it succeeds or the
+// policy gives up, returning the last error. Waiting ends early if ctx or
+// quit is done.
+func (b *Backoff) retry(ctx context.Context, quit <-chan struct{}, err error, fn func() error) error {
+	for n := 1; err != nil; n++ {
+		d, ok := b.Delay(n)
+		if !ok {
+			return err
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-quit:
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		err = fn()
+	}
+	return nil
+}
+
+// Report an error for a policy that cannot be used.
+func (b *Backoff) validate(name string) error {
+	if b.MaxAttempts < 0 || b.Base < 0 || b.Ceiling < 0 {
+		return errors.New("rollinglog: " + name + " must not have negative fields")
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return errors.New("rollinglog: " + name + ".Jitter must be between 0 and 1")
+	}
+	return nil
+}
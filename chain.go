@@ -0,0 +1,195 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Suffix of the sidecar file holding a log file's HMAC chain.
+const chainSuffix = ".hmac"
+
+// ErrChainOpen is returned by VerifyHMAC for a file whose chain was never
+// closed: it is still being written, or the writer stopped without Close.
+var ErrChainOpen = errors.New("rollinglog: HMAC chain is not closed")
+
+// The HMAC chain of an open log file, written to path.hmac as lines of
+//
+//	open <offset> <seed>     a writer began appending at offset
+//	<end> <mac>              the record ending at end
+//	close <end> <mac>        the writer closed the file
+//
+// Each mac covers the previous one, the end offset and the bytes of the
+// record, so editing, removing or reordering records breaks the chain.
+type hmacChain struct {
+	key  []byte
+	side *os.File
+	mac  []byte
+	end  int64
+}
+
+// Start a chain segment for f, newly opened at p.
+func openChain(key []byte, f *os.File, p string, mode os.FileMode) (*hmacChain, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	side, err := os.OpenFile(p+chainSuffix, os.O_CREATE|os.O_APPEND|os.O_WRONLY, mode)
+	if err != nil {
+		return nil, err
+	}
+	c := &hmacChain{key: key, side: side, mac: chainSeed(key, p), end: fi.Size()}
+	if _, err := fmt.Fprintf(side, "open %d %x\n", c.end, c.mac); err != nil {
+		side.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Record p, just written to the file.
+func (c *hmacChain) add(p []byte) error {
+	c.end += int64(len(p))
+	c.mac = chainMAC(c.key, c.mac, c.end, "", p)
+	_, err := fmt.Fprintf(c.side, "%d %x\n", c.end, c.mac)
+	return err
+}
+
+func (c *hmacChain) close() error {
+	c.mac = chainMAC(c.key, c.mac, c.end, "close", nil)
+	_, err := fmt.Fprintf(c.side, "close %d %x\n", c.end, c.mac)
+	if cerr := c.side.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// The first mac of a chain binds it to the file's name.
+func chainSeed(key []byte, p string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("rollinglog chain " + filepath.Base(p)))
+	return h.Sum(nil)
+}
+
+func chainMAC(key, prev []byte, end int64, label string, p []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(prev)
+	binary.Write(h, binary.BigEndian, end)
+	h.Write([]byte(label))
+	h.Write(p)
+	return h.Sum(nil)
+}
+
+// VerifyHMAC checks the log file at path against the HMAC chain written
+// alongside it with Config.HMACKey, returning the final mac. Every byte of
+// the file must be covered by the chain. A file that verifies up to the
+// end but whose chain was not closed returns the mac so far with
+// ErrChainOpen.
+func VerifyHMAC(path string, key []byte) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	side, err := os.Open(path + chainSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer side.Close()
+
+	var mac []byte
+	var end int64
+	closed := true
+	scanner := bufio.NewScanner(side)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		bad := func(format string, args ...interface{}) error {
+			return fmt.Errorf("rollinglog: %s%s:%d: %s", path, chainSuffix, line, fmt.Sprintf(format, args...))
+		}
+		label := ""
+		if len(fields) == 3 {
+			label, fields = fields[0], fields[1:]
+		}
+		if len(fields) != 2 {
+			return nil, bad("malformed line")
+		}
+		off, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, bad("malformed offset")
+		}
+		want, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, bad("malformed mac")
+		}
+
+		switch label {
+		case "open":
+			if off != end {
+				return nil, bad("segment starts at offset %d, chain covers %d bytes", off, end)
+			}
+			if !hmac.Equal(want, chainSeed(key, path)) {
+				return nil, bad("chain does not belong to %s or the key is wrong", filepath.Base(path))
+			}
+			mac, closed = want, false
+		case "close":
+			if closed || off != end {
+				return nil, bad("unexpected close")
+			}
+			mac, closed = chainMAC(key, mac, end, "close", nil), true
+			if !hmac.Equal(want, mac) {
+				return nil, bad("close record does not match")
+			}
+		case "":
+			if closed || off < end || off > int64(len(data)) {
+				return nil, bad("record end %d out of range", off)
+			}
+			mac = chainMAC(key, mac, off, "", data[end:off])
+			if !hmac.Equal(want, mac) {
+				return nil, bad("record at offset %d has been modified", end)
+			}
+			end = off
+		default:
+			return nil, bad("unknown record %q", label)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if end != int64(len(data)) {
+		return nil, fmt.Errorf("rollinglog: %s: %d bytes after offset %d are not in the chain", path, int64(len(data))-end, end)
+	}
+	if !closed {
+		return mac, ErrChainOpen
+	}
+	return mac, nil
+}
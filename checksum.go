@@ -0,0 +1,274 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default Config.ChecksumAlgorithm.
+const defaultChecksum = "sha256"
+
+// ErrChecksumMismatch is returned by Verify for a file that no longer
+// matches its checksum.
+var ErrChecksumMismatch = errors.New("rollinglog: checksum mismatch")
+
+// The hashes Config.ChecksumAlgorithm can name.
+var checksums = struct {
+	sync.RWMutex
+	hashes map[string]func() hash.Hash
+}{hashes: map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"xxh64":  newXXH64,
+}}
+
+// RegisterChecksum makes newHash available to Config.ChecksumAlgorithm as
+// name, such as BLAKE3 from a third-party package as "b3". Sidecars are
+// named path.name and are checked by Verify like the others. The name must
+// be a lower case word not already registered.
+func RegisterChecksum(name string, newHash func() hash.Hash) error {
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+		return fmt.Errorf("rollinglog: invalid checksum name %q", name)
+	}
+	checksums.Lock()
+	defer checksums.Unlock()
+	if _, ok := checksums.hashes[name]; ok {
+		return fmt.Errorf("rollinglog: checksum %q is already registered", name)
+	}
+	checksums.hashes[name] = newHash
+	return nil
+}
+
+// The hash named by a Config.ChecksumAlgorithm, if it is registered.
+func checksumHash(name string) (func() hash.Hash, bool) {
+	if name == "" {
+		name = defaultChecksum
+	}
+	checksums.RLock()
+	defer checksums.RUnlock()
+	newHash, ok := checksums.hashes[name]
+	return newHash, ok
+}
+
+// The names of the registered checksums, sorted.
+func checksumNames() []string {
+	checksums.RLock()
+	defer checksums.RUnlock()
+	names := make([]string, 0, len(checksums.hashes))
+	for name := range checksums.hashes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// The suffixes of the checksum sidecars a log file may have.
+func checksumSuffixes() []string {
+	names := checksumNames()
+	for i, name := range names {
+		names[i] = "." + name
+	}
+	return names
+}
+
+// Write the checksum sidecar of the finished log file p with the named
+// algorithm, in the format of sha256sum so that "sha256sum -c" and the
+// like can check it too.
+func writeChecksum(p, algorithm string, mode os.FileMode) error {
+	if algorithm == "" {
+		algorithm = defaultChecksum
+	}
+	newHash, ok := checksumHash(algorithm)
+	if !ok {
+		return fmt.Errorf("rollinglog: unknown checksum %q", algorithm)
+	}
+	sum, err := fileSum(p, newHash)
+	if err != nil {
+		return err
+	}
+	return saveChecksum(p, algorithm, hex.EncodeToString(sum), mode)
+}
+
+// Replace the checksum sidecar of p for algorithm with one holding sum.
+func saveChecksum(p, algorithm, sum string, mode os.FileMode) error {
+	side := p + "." + algorithm
+	tmp := side + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sum+"  "+filepath.Base(p)+"\n"), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, side); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// The algorithm of the checksum sidecar of p, or "" if it has none.
+func checksumOf(p string) string {
+	for _, name := range checksumNames() {
+		if _, err := os.Lstat(p + "." + name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// Replace the checksum of src, if it has one, with one for dst, which holds
+// the same log in another form, such as compressed.
+func refreshChecksum(src, dst string, mode os.FileMode) error {
+	algorithm := checksumOf(src)
+	if algorithm == "" {
+		return nil
+	}
+	if err := writeChecksum(dst, algorithm, mode); err != nil {
+		return err
+	}
+	return os.Remove(src + "." + algorithm)
+}
+
+// Rewrite the file name recorded in the checksum sidecar of p, if any,
+// after p has been renamed.
+func relabelChecksum(p string) error {
+	algorithm := checksumOf(p)
+	if algorithm == "" {
+		return nil
+	}
+	side := p + "." + algorithm
+	line, err := os.ReadFile(side)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) != 2 || fields[1] == filepath.Base(p) {
+		return nil
+	}
+	fi, err := os.Stat(side)
+	if err != nil {
+		return err
+	}
+	return saveChecksum(p, algorithm, fields[0], fi.Mode().Perm())
+}
+
+func fileSHA256(p string) ([]byte, error) {
+	return fileSum(p, sha256.New)
+}
+
+func fileSum(p string, newHash func() hash.Hash) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Verify checks the finished log file at path against the checksum
+// sidecar written with Config.Checksum, whichever its algorithm, returning
+// ErrChecksumMismatch if the contents have changed.
+func Verify(path string) error {
+	algorithm := checksumOf(path)
+	if algorithm == "" {
+		algorithm = defaultChecksum // for the error opening it
+	}
+	side := path + "." + algorithm
+	f, err := os.Open(side)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return fmt.Errorf("rollinglog: %s: malformed checksum", side)
+	}
+	want, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return fmt.Errorf("rollinglog: %s: malformed checksum", side)
+	}
+
+	newHash, ok := checksumHash(algorithm)
+	if !ok {
+		return fmt.Errorf("rollinglog: unknown checksum %q", algorithm)
+	}
+	sum, err := fileSum(path, newHash)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(sum) != hex.EncodeToString(want) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// A VerifyResult is the outcome of checking one file with VerifyRange.
+type VerifyResult struct {
+	Path string
+	Err  error // nil if the file matches its checksum
+}
+
+// VerifyRange checks the files of config's log for the periods between
+// from and to, inclusive, as Open selects them, against their checksum
+// sidecars, for periodic audits of the files kept. It returns the outcome
+// for each file that has a sidecar, oldest first; files without one, such
+// as the active file, are left out.
+func VerifyRange(config Config, from, to time.Time) ([]VerifyResult, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	files, err := exportFiles(l, from, to)
+	if err != nil {
+		return nil, err
+	}
+	var results []VerifyResult
+	for _, p := range files {
+		if checksumOf(p) == "" {
+			continue
+		}
+		results = append(results, VerifyResult{p, Verify(p)})
+	}
+	return results, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXXH64(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"", "ef46db3751d8e999"},
+		{"a", "d24ec4f1a98c6e5b"},
+		{"abc", "44bc2cf5ad770999"},
+		{"hello, world", "b33a384e6d1b1242"},
+		{"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$", "1032d841e824f998"},
+	} {
+		h := newXXH64()
+		h.Write([]byte(tc.in))
+		if got := hex.EncodeToString(h.Sum(nil)); got != tc.want {
+			t.Errorf("xxh64(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+
+	// the same however the input is split
+	in := []byte(strings.Repeat("0123456789abcdef", 9) + "tail")
+	whole := newXXH64()
+	whole.Write(in)
+	for _, split := range []int{1, 7, 31, 32, 33} {
+		h := newXXH64()
+		for p := in; len(p) > 0; {
+			n := min(split, len(p))
+			h.Write(p[:n])
+			p = p[n:]
+		}
+		if got, want := hex.EncodeToString(h.Sum(nil)), hex.EncodeToString(whole.Sum(nil)); got != want {
+			t.Errorf("written %d bytes at a time: got %s, want %s", split, got, want)
+		}
+	}
+}
+
+func TestVerifyRange(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{FilepathPattern: filepath.Join(dir, "{2006-01-02}.log"), ChecksumAlgorithm: "xxh64"}
+	days := []time.Time{
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local),
+		time.Date(2024, 3, 2, 0, 0, 0, 0, time.Local),
+		time.Date(2024, 3, 3, 0, 0, 0, 0, time.Local),
+	}
+	var paths []string
+	for _, day := range days {
+		p := filepath.Join(dir, day.Format("2006-01-02")+".log")
+		if err := os.WriteFile(p, []byte(day.Format(time.DateOnly)+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeChecksum(p, config.ChecksumAlgorithm, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	if err := os.WriteFile(paths[1], []byte("tampered\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := VerifyRange(config, days[0], days[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, r := range results {
+		if r.Path != paths[i] {
+			t.Errorf("result %d is for %s, want %s", i, r.Path, paths[i])
+		}
+		if want := i == 1; (r.Err == ErrChecksumMismatch) != want || !want && r.Err != nil {
+			t.Errorf("%s: got %v", r.Path, r.Err)
+		}
+	}
+}
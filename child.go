@@ -0,0 +1,98 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"sync"
+)
+
+// WrapCmd sends the standard output and error of cmd, which must not have
+// been started, to a rolling log created from config. Unlike handing the
+// child a file from Writer.ActiveFile, the output goes through pipes that
+// the exec package copies into the writer, so it follows the log across
+// rotations. Each stream is written a line at a time, so lines from the
+// two are never mixed. Close the writer once cmd.Wait has returned, which
+// also writes out a last line without a newline.
+func WrapCmd(cmd *exec.Cmd, config Config) (*Writer, error) {
+	if cmd.Process != nil {
+		return nil, errors.New("rollinglog: WrapCmd: command already started")
+	}
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return nil, errors.New("rollinglog: WrapCmd: Stdout or Stderr already set")
+	}
+	rf, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := &lineWriter{w: rf}, &lineWriter{w: rf}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	rf.atClose = append(rf.atClose, stdout.flush, stderr.flush)
+	return rf, nil
+}
+
+// A lineWriter passes whole lines to w, keeping a partial line back
+// until the rest of it arrives.
+type lineWriter struct {
+	w *Writer
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	i := bytes.LastIndexByte(p, '\n')
+	if i == -1 {
+		if len(lw.partial)+len(p) < maxFilterBuf {
+			lw.partial = append(lw.partial, p...)
+			return len(p), nil
+		}
+		i = len(p) - 1 // too long to hold back any more
+	}
+	lines := p[:i+1]
+	if len(lw.partial) > 0 {
+		lines = append(lw.partial, lines...)
+	}
+	_, err := lw.w.Write(lines)
+	lw.partial = append(lw.partial[:0], p[i+1:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Write out the partial line left by a child that has exited.
+func (lw *lineWriter) flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.partial) > 0 {
+		lw.w.Write(lw.partial)
+		lw.partial = lw.partial[:0]
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Alternatives asked of Config.Resolver for one file before giving up.
+const maxResolved = 100
+
+// With Config.Disambiguate, make sure no other process writes f, just
+// opened at p with flags, by locking it. If another process holds the
+// lock, f is closed and the writer's own instance file beside it is
+// opened instead, or the file Config.Resolver picks. The lock taken is
+// returned with the file, nil if the writer already holds it, for install
+// to keep in place of the lock of the file it replaces.
+func (rf *Writer) claim(f File, p string, flags int) (File, string, *os.File, error) {
+	lock, ok, err := rf.lockActive(f)
+	if err != nil {
+		f.Close()
+		return nil, "", nil, err
+	}
+	if ok {
+		return f, p, lock, nil
+	}
+	f.Close()
+	resolve := rf.config.Resolver
+	base := instancePath(p, rf.instanceID())
+	for tries := 1; ; tries++ {
+		if resolve != nil {
+			if base = resolve(p); base == "" || base == p {
+				return nil, "", nil, fmt.Errorf("rollinglog: Resolver gave no alternative to %s", p)
+			}
+			if err := rf.mkdirAll(path.Dir(base)); err != nil {
+				return nil, "", nil, err
+			}
+		}
+		q := base
+		f, err = rf.openLog(q, flags)
+		for seq := 1; flags&os.O_EXCL != 0 && os.IsExist(err); seq++ {
+			q = sequencePath(base, seq)
+			f, err = rf.openLog(q, flags)
+		}
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if lock, ok, err = rf.lockActive(f); err != nil {
+			f.Close()
+			return nil, "", nil, err
+		} else if ok {
+			return f, q, lock, nil
+		}
+		f.Close()
+		if resolve == nil || tries == maxResolved {
+			return nil, "", nil, fmt.Errorf("rollinglog: %s is locked by another process", q)
+		}
+		p = q // ask again about the alternative
+	}
+}
+
+// Take a lock on the file f refers to, on a descriptor of its own,
+// reporting false if another process holds it. A file whose lock the
+// writer already holds is ours as well, and returns no new descriptor.
+func (rf *Writer) lockActive(f File) (*os.File, bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	rf.mu.Lock()
+	held := rf.claimed
+	rf.mu.Unlock()
+	if held != nil {
+		if hi, err := held.Stat(); err == nil && os.SameFile(fi, hi) {
+			return nil, true, nil
+		}
+	}
+
+	lf, err := os.Open(f.Name())
+	if err != nil {
+		return nil, false, err
+	}
+	if err := lockFile(lf, false); err != nil {
+		lf.Close()
+		if err == errLocked {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return lf, true, nil
+}
+
+// The id put into the names of instance files: Config.InstanceID, or the
+// host name and process id.
+func (rf *Writer) instanceID() string {
+	if id := rf.config.InstanceID; id != "" {
+		return id
+	}
+	return rf.layout.ph.hostname + "-" + strconv.Itoa(rf.layout.ph.pid)
+}
+
+// The instance file for p, with id before its extension: app.log becomes
+// app.web1-1234.log.
+func instancePath(p, id string) string {
+	ext := path.Ext(p)
+	if strings.ContainsRune(ext, '/') {
+		ext = ""
+	}
+	return p[:len(p)-len(ext)] + "." + id + ext
+}
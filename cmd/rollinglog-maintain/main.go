@@ -0,0 +1,118 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Command rollinglog-maintain applies retention and compression policies
+// to the log files produced by a rollinglog pattern, for programs that do
+// not run long enough to maintain their own logs. It is typically run
+// from cron.
+//
+//	rollinglog-maintain -config logging.yaml
+//	rollinglog-maintain -pattern 'logs/{2006-01-02}.log' -max-files 30 -n
+//
+// Flags override the corresponding settings of -config. Each removal or
+// compression is printed; with -n or -dry-run nothing is changed, and the
+// next rotation of the active file is printed with when it is due.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("rollinglog-maintain: ")
+	configPath := flag.String("config", "", "read the rollinglog `file` (JSON or YAML) for the pattern and policies")
+	pattern := flag.String("pattern", "", "FilepathPattern of the logs")
+	numbered := flag.Bool("numbered", false, "the logs use numbered rollover")
+	strftime := flag.Bool("strftime", false, "the pattern uses strftime syntax")
+	maxFiles := flag.Int("max-files", 0, "keep at most `n` files")
+	maxTotal := flag.Int64("max-total-bytes", 0, "keep at most `n` bytes of files")
+	maxBackups := flag.Int("max-backups", 0, "keep at most `n` numbered backups")
+	compressFrom := flag.Int("compress-from", 0, "compress numbered backups from `n` up")
+	dryRun := flag.Bool("n", false, "print what would be done without doing it")
+	flag.BoolVar(dryRun, "dry-run", false, "same as -n")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: rollinglog-maintain [-config file | -pattern pattern] [flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 || (*configPath == "" && *pattern == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var config rollinglog.Config
+	if *configPath != "" {
+		var err error
+		if config, err = rollinglog.ConfigFromFile(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pattern":
+			config.FilepathPattern = *pattern
+		case "numbered":
+			if *numbered {
+				config.Rollover = rollinglog.RolloverNumbered
+			}
+		case "strftime":
+			if *strftime {
+				config.PatternSyntax = rollinglog.SyntaxStrftime
+			}
+		case "max-files":
+			config.MaxFiles = *maxFiles
+		case "max-total-bytes":
+			config.MaxTotalBytes = *maxTotal
+		case "max-backups":
+			config.MaxBackups = *maxBackups
+		case "compress-from":
+			config.CompressFrom = *compressFrom
+		}
+	})
+
+	var actions []rollinglog.MaintainAction
+	var err error
+	if *dryRun {
+		actions, err = rollinglog.Plan(config)
+	} else {
+		actions, err = rollinglog.Maintain(config, false)
+	}
+	for _, a := range actions {
+		if a.Op == "rotate" {
+			fmt.Printf("%s %s at %s\n", a.Op, a.Path, a.At.Format(time.RFC3339))
+			continue
+		}
+		fmt.Printf("%s %s\n", a.Op, a.Path)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
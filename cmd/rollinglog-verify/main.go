@@ -0,0 +1,140 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Command rollinglog-verify checks the log files produced by a rollinglog
+// pattern over a time range against their checksum sidecars, printing one
+// line per file, and exits with status 1 if any file fails.
+//
+//	rollinglog-verify -pattern 'logs/{2006-01-02}/app.log' -from 2024-03-01 -to 2024-03-07
+//	rollinglog-verify -config logging.yaml -from 720h -q
+//
+// Times are RFC 3339, or a date with an optional "15:04" or "15:04:05" time
+// in local time, or a duration counted back from now. The range defaults
+// to the start of today through now.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("rollinglog-verify: ")
+	configPath := flag.String("config", "", "read the rollinglog `file` (JSON or YAML) for the pattern and rollover settings")
+	pattern := flag.String("pattern", "", "FilepathPattern of the logs, overriding -config")
+	numbered := flag.Bool("numbered", false, "the logs use numbered rollover")
+	strftime := flag.Bool("strftime", false, "the pattern uses strftime syntax")
+	fromFlag := flag.String("from", "", "start of the range")
+	toFlag := flag.String("to", "", "end of the range")
+	quiet := flag.Bool("q", false, "print only the files that fail")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: rollinglog-verify [-config file | -pattern pattern] [-from time] [-to time] [-q]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 || (*configPath == "" && *pattern == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var config rollinglog.Config
+	if *configPath != "" {
+		var err error
+		if config, err = rollinglog.ConfigFromFile(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *pattern != "" {
+		config.FilepathPattern = *pattern
+	}
+	if *numbered {
+		config.Rollover = rollinglog.RolloverNumbered
+	}
+	if *strftime {
+		config.PatternSyntax = rollinglog.SyntaxStrftime
+	}
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	to := now
+	if *fromFlag != "" {
+		var err error
+		if from, err = parseTime(*fromFlag, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *toFlag != "" {
+		var err error
+		if to, err = parseTime(*toFlag, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	results, err := rollinglog.VerifyRange(config, from, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := false
+	for _, r := range results {
+		if r.Err != nil {
+			failed = true
+			fmt.Printf("%s: FAILED (%v)\n", r.Path, r.Err)
+		} else if !*quiet {
+			fmt.Printf("%s: OK\n", r.Path)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+var timeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Parse a -from or -to value.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
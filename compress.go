@@ -0,0 +1,170 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Suffix of the temporary file a compression is written to before it is
+// renamed into place.
+const compressTempSuffix = ".gz.tmp"
+
+// Replace src with src.gz, compressed at the gzip level given; 0 is the
+// default level.
+func compressFile(src string, mode os.FileMode, level int) error {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := src + compressTempSuffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	zw, err := gzip.NewWriterLevel(out, level)
+	if err == nil {
+		_, err = io.Copy(zw, in)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, src+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// A bounded pool compressing files in the background, with
+// Config.CompressWorkers and CompressLevel, retrying as Config.Retry says.
+// Writers of a pooled Scheduler share its workers instead.
+type compressor struct {
+	level  int
+	retry  Backoff
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	queued atomic.Int64 // compressions waiting or under way
+	report func(error)  // for failures in the background, if not log
+
+	quit     chan struct{} // closed to abandon retries
+	quitOnce sync.Once
+}
+
+func newCompressor(config *Config) *compressor {
+	sem := config.workerPool()
+	if sem == nil {
+		workers := config.CompressWorkers
+		if workers <= 0 {
+			workers = 1
+		}
+		sem = make(chan struct{}, workers)
+	}
+	return &compressor{level: config.CompressLevel, retry: config.Retry, sem: sem, quit: make(chan struct{})}
+}
+
+// Compress each of paths with compressBackup as a worker comes free,
+// reporting any failure.
+func (c *compressor) start(paths []string, mode os.FileMode) {
+	for _, p := range paths {
+		c.do(p, mode, func(err error) {
+			if err == nil {
+				return
+			}
+			err = fmt.Errorf("rollinglog: compressing %s: %w", p, err)
+			if c.report != nil {
+				c.report(err)
+			} else {
+				log.Print(err)
+			}
+		})
+	}
+}
+
+// Compress each of paths and wait for them, returning their errors.
+func (c *compressor) run(paths []string, mode os.FileMode) []error {
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, p := range paths {
+		wg.Add(1)
+		c.do(p, mode, func(err error) {
+			errs[i] = err
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	return errs
+}
+
+func (c *compressor) do(p string, mode os.FileMode, done func(error)) {
+	c.wg.Add(1)
+	c.queued.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.queued.Add(-1)
+		c.sem <- struct{}{}
+		compress := func() error { return compressBackup(p, mode, c.level) }
+		err := compress()
+		<-c.sem
+		err = c.retry.retry(context.Background(), c.quit, err, func() error {
+			c.sem <- struct{}{}
+			defer func() { <-c.sem }()
+			return compress()
+		})
+		done(err)
+	}()
+}
+
+// Wait for every compression under way.
+func (c *compressor) wait() {
+	c.wg.Wait()
+}
+
+// Stop retrying failed compressions.
+func (c *compressor) abandon() {
+	c.quitOnce.Do(func() { close(c.quit) })
+}
@@ -0,0 +1,379 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFromFile loads a Config from a JSON (.json) or YAML (.yaml, .yml)
+// file. Keys are the json tags of the Config fields; fields holding
+// functions, writers and other interfaces have none and cannot be loaded.
+// The fields of Retry are nested under retry, as an object in JSON or an
+// indented block in YAML. Lists such as post_rotate_cmd and
+// pressure_levels are JSON arrays or YAML flow sequences ([a, b]). Other
+// YAML is limited to "key: value" lines. Numeric values accept a 0 prefix
+// for octal, so modes can be written as "0600", and durations use
+// time.ParseDuration syntax such as "90s".
+func ConfigFromFile(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		values, err = parseJSONValues(data)
+	case ".yaml", ".yml":
+		values, err = parseYAMLValues(data)
+	default:
+		return config, fmt.Errorf("rollinglog: unknown config file type %q", path)
+	}
+	if err != nil {
+		return config, fmt.Errorf("rollinglog: %s: %v", path, err)
+	}
+
+	if err := applyConfigValues(&config, values); err != nil {
+		return config, fmt.Errorf("rollinglog: %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// ConfigFromEnv loads a Config from environment variables. Each field is
+// read from prefix followed by its upper-cased json tag, so with a prefix of
+// "APP_LOG_" the file pattern comes from APP_LOG_FILEPATH_PATTERN and the
+// base delay of Retry from APP_LOG_RETRY_BASE. Lists are separated by
+// commas, as in APP_LOG_PRESSURE_LEVELS=0.5,0.9, or written as JSON
+// arrays. Unset variables leave the field at its zero value.
+func ConfigFromEnv(prefix string) (Config, error) {
+	var config Config
+	values := make(map[string]string)
+	for _, name := range configKeys() {
+		env := prefix + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+		if v, ok := os.LookupEnv(env); ok {
+			values[name] = v
+		}
+	}
+	if err := applyConfigValues(&config, values); err != nil {
+		return config, fmt.Errorf("rollinglog: environment: %v", err)
+	}
+	return config, nil
+}
+
+// Name of the configuration key for a Config field, or "" if the field
+// cannot be loaded from text.
+func configKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// Reports whether fields of type t are loaded key by key, as retry.base,
+// rather than from a single value.
+func nestedConfig(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func configKeys() []string {
+	var keys []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			key := configKey(t.Field(i))
+			if key == "" {
+				continue
+			}
+			if nestedConfig(t.Field(i).Type) {
+				walk(t.Field(i).Type, prefix+key+".")
+			} else {
+				keys = append(keys, prefix+key)
+			}
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return keys
+}
+
+func applyConfigValues(config *Config, values map[string]string) error {
+	for key := range values {
+		if !contains(configKeys(), key) {
+			return fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return applyStructValues(reflect.ValueOf(config).Elem(), "", values)
+}
+
+func applyStructValues(v reflect.Value, prefix string, values map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := configKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		key = prefix + key
+		if nestedConfig(t.Field(i).Type) {
+			if err := applyStructValues(v.Field(i), key+".", values); err != nil {
+				return err
+			}
+			continue
+		}
+		text, ok := values[key]
+		if !ok {
+			continue
+		}
+		if err := setConfigField(v.Field(i), text); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func setConfigField(field reflect.Value, text string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(text))
+	}
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(text, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(text, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		items, err := splitList(text)
+		if err != nil {
+			return err
+		}
+		list := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigField(list.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(list)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// Decode a JSON object, keeping each value as text. The keys of nested
+// objects are joined to their parent's with a dot; arrays are kept as JSON.
+func parseJSONValues(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	return values, addJSONValues(values, "", data)
+}
+
+func addJSONValues(values map[string]string, prefix string, data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for key, msg := range raw {
+		key = prefix + key
+		var s string
+		if err := json.Unmarshal(msg, &s); err == nil {
+			values[key] = s
+			continue
+		}
+		msg = bytes.TrimSpace(msg)
+		if len(msg) > 0 && msg[0] == '{' {
+			if err := addJSONValues(values, key+".", msg); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			continue
+		}
+		values[key] = string(msg)
+	}
+	return nil
+}
+
+// Decode a YAML mapping of "key: value" lines, in which a key without a
+// value starts a block of more deeply indented keys nested under it.
+func parseYAMLValues(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	type block struct {
+		indent int
+		prefix string
+	}
+	var blocks []block
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(raw)
+		if text == "" || text[0] == '#' || text == "---" {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " \t"))
+		for len(blocks) > 0 && indent <= blocks[len(blocks)-1].indent {
+			blocks = blocks[:len(blocks)-1]
+		}
+		prefix := ""
+		if len(blocks) > 0 {
+			prefix = blocks[len(blocks)-1].prefix
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line)
+		}
+		key, value = prefix+strings.TrimSpace(key), strings.TrimSpace(value)
+		value, err := yamlScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if value == "" {
+			blocks = append(blocks, block{indent, key + "."})
+			continue
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// Split a list value: a JSON array or YAML flow sequence, or in the
+// environment a comma-separated list.
+func splitList(text string) ([]string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	if text[0] != '[' {
+		items := strings.Split(text, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		return items, nil
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(text), &raw); err == nil {
+		items := make([]string, len(raw))
+		for i, msg := range raw {
+			if err := json.Unmarshal(msg, &items[i]); err != nil {
+				items[i] = string(bytes.TrimSpace(msg))
+			}
+		}
+		return items, nil
+	}
+	if text[len(text)-1] != ']' {
+		return nil, fmt.Errorf("unterminated list %s", text)
+	}
+	var items []string
+	for _, item := range strings.Split(text[1:len(text)-1], ",") {
+		item, err := yamlScalar(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Decode a single YAML scalar, removing quotes and trailing comments.
+func yamlScalar(value string) (string, error) {
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		if i := strings.Index(value, " #"); i != -1 {
+			value = strings.TrimSpace(value[:i])
+		}
+		return value, nil
+	}
+
+	quote := value[0]
+	end := 1
+	for ; end < len(value); end++ {
+		if value[end] == '\\' && quote == '"' {
+			end++
+		} else if value[end] == quote {
+			if quote == '\'' && end+1 < len(value) && value[end+1] == '\'' {
+				end++
+				continue
+			}
+			break
+		}
+	}
+	if end >= len(value) {
+		return "", fmt.Errorf("unterminated string %s", value)
+	}
+	if rest := strings.TrimSpace(value[end+1:]); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected text after string: %s", rest)
+	}
+	if quote == '"' {
+		return strconv.Unquote(value[:end+1])
+	}
+	return strings.ReplaceAll(value[1:end], "''", "'"), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
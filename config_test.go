@@ -0,0 +1,103 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigFromFileLists(t *testing.T) {
+	want := Config{
+		FilepathPattern: "logs/{2006-01-02}.log",
+		Retry:           Backoff{MaxAttempts: 5, Base: time.Second, Jitter: 0.25},
+		PostRotateCmd:   []string{"gzip", "-9"},
+		PressureLevels:  []float64{0.5, 0.9},
+	}
+	files := map[string]string{
+		"config.json": `{
+			"filepath_pattern": "logs/{2006-01-02}.log",
+			"retry": {"max_attempts": 5, "base": "1s", "jitter": 0.25},
+			"post_rotate_cmd": ["gzip", "-9"],
+			"pressure_levels": [0.5, 0.9]
+		}`,
+		"config.yaml": `
+filepath_pattern: "logs/{2006-01-02}.log"
+retry:
+  max_attempts: 5
+  base: 1s
+  jitter: 0.25
+post_rotate_cmd: [gzip, "-9"]
+pressure_levels: [0.5, 0.9]
+`,
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ConfigFromFile(p)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got.Retry, want.Retry) || !reflect.DeepEqual(got.PostRotateCmd, want.PostRotateCmd) ||
+			!reflect.DeepEqual(got.PressureLevels, want.PressureLevels) || got.FilepathPattern != want.FilepathPattern {
+			t.Errorf("%s: got %+v", name, got)
+		}
+	}
+}
+
+func TestConfigFromEnvLists(t *testing.T) {
+	t.Setenv("TEST_LOG_RETRY_BASE", "2s")
+	t.Setenv("TEST_LOG_POST_ROTATE_CMD", "gzip,-9")
+	t.Setenv("TEST_LOG_PRESSURE_LEVELS", "0.5, 0.9")
+	got, err := ConfigFromEnv("TEST_LOG_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Retry.Base != 2*time.Second {
+		t.Errorf("Retry.Base = %v", got.Retry.Base)
+	}
+	if !reflect.DeepEqual(got.PostRotateCmd, []string{"gzip", "-9"}) {
+		t.Errorf("PostRotateCmd = %q", got.PostRotateCmd)
+	}
+	if !reflect.DeepEqual(got.PressureLevels, []float64{0.5, 0.9}) {
+		t.Errorf("PressureLevels = %v", got.PressureLevels)
+	}
+}
+
+func TestConfigUnknownNestedKey(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(p, []byte(`{"retry": {"bogus": 1}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConfigFromFile(p); err == nil {
+		t.Fatal("unknown nested key accepted")
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A record linking rotated files with Config.Continuity, written as a
+// line of JSON.
+type continuityRecord struct {
+	Kind   string `json:"rollinglog"` // always "continuity"
+	Prev   string `json:"prev,omitempty"`
+	Size   int64  `json:"size,omitempty"`   // of prev, up to its closing records
+	SHA256 string `json:"sha256,omitempty"` // of those bytes of prev
+	Next   string `json:"next,omitempty"`
+}
+
+// How far into a file VerifyContinuity looks for the record of the file
+// before it, leaving room for a Header.
+const continuityScan = 64 << 10
+
+// Start hashing lf, just opened, with Config.Continuity, covering what it
+// already holds. The hash is left unset if the file cannot be read.
+func (rf *Writer) startSum(lf *logFile) {
+	sum := sha256.New()
+	if lf.size > 0 {
+		f, err := rf.fs.OpenFile(lf.Name(), os.O_RDONLY, 0)
+		if err != nil {
+			rf.logf("reading %s for continuity: %w", lf.Name(), err)
+			return
+		}
+		defer f.Close()
+		r, ok := f.(io.Reader)
+		if !ok {
+			rf.logf("reading %s for continuity: files of this Config.FS cannot be read back", lf.Name())
+			return
+		}
+		if _, err := io.CopyN(sum, r, lf.size); err != nil {
+			rf.logf("reading %s for continuity: %w", lf.Name(), err)
+			return
+		}
+	}
+	lf.sum = sum
+}
+
+// With Config.Continuity, follow the header of f, which replaces prev,
+// with a record of where prev ends. Called with rf.mu held, so nothing
+// reaches either file meanwhile.
+func (rf *Writer) linkFrom(f, prev *logFile) {
+	if !rf.config.Continuity {
+		return
+	}
+	rec := continuityRecord{Kind: "continuity", Prev: prev.Name(), Size: prev.size}
+	if prev.sum != nil {
+		rec.SHA256 = hex.EncodeToString(prev.sum.Sum(nil))
+	}
+	rf.writeContinuity(f, rec)
+}
+
+// With Config.Continuity, end prev, after any footer, with a record
+// naming next.
+func (rf *Writer) linkTo(prev *logFile, next string) {
+	if rf.config.Continuity {
+		rf.writeContinuity(prev, continuityRecord{Kind: "continuity", Next: next})
+	}
+}
+
+func (rf *Writer) writeContinuity(f *logFile, rec continuityRecord) {
+	rf.writeFrame(f, "continuity record", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(rec)
+	})
+}
+
+// VerifyContinuity checks that the files at paths, oldest first, written
+// with Config.Continuity, follow on from one another with nothing missing
+// or altered in between: each file after the first must start, after any
+// header, with a record of the size and checksum of the one before, and
+// each file before the last must end with a record naming the next. Files
+// are matched by base name, so copies can be checked wherever they have
+// been moved to, and files ending in .gz are read decompressed.
+func VerifyContinuity(paths ...string) error {
+	for i := 1; i < len(paths); i++ {
+		prev, next := paths[i-1], paths[i]
+		var rec continuityRecord
+		err := readLog(next, func(r io.Reader) error {
+			br := bufio.NewReader(io.LimitReader(r, continuityScan))
+			for {
+				line, err := br.ReadBytes('\n')
+				if parseContinuity(line, &rec) && rec.Prev != "" {
+					return nil
+				}
+				if err == io.EOF {
+					return fmt.Errorf("rollinglog: %s has no record of the file before it", next)
+				} else if err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if logBase(rec.Prev) != logBase(prev) {
+			return fmt.Errorf("rollinglog: %s follows %s, not %s", next, rec.Prev, prev)
+		}
+
+		err = readLog(prev, func(r io.Reader) error {
+			sum := sha256.New()
+			if n, err := io.CopyN(sum, r, rec.Size); err == io.EOF {
+				return fmt.Errorf("rollinglog: %s is %d bytes, short of the %d recorded in %s", prev, n, rec.Size, next)
+			} else if err != nil {
+				return err
+			}
+			if rec.SHA256 != "" && hex.EncodeToString(sum.Sum(nil)) != rec.SHA256 {
+				return fmt.Errorf("rollinglog: %s does not match the checksum recorded in %s", prev, next)
+			}
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			var last continuityRecord
+			rest = bytes.TrimSuffix(rest, []byte("\n"))
+			if !parseContinuity(rest[bytes.LastIndexByte(rest, '\n')+1:], &last) || last.Next == "" {
+				return fmt.Errorf("rollinglog: %s does not end with a record of the file after it", prev)
+			}
+			if logBase(last.Next) != logBase(next) {
+				return fmt.Errorf("rollinglog: %s is followed by %s, not %s", prev, last.Next, next)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode line into rec, reporting whether it is a continuity record.
+func parseContinuity(line []byte, rec *continuityRecord) bool {
+	*rec = continuityRecord{}
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte(`{"rollinglog":`)) {
+		return false
+	}
+	return json.Unmarshal(line, rec) == nil && rec.Kind == "continuity"
+}
+
+// The base name of a log file, without the suffix compression adds.
+func logBase(p string) string {
+	return strings.TrimSuffix(filepath.Base(p), ".gz")
+}
+
+// Call fn with the contents of the log file name, decompressed if it ends
+// in .gz.
+func readLog(name string, fn func(r io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return fn(r)
+}
@@ -0,0 +1,65 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// The writer whose file last became the crash output, if any.
+var (
+	crashMu     sync.Mutex
+	crashWriter *Writer
+)
+
+// With Config.CrashOutput, make f, just opened by rf, the file the runtime
+// writes fatal errors to.
+func (rf *Writer) setCrashOutput(f *os.File) {
+	if !rf.config.CrashOutput {
+		return
+	}
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		rf.logf("setting crash output: %w", err)
+		return
+	}
+	crashWriter = rf
+}
+
+// Stop sending crash output to rf's files, if it still is.
+func (rf *Writer) clearCrashOutput() {
+	if !rf.config.CrashOutput {
+		return
+	}
+	crashMu.Lock()
+	defer crashMu.Unlock()
+	if crashWriter == rf {
+		debug.SetCrashOutput(nil, debug.CrashOptions{})
+		crashWriter = nil
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// OpenMode selects what New does when the file for the current period
+// already exists, as after a restart within the same day.
+type OpenMode int
+
+const (
+	// OpenAppend appends to the existing file.
+	OpenAppend OpenMode = iota
+	// OpenTruncate empties the existing file and starts it afresh.
+	OpenTruncate
+	// OpenNewSequence leaves the existing file alone and starts the next
+	// free Dedupe sequence name, such as app-001.log.
+	OpenNewSequence
+)
+
+func (m OpenMode) MarshalText() ([]byte, error) {
+	switch m {
+	case OpenAppend:
+		return []byte("append"), nil
+	case OpenTruncate:
+		return []byte("truncate"), nil
+	case OpenNewSequence:
+		return []byte("new-sequence"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown open mode %d", int(m))
+}
+
+func (m *OpenMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "append", "":
+		*m = OpenAppend
+	case "truncate":
+		*m = OpenTruncate
+	case "new-sequence":
+		*m = OpenNewSequence
+	default:
+		return fmt.Errorf("rollinglog: unknown open mode %q", text)
+	}
+	return nil
+}
+
+// Resolve Config.Owner and Group to numeric IDs, -1 for those not set.
+func resolveOwner(owner, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if owner != "" {
+		if uid, err = strconv.Atoi(owner); err != nil {
+			u, lerr := user.Lookup(owner)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("rollinglog: Owner: %v", lerr)
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, fmt.Errorf("rollinglog: Owner %s has non-numeric uid %q", owner, u.Uid)
+			}
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, lerr := user.LookupGroup(group)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("rollinglog: Group: %v", lerr)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("rollinglog: Group %s has non-numeric gid %q", group, g.Gid)
+			}
+		}
+	}
+	return uid, gid, nil
+}
+
+// Reports whether files and directories need attention once created.
+func (rf *Writer) tendsCreated() bool {
+	return rf.uid != -1 || rf.gid != -1 || rf.config.StrictPerms || rf.config.DurableCreate
+}
+
+// Apply the ownership and permission settings to p, which the writer has
+// just created with mode. The mode is set after the owner, as chown may
+// clear the setuid and setgid bits.
+func (rf *Writer) created(p string, mode os.FileMode) {
+	if rf.uid != -1 || rf.gid != -1 {
+		if err := os.Chown(p, rf.uid, rf.gid); err != nil {
+			rf.logf("%w", err)
+		}
+	}
+	if rf.config.StrictPerms {
+		if err := os.Chmod(p, unixMode(mode)); err != nil {
+			rf.logf("%w", err)
+		}
+	}
+	if rf.config.DurableCreate {
+		if err := syncDir(filepath.Dir(p)); err != nil {
+			rf.logf("syncing directory of %s: %w", p, err)
+		}
+	}
+}
+
+// With Config.AppendOnly, mark f, just opened by rf, append-only.
+func (rf *Writer) setAppendOnly(f *os.File) {
+	if err := appendOnly(f); err != nil {
+		rf.logf("making %s append-only: %w", f.Name(), err)
+	}
+}
+
+// Flush the entries of dir to stable storage.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// directories cannot be opened for syncing
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Convert the special bits of a mode written as octal, such as the 02700
+// default DirMode, to the os.FileMode flags that os.Chmod understands.
+func unixMode(m os.FileMode) os.FileMode {
+	for bit, flag := range map[os.FileMode]os.FileMode{
+		0o4000: os.ModeSetuid,
+		0o2000: os.ModeSetgid,
+		0o1000: os.ModeSticky,
+	} {
+		if m&bit != 0 {
+			m = m&^bit | flag
+		}
+	}
+	return m
+}
+
+// Create dir and any missing parents with Config.DirMode, like
+// os.MkdirAll, tending to each directory created.
+func (rf *Writer) mkdirAll(dir string) error {
+	if !rf.tendsCreated() {
+		if err := rf.fs.MkdirAll(dir, rf.config.DirMode); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	}
+	if fi, err := os.Stat(dir); err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: fmt.Errorf("not a directory")}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := rf.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, rf.config.DirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	rf.created(dir, rf.config.DirMode)
+	return nil
+}
+
+// Open the log file p with flags, which include os.O_CREATE, Config.Mode
+// and Config.SyncWrites, tending to the file if this creates it.
+func (rf *Writer) openLog(p string, flags int) (File, error) {
+	flags |= rf.config.SyncWrites.flag()
+	if !rf.tendsCreated() {
+		return rf.fs.OpenFile(p, flags, rf.config.Mode)
+	}
+	f, err := rf.fs.OpenFile(p, flags|os.O_EXCL, rf.config.Mode)
+	if err == nil {
+		rf.created(p, rf.config.Mode)
+		return f, nil
+	}
+	if flags&os.O_EXCL == 0 && os.IsExist(err) {
+		return rf.fs.OpenFile(p, flags, rf.config.Mode)
+	}
+	return nil, err
+}
@@ -0,0 +1,127 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoRoom = errors.New("rollinglog: Header leaves no room under HardMaxBytes")
+
+// Write p to the active file, cutting over to a new one whenever the next
+// piece would take the file past Config.HardMaxBytes. A record is kept
+// whole where a file of its own could hold it. Called with rf.mu held.
+func (rf *Writer) writeLimited(p []byte) (int, error) {
+	limit := rf.config.HardMaxBytes
+	var n int
+	for {
+		chunk := p[n:]
+		if f := rf.f; limit > 0 && f != nil && f.size+int64(len(chunk)) > limit {
+			room := limit - f.size
+			if f.size > f.start && int64(len(chunk)) <= limit-f.start || room <= 0 {
+				if f.size <= f.start {
+					return n, errNoRoom
+				}
+				if err := rf.cut(); err != nil {
+					return n, err
+				}
+				continue
+			}
+			chunk = chunk[:room]
+		}
+		m, err := rf.writeFile(rf.f, chunk)
+		if err != nil && rf.config.OnFull != FullFail && isDiskFull(err) {
+			m, err = rf.writeFull(chunk, m, err)
+		}
+		if n += m; err != nil || n == len(p) {
+			return n, err
+		}
+	}
+}
+
+// Replace the active file with the next free sequence file of its period,
+// leaving the rotation goroutine to hand the old one on. Called with rf.mu
+// held.
+func (rf *Writer) cut() error {
+	old := rf.f
+	var f File
+	var p string
+	for seq := 1; ; seq++ {
+		var err error
+		if p, err = rf.layout.name(old.stamp, seq); err != nil {
+			return err
+		}
+		f, err = rf.openLog(p, os.O_CREATE|os.O_EXCL|os.O_APPEND|os.O_WRONLY)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+	lf, err := rf.setupFile(f, p, old.base)
+	if err != nil {
+		return err
+	}
+	lf.stamp = old.stamp
+
+	rf.flushRepeats(lf)
+	rf.f = lf
+	rf.stats.Rotations++
+	rf.cutParts = append(rf.cutParts, old.Name())
+	rf.journal.event(journalInfo, old.Name(), "cut %s at %d bytes", old.Name(), old.size)
+	if err := old.close(); err != nil {
+		rf.logf("closing %s: %w", old.Name(), err)
+	}
+	rf.poke()
+	return nil
+}
+
+// Take over the active file if it was replaced other than by rotation, by
+// Config.HardMaxBytes or Reopen, and finish the files cut since last time.
+func (rf *Writer) adopt() {
+	rs := &rf.rot
+	rf.mu.Lock()
+	parts := rf.cutParts
+	rf.cutParts = nil
+	f := rf.f
+	rf.mu.Unlock()
+
+	if f != nil && f != rs.current {
+		rs.current = f
+		rs.opened, _ = rf.fs.Stat(f.Name())
+	}
+	if len(parts) == 0 || f == nil {
+		return
+	}
+	rf.maintain(func() {
+		for _, p := range parts {
+			rf.postRotate(rf.ctx, p, RotateHardLimit)
+		}
+		rf.prune(rf.layout, f.Name())
+	})
+	rf.schedule(rf.clock.Now())
+}
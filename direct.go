@@ -0,0 +1,154 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"unsafe"
+)
+
+// Alignment of the offsets, lengths and memory of direct writes, and how
+// much is gathered before writing.
+const (
+	directAlign = 4096
+	directChunk = 256 << 10
+)
+
+// Writes a file with Config.DirectIO. Records are gathered in an aligned
+// buffer and written a whole number of blocks at a time, bypassing the
+// page cache. The partial block at the end is written through an ordinary
+// descriptor when the file is closed, and rewritten in full by the next
+// direct write.
+type directWriter struct {
+	direct *os.File // opened with O_DIRECT
+	plain  *os.File // for the partial block at the end
+	buf    []byte   // aligned, directChunk long
+	n      int      // bytes held in buf
+	off    int64    // offset of buf[0] in the file, a multiple of directAlign
+}
+
+// Open p, which holds size bytes, for direct writing after its end.
+func openDirect(p string, size int64) (*directWriter, error) {
+	direct, err := openDirectFile(p)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		direct.Close()
+		return nil, err
+	}
+	d := &directWriter{direct: direct, plain: plain, buf: alignedBuffer(directChunk), off: size &^ (directAlign - 1)}
+	if tail := int(size - d.off); tail > 0 {
+		// the last block is rewritten whole, so start with what it holds
+		err := readTail(p, d.buf[:tail], d.off)
+		if err != nil {
+			d.direct.Close()
+			d.plain.Close()
+			return nil, err
+		}
+		d.n = tail
+	}
+	return d, nil
+}
+
+// Read the len(b) bytes of p at off.
+func readTail(p string, b []byte, off int64) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(b, off)
+	return err
+}
+
+// A buffer of n bytes starting at a multiple of directAlign in memory.
+func alignedBuffer(n int) []byte {
+	b := make([]byte, n+directAlign)
+	skip := 0
+	if rem := int(uintptr(unsafe.Pointer(&b[0])) & (directAlign - 1)); rem != 0 {
+		skip = directAlign - rem
+	}
+	return b[skip : skip+n : skip+n]
+}
+
+func (d *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if d.n == len(d.buf) {
+			if err := d.writeBlocks(); err != nil {
+				return written, err
+			}
+		}
+		c := copy(d.buf[d.n:], p)
+		d.n += c
+		written += c
+		p = p[c:]
+	}
+	if d.n == len(d.buf) {
+		return written, d.writeBlocks()
+	}
+	return written, nil
+}
+
+// Write the whole blocks held, keeping the partial one after them.
+func (d *directWriter) writeBlocks() error {
+	full := d.n &^ (directAlign - 1)
+	if full == 0 {
+		return nil
+	}
+	if _, err := d.direct.WriteAt(d.buf[:full], d.off); err != nil {
+		return err
+	}
+	d.off += int64(full)
+	d.n = copy(d.buf, d.buf[full:d.n])
+	return nil
+}
+
+// Write everything held to the file. The partial block at the end stays
+// held, to be rewritten in full by the next direct write.
+func (d *directWriter) Flush() error {
+	if err := d.writeBlocks(); err != nil {
+		return err
+	}
+	if d.n > 0 {
+		if _, err := d.plain.WriteAt(d.buf[:d.n], d.off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *directWriter) Close() error {
+	err := d.Flush()
+	if cerr := d.direct.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := d.plain.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"time"
+)
+
+// Records dropped since they were last accounted for, by the line that
+// RateLimiter and Config.DropSummary write about them.
+type dropSummary struct {
+	records     int64
+	bytes       int64
+	first, last time.Time
+	reasons     []dropCount // in the order first seen
+}
+
+// The records dropped for one reason.
+type dropCount struct {
+	reason  string
+	records int64
+}
+
+// Count a record of n bytes dropped at now for reason.
+func (d *dropSummary) add(reason string, n int, now time.Time) {
+	d.addRecords(reason, 1, int64(n), now)
+}
+
+// Count records totalling n bytes dropped at now for reason.
+func (d *dropSummary) addRecords(reason string, records, n int64, now time.Time) {
+	if d.records == 0 {
+		d.first = now
+	}
+	d.last = now
+	d.records += records
+	d.bytes += n
+	for i := range d.reasons {
+		if d.reasons[i].reason == reason {
+			d.reasons[i].records += records
+			return
+		}
+	}
+	d.reasons = append(d.reasons, dropCount{reason, records})
+}
+
+// The line accounting for the records counted, such as
+//
+//	rollinglog: dropped 1532 records (98304 bytes) between 2024-05-01T12:00:00.000Z and 2024-05-01T12:00:04.250Z, reasons: queue full 1500, displaced 32
+func (d *dropSummary) line() []byte {
+	noun := "records"
+	if d.records == 1 {
+		noun = "record"
+	}
+	b := fmt.Appendf(nil, "rollinglog: dropped %d %s (%d bytes) between %s and %s, reasons:",
+		d.records, noun, d.bytes, d.first.Format(defaultPrefixFormat), d.last.Format(defaultPrefixFormat))
+	for i, c := range d.reasons {
+		sep := ", "
+		if i == 0 {
+			sep = " "
+		}
+		b = fmt.Appendf(b, "%s%s %d", sep, c.reason, c.records)
+	}
+	return append(b, '\n')
+}
+
+// Start counting afresh, once the line has been written.
+func (d *dropSummary) reset() {
+	d.records, d.bytes = 0, 0
+	d.reasons = d.reasons[:0]
+}
@@ -0,0 +1,214 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An Encrypter encrypts log files as they are written.
+type Encrypter interface {
+	// Encrypt returns a writer encrypting to w, a newly opened log file
+	// at path. Closing it finishes the encrypted stream but must not
+	// close w.
+	Encrypt(w io.Writer, path string) (io.WriteCloser, error)
+}
+
+// AESGCM is an Encrypter using AES-GCM. Every file gets its own random
+// data key, stored at the start of the file sealed with Key. Each Write
+// becomes one or more sealed chunks, numbered so that they cannot be
+// reordered or removed unnoticed, and Close seals an end marker so that a
+// truncated file can be told from a complete one. Decrypt reverses it.
+type AESGCM struct {
+	Key []byte // 16, 24 or 32 bytes
+}
+
+// ErrTruncated is returned by AESGCM.Decrypt for a file that ends before
+// its end marker, for example after a crash.
+var ErrTruncated = errors.New("rollinglog: encrypted log is truncated")
+
+const (
+	aesgcmMagic    = "RLGCM\x01"
+	aesgcmChunk    = 64 << 10 // largest plaintext per chunk
+	aesgcmData     = 0        // chunk flags
+	aesgcmEnd      = 1
+	aesgcmKeyBytes = 32
+)
+
+func (a *AESGCM) kek() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(a.Key)
+	if err != nil {
+		return nil, fmt.Errorf("rollinglog: encryption key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (a *AESGCM) Encrypt(w io.Writer, path string) (io.WriteCloser, error) {
+	kek, err := a.kek()
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, aesgcmKeyBytes)
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(aesgcmMagic), nonce...)
+	header = kek.Seal(header, nonce, key, []byte(aesgcmMagic))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &aesgcmWriter{w: w, aead: aead}, nil
+}
+
+type aesgcmWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	seq   uint64
+	buf   []byte
+	nonce [12]byte
+}
+
+func (e *aesgcmWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), aesgcmChunk)
+		if err := e.seal(aesgcmData, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (e *aesgcmWriter) Close() error {
+	return e.seal(aesgcmEnd, nil)
+}
+
+// Write one chunk: flag, ciphertext length, ciphertext. The flag is
+// authenticated and the chunk number is the nonce.
+func (e *aesgcmWriter) seal(flag byte, p []byte) error {
+	binary.BigEndian.PutUint64(e.nonce[4:], e.seq)
+	e.seq++
+	e.buf = append(e.buf[:0], flag, 0, 0, 0, 0)
+	e.buf = e.aead.Seal(e.buf, e.nonce[:], p, []byte{flag})
+	binary.BigEndian.PutUint32(e.buf[1:5], uint32(len(e.buf)-5))
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// Decrypt writes the plaintext of an encrypted log file read from src to
+// dst. A file holding several encrypted streams, as left by appending
+// out-of-order records to a finished file, is decrypted in full.
+func (a *AESGCM) Decrypt(dst io.Writer, src io.Reader) error {
+	kek, err := a.kek()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(src)
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			return nil
+		}
+		if err := a.decryptStream(dst, r, kek); err != nil {
+			return err
+		}
+	}
+}
+
+// Decrypt one stream, from its header to its end marker.
+func (a *AESGCM) decryptStream(dst io.Writer, r io.Reader, kek cipher.AEAD) error {
+	header := make([]byte, len(aesgcmMagic)+kek.NonceSize()+aesgcmKeyBytes+kek.Overhead())
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ErrTruncated
+	}
+	if string(header[:len(aesgcmMagic)]) != aesgcmMagic {
+		return errors.New("rollinglog: not an encrypted log")
+	}
+	nonce := header[len(aesgcmMagic) : len(aesgcmMagic)+kek.NonceSize()]
+	key, err := kek.Open(nil, nonce, header[len(aesgcmMagic)+kek.NonceSize():], []byte(aesgcmMagic))
+	if err != nil {
+		return errors.New("rollinglog: wrong key for encrypted log")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	var chunkNonce [12]byte
+	var buf []byte
+	for seq := uint64(0); ; seq++ {
+		var head [5]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return ErrTruncated
+		}
+		size := binary.BigEndian.Uint32(head[1:])
+		if size > aesgcmChunk+uint32(aead.Overhead()) {
+			return errors.New("rollinglog: corrupt encrypted log")
+		}
+		if cap(buf) < int(size) {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return ErrTruncated
+		}
+		binary.BigEndian.PutUint64(chunkNonce[4:], seq)
+		plain, err := aead.Open(buf[:0], chunkNonce[:], buf, head[:1])
+		if err != nil {
+			return errors.New("rollinglog: corrupt encrypted log")
+		}
+		if head[0] == aesgcmEnd {
+			return nil
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExportFormat selects how Export packages the records it finds.
+type ExportFormat int
+
+const (
+	// ExportStream concatenates the records, oldest file first.
+	ExportStream ExportFormat = iota
+	// ExportTarGz writes a gzip compressed tar archive with one entry per
+	// log file, named after its path.
+	ExportTarGz
+)
+
+// Periods Export walks before giving up, so a sub-second pattern cannot
+// turn a long window into an endless scan.
+const exportMaxPeriods = 1 << 20
+
+// Export writes every record between from and to, inclusive, from the log
+// files config produces to dst. Compressed backups are decompressed. When
+// config.Timestamp is set, individual records are filtered by their time,
+// with lines it cannot parse following the record before them; otherwise
+// whole files whose period overlaps the window are included.
+func Export(ctx context.Context, config Config, from, to time.Time, dst io.Writer, format ExportFormat) error {
+	l, err := newLayout(&config)
+	if err != nil {
+		return err
+	}
+	files, err := exportFiles(l, from, to)
+	if err != nil {
+		return err
+	}
+
+	if format == ExportStream {
+		for _, name := range files {
+			if err := exportFile(ctx, name, config.Timestamp, from, to, dst); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	zw := gzip.NewWriter(dst)
+	tw := tar.NewWriter(zw)
+	for _, name := range files {
+		if err := exportTarEntry(ctx, tw, name, config.Timestamp, from, to); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Export writes the records between from and to to dst; see the Export
+// function.
+func (rf *Writer) Export(ctx context.Context, from, to time.Time, dst io.Writer, format ExportFormat) error {
+	return Export(ctx, rf.config, from, to, dst, format)
+}
+
+// The files that may hold records between from and to, oldest first.
+func exportFiles(l *layout, from, to time.Time) ([]string, error) {
+	if l.config.Rollover == RolloverNumbered {
+		return exportNumbered(l, from, to)
+	}
+	if !l.config.customNames() && l.static() {
+		files, err := foreignPaths(l, from, to)
+		if err != nil {
+			return nil, err
+		}
+		p := l.fp.format(l.ph, from)
+		files = append(files, existing(globEscape(p))...)
+		return append(files, sequenceFiles(p)...), nil
+	}
+
+	files, err := foreignPaths(l, from, to)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	t := l.sched.prev(from)
+	for i := 0; !t.After(to); i++ {
+		if i == exportMaxPeriods {
+			return nil, errors.New("rollinglog: export window spans too many periods")
+		}
+		p, err := l.pathFor(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range append(existing(globEscape(p)), sequenceFiles(p)...) {
+			if !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+		next := l.sched.next(t)
+		if !next.After(t) {
+			break
+		}
+		t = next
+	}
+	return files, nil
+}
+
+// The backups of other tooling, with ForeignBackups, that may hold records
+// between from and to, oldest first.
+func foreignPaths(l *layout, from, to time.Time) ([]string, error) {
+	if !l.config.ForeignBackups {
+		return nil, nil
+	}
+	foreign, err := foreignFiles(l)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, ff := range foreign {
+		if (ff.from.IsZero() || !ff.from.After(to)) && (ff.to.IsZero() || !ff.to.Before(from)) {
+			files = append(files, ff.Path)
+		}
+	}
+	return files, nil
+}
+
+// Numbered backups cover the span between the modification time of the
+// next older backup and their own.
+func exportNumbered(l *layout, from, to time.Time) ([]string, error) {
+	all, err := numberedFiles(l, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	var start time.Time // end of the next older file
+	for _, p := range all {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		end := fi.ModTime()
+		if !end.Before(from) && !start.After(to) {
+			files = append(files, p)
+		}
+		start = end
+	}
+	return files, nil
+}
+
+// The existing log files matching glob, leaving out sidecars.
+func existing(glob string) []string {
+	matches, _ := filepath.Glob(glob)
+	files := matches[:0]
+	for _, p := range matches {
+		if !isSidecar(p) {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// The existing Dedupe sequence variants of p, leaving out other names the
+// glob matches, such as lumberjack's.
+func sequenceFiles(p string) []string {
+	matches := existing(sequenceGlob(p))
+	files := matches[:0]
+	for _, name := range matches {
+		if base, seq := splitSequence(name); seq > 0 && base == p {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// A glob for the Dedupe sequence variants of p.
+func sequenceGlob(p string) string {
+	ext := path.Ext(p)
+	if strings.ContainsRune(ext, '/') {
+		ext = ""
+	}
+	return globEscape(p[:len(p)-len(ext)]) + "-[0-9][0-9][0-9]*" + globEscape(ext)
+}
+
+// Copy the records of name between from and to to dst.
+func exportFile(ctx context.Context, name string, stamp func([]byte) (time.Time, bool), from, to time.Time, dst io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	if stamp == nil {
+		_, err := io.Copy(dst, r)
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	keep := false
+	for n := 0; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if t, ok := stamp(line); ok {
+				keep = !t.Before(from) && !t.After(to)
+			}
+			if keep {
+				if _, werr := dst.Write(line); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if n%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Add the records of name between from and to to tw. tar needs the size up
+// front, so the records are staged in a temporary file.
+func exportTarEntry(ctx context.Context, tw *tar.Writer, name string, stamp func([]byte) (time.Time, bool), from, to time.Time) error {
+	tmp, err := os.CreateTemp("", "rollinglog-export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := exportFile(ctx, name, stamp, from, to, tmp); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	entry := strings.TrimSuffix(strings.TrimPrefix(filepath.ToSlash(name), "/"), ".gz")
+	var modTime time.Time
+	if fi, err := os.Stat(name); err == nil {
+		modTime = fi.ModTime()
+	}
+	hdr := &tar.Header{Name: entry, Mode: 0644, Size: size, ModTime: modTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, tmp)
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// Writers published with Config.Expvar, by name. expvar cannot remove a
+// variable, so a name is published once and then follows whichever writer
+// last claimed it; Close leaves it mapped to nil, which reads as null.
+var (
+	expvarMu      sync.Mutex
+	expvarWriters = make(map[string]*Writer)
+)
+
+// Publish rf's Stats under name until rf is closed. A name held by another
+// open writer is an error, as is one already used by something other than
+// rollinglog.
+func publishExpvar(name string, rf *Writer) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if prev, ok := expvarWriters[name]; ok {
+		if prev != nil {
+			return fmt.Errorf("rollinglog: expvar %q is in use by another writer", name)
+		}
+	} else {
+		if expvar.Get(name) != nil {
+			return fmt.Errorf("rollinglog: expvar %q is already published", name)
+		}
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			expvarMu.Lock()
+			rf := expvarWriters[name]
+			expvarMu.Unlock()
+			if rf == nil {
+				return nil
+			}
+			return rf.Stats()
+		}))
+	}
+	expvarWriters[name] = rf
+	rf.atClose = append(rf.atClose, func() { unpublishExpvar(name, rf) })
+	return nil
+}
+
+// Release name if rf still holds it.
+func unpublishExpvar(name string, rf *Writer) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvarWriters[name] == rf {
+		expvarWriters[name] = nil
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Default Config.PrefixFormat.
+const defaultPrefixFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Rewrite a record on its way to the file according to the Config. Called
+// with rf.mu held. The result may be p itself, or one of rf.bufs, valid
+// until the next call.
+func (rf *Writer) filter(p []byte) []byte {
+	now := rf.clock.Now()
+	fb := &rf.bufs
+	if rf.config.StripANSI {
+		p = fb.keep(p, rf.ansi.strip(fb.next(), p))
+	}
+	if rf.config.MaxLineBytes > 0 {
+		p = fb.keep(p, rf.lineLen.truncate(fb.next(), p, rf.config.MaxLineBytes))
+	}
+	if rf.config.RepeatWindow > 0 {
+		p = fb.keep(p, rf.repeats.suppress(fb.next(), p, now, rf.config.RepeatWindow))
+	}
+	if rf.config.PrefixTimestamps {
+		p = fb.keep(p, rf.prefixTimestamps(fb.next(), p, now))
+	}
+	if rf.config.JSONEnvelope && len(p) > 0 {
+		p = fb.keep(p, rf.envelope(fb.next(), p, now))
+	}
+	return p
+}
+
+// Buffers larger than this are not kept for the next record.
+const maxFilterBuf = 64 << 10
+
+// Buffers the filters write into, reused from one record to the next so
+// that steady-state writes do not allocate. Filters alternate between the
+// two, each reading what the one before wrote.
+type filterBufs struct {
+	bufs  [2][]byte
+	i     int
+	stamp []byte // formatted time
+}
+
+// The buffer for the next filter to append to.
+func (fb *filterBufs) next() []byte {
+	fb.i ^= 1
+	return fb.bufs[fb.i][:0]
+}
+
+// Keep out, written by a filter given in and the buffer from next, for
+// reuse. A filter that returned in unchanged leaves the buffer unused.
+func (fb *filterBufs) keep(in, out []byte) []byte {
+	if len(out) == 0 || len(in) > 0 && &out[0] == &in[0] {
+		fb.i ^= 1
+		return out
+	}
+	if cap(out) <= maxFilterBuf {
+		fb.bufs[fb.i] = out
+	} else {
+		fb.bufs[fb.i] = nil
+	}
+	return out
+}
+
+// Default Config.EnvelopeStream.
+const defaultEnvelopeStream = "stdout"
+
+// The time of an envelope: RFC 3339 in UTC, with every digit of the
+// fraction kept so that all records line up.
+const envelopeTime = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Append p, less its final newline, to out as a JSON object on a line of
+// its own, with its keys in order.
+func (rf *Writer) envelope(out, p []byte, now time.Time) []byte {
+	stream := rf.config.EnvelopeStream
+	if stream == "" {
+		stream = defaultEnvelopeStream
+	}
+	out = append(out, `{"msg":`...)
+	out = appendJSONString(out, bytes.TrimSuffix(p, []byte("\n")))
+	out = append(out, `,"stream":`...)
+	out = appendJSONString(out, []byte(stream))
+	out = append(out, `,"ts":"`...)
+	out = now.UTC().AppendFormat(out, envelopeTime)
+	return append(out, "\"}\n"...)
+}
+
+// Append s to out as a JSON string, with invalid UTF-8 replaced as
+// encoding/json does.
+func appendJSONString(out, s []byte) []byte {
+	const hex = "0123456789abcdef"
+	out = append(out, '"')
+	for len(s) > 0 {
+		c := s[0]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRune(s)
+			if r == utf8.RuneError && size == 1 {
+				out = append(out, `\ufffd`...)
+			} else {
+				out = append(out, s[:size]...)
+			}
+			s = s[size:]
+			continue
+		}
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c == '\n':
+			out = append(out, `\n`...)
+		case c == '\r':
+			out = append(out, `\r`...)
+		case c == '\t':
+			out = append(out, `\t`...)
+		case c < 0x20:
+			out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			out = append(out, c)
+		}
+		s = s[1:]
+	}
+	return append(out, '"')
+}
+
+// Append p to out, starting each line of p that begins a line in the
+// file with the time.
+func (rf *Writer) prefixTimestamps(out, p []byte, now time.Time) []byte {
+	format := rf.config.PrefixFormat
+	if format == "" {
+		format = defaultPrefixFormat
+	}
+	rf.bufs.stamp = append(now.AppendFormat(rf.bufs.stamp[:0], format), ' ')
+	prefix := rf.bufs.stamp
+
+	for len(p) > 0 {
+		if !rf.midLine {
+			out = append(out, prefix...)
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			out = append(out, p...)
+			rf.midLine = true
+			break
+		}
+		out = append(out, p[:i+1]...)
+		rf.midLine = false
+		p = p[i+1:]
+	}
+	return out
+}
+
+// Where an ANSI escape sequence stripper is in the stream. Sequences may
+// be split across Write calls, so the state lives in the Writer.
+type ansiState int
+
+const (
+	ansiText         ansiState = iota
+	ansiEscape                 // after ESC
+	ansiCSI                    // in ESC [ ... final byte
+	ansiString                 // in an OSC, DCS, PM or APC string until BEL or ST
+	ansiStringEscape           // after ESC inside a string, expecting \
+)
+
+// Remove terminal escape sequences from p: color and cursor control (CSI),
+// window titles and hyperlinks (OSC) and the other ESC sequences. The
+// rest is appended to out, or p itself returned if it has none.
+func (s *ansiState) strip(out, p []byte) []byte {
+	if *s == ansiText && bytes.IndexByte(p, 0x1b) < 0 {
+		return p
+	}
+	for _, c := range p {
+		switch *s {
+		case ansiText:
+			if c == 0x1b {
+				*s = ansiEscape
+			} else {
+				out = append(out, c)
+			}
+		case ansiEscape:
+			switch c {
+			case '[':
+				*s = ansiCSI
+			case ']', 'P', '^', '_':
+				*s = ansiString
+			default:
+				// intermediate bytes continue the sequence
+				if c < 0x20 || c > 0x2f {
+					*s = ansiText
+				}
+			}
+		case ansiCSI:
+			if c >= 0x40 && c <= 0x7e {
+				*s = ansiText
+			}
+		case ansiString:
+			if c == 0x07 {
+				*s = ansiText
+			} else if c == 0x1b {
+				*s = ansiStringEscape
+			}
+		case ansiStringEscape:
+			if c == '\\' {
+				*s = ansiText
+			} else {
+				*s = ansiString
+			}
+		}
+	}
+	return out
+}
+
+// Marker ending a line cut short by Config.MaxLineBytes.
+const truncatedMarker = " [truncated]"
+
+// Length of the line being written, for Config.MaxLineBytes.
+type lineLimit struct {
+	n   int  // bytes of the current line so far
+	cut bool // the current line has been truncated
+}
+
+// Cut lines of p longer than max bytes, marking where they were cut, and
+// append the result to out, or return p itself if nothing was cut.
+func (l *lineLimit) truncate(out, p []byte, max int) []byte {
+	if l.n+len(p) <= max && !l.cut {
+		if i := bytes.LastIndexByte(p, '\n'); i >= 0 {
+			l.n = len(p) - i - 1
+		} else {
+			l.n += len(p)
+		}
+		return p
+	}
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		piece := p
+		if i >= 0 {
+			piece = p[:i]
+		}
+		if !l.cut {
+			if keep := max - l.n; len(piece) <= keep {
+				out = append(out, piece...)
+				l.n += len(piece)
+			} else {
+				out = append(out, piece[:keep]...)
+				out = append(out, truncatedMarker...)
+				l.cut = true
+			}
+		}
+		if i < 0 {
+			break
+		}
+		out = append(out, '\n')
+		l.n, l.cut = 0, false
+		p = p[i+1:]
+	}
+	return out
+}
+
+// Lines seen by the repeated line suppressor.
+type repeatState struct {
+	line  []byte // last complete line written, empty at the start of a file
+	since time.Time
+	count int  // repeats of line not written
+	mid   bool // the last record ended partway through a line
+}
+
+// Drop lines of p that repeat the previous line within window of its
+// first appearance, writing a count of them once the run ends, and append
+// the rest to out.
+func (r *repeatState) suppress(out, p []byte, now time.Time, window time.Duration) []byte {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if r.mid || i < 0 {
+			// partial lines are never suppressed
+			if !r.mid {
+				out = r.summary(out)
+				r.line = r.line[:0]
+			}
+			if i < 0 {
+				out = append(out, p...)
+				r.mid = true
+				break
+			}
+			out = append(out, p[:i+1]...)
+			r.mid = false
+			p = p[i+1:]
+			continue
+		}
+
+		line := p[:i+1]
+		p = p[i+1:]
+		if len(r.line) > 0 && bytes.Equal(line, r.line) && now.Sub(r.since) < window {
+			r.count++
+			continue
+		}
+		out = r.summary(out)
+		r.line = append(r.line[:0], line...)
+		r.since = now
+		out = append(out, line...)
+	}
+	return out
+}
+
+// Append the count of suppressed repeats, if any, to out.
+func (r *repeatState) summary(out []byte) []byte {
+	if r.count > 0 {
+		out = append(out, "last message repeated "...)
+		out = strconv.AppendInt(out, int64(r.count), 10)
+		out = append(out, " times\n"...)
+		r.count = 0
+	}
+	return out
+}
+
+// Write any pending repeat count to f, which is about to be retired, so
+// that the next file starts afresh. Called with rf.mu held.
+func (rf *Writer) flushRepeats(f *logFile) {
+	if rf.config.RepeatWindow <= 0 {
+		return
+	}
+	q := rf.repeats.summary(nil)
+	rf.repeats.line = rf.repeats.line[:0]
+	if len(q) == 0 || f == nil {
+		return
+	}
+	if rf.config.PrefixTimestamps {
+		q = rf.prefixTimestamps(nil, q, rf.clock.Now())
+	}
+	if _, err := rf.writeFile(f, q); err != nil {
+		rf.logf("writing repeat count to %s: %w", f.Name(), err)
+	}
+}
+
+// The number of bytes of a record p to report written when n bytes of its
+// filtered form q reached the file.
+func consumed(p, q []byte, n int) int {
+	if n >= len(q) || n > len(p) {
+		return len(p)
+	}
+	return n
+}
+
+// Pass p through Config.Filters, reporting false if one of them dropped
+// it. Called with rf.mu held.
+func (rf *Writer) applyFilters(p []byte) ([]byte, bool) {
+	for _, filter := range rf.config.Filters {
+		var ok bool
+		if p, ok = filter(p); !ok {
+			rf.stats.Filtered++
+			return nil, false
+		}
+	}
+	return p, true
+}
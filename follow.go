@@ -0,0 +1,182 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How often Follow checks for new data and rotation once it has caught up.
+const followPoll = 250 * time.Millisecond
+
+// Follow returns a reader with the semantics of tail -F over the logs
+// config produces. It starts at the beginning of the current file and,
+// once it has caught up, waits for more to be written. When rotation
+// moves the pattern on to a new file, or replaces the file behind
+// RolloverNumbered or RolloverRenamed, the reader finishes the old file and continues from
+// the start of the new one; a copy-truncated file is read again from its
+// start. Read blocks until there is data, and returns io.EOF once Close
+// has been called.
+func Follow(config Config) (io.ReadCloser, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	return newFollower(l, false), nil
+}
+
+// Follow the files of l, starting at the end of the current one if tail
+// is set.
+func newFollower(l *layout, tail bool) *follower {
+	return &follower{l: l, tail: tail, done: make(chan struct{})}
+}
+
+type follower struct {
+	l    *layout
+	tail bool // skip what the first file already holds
+
+	mu      sync.Mutex
+	f       *os.File
+	off     int64
+	pending string // replacement seen once at EOF, switched to the next time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (fr *follower) Read(p []byte) (int, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	for {
+		select {
+		case <-fr.done:
+			return 0, io.EOF
+		default:
+		}
+
+		if fr.f != nil {
+			n, err := fr.f.Read(p)
+			fr.off += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			if fr.advance() {
+				continue
+			}
+		} else if fr.open(fr.target()) {
+			continue
+		}
+
+		fr.mu.Unlock()
+		select {
+		case <-fr.done:
+		case <-time.After(followPoll):
+		}
+		fr.mu.Lock()
+	}
+}
+
+// The file the writer is currently appending to.
+func (fr *follower) target() string {
+	p, err := fr.l.activePath(time.Now())
+	if err != nil && fr.f != nil {
+		return fr.f.Name()
+	}
+	if fr.l.config.Dedupe {
+		if seq := sequenceFiles(p); len(seq) > 0 {
+			sort.Strings(seq)
+			return seq[len(seq)-1]
+		}
+	}
+	return p
+}
+
+func (fr *follower) open(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	fr.f, fr.off, fr.pending = f, 0, ""
+	if fr.tail {
+		fr.tail = false
+		if off, err := f.Seek(0, io.SeekEnd); err == nil {
+			fr.off = off
+		}
+	}
+	return true
+}
+
+// At the end of the open file, move to its replacement or back to the
+// start of a truncated file, reporting whether there may be more to read.
+// A replacement is only switched to after the old file has been found at
+// its end a second time, a poll later, so records written to it just
+// before it was retired are not missed.
+func (fr *follower) advance() bool {
+	name := fr.target()
+	fi, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	cur, err := fr.f.Stat()
+	if err != nil {
+		return false
+	}
+	if os.SameFile(fi, cur) {
+		fr.pending = ""
+		if cur.Size() < fr.off {
+			if _, err := fr.f.Seek(0, io.SeekStart); err == nil {
+				fr.off = 0
+				return true
+			}
+		}
+		return false
+	}
+	if fr.pending != name {
+		fr.pending = name
+		return false
+	}
+	fr.f.Close()
+	fr.f = nil
+	return fr.open(name)
+}
+
+func (fr *follower) Close() error {
+	fr.closeOnce.Do(func() { close(fr.done) })
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.f == nil {
+		return nil
+	}
+	err := fr.f.Close()
+	fr.f = nil
+	return err
+}
@@ -0,0 +1,164 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The time lumberjack inserts before the extension of its backups.
+const lumberjackLayout = "2006-01-02T15-04-05.000"
+
+// Apache rotatelogs suffixes are Unix times; shorter ones are the
+// generations file-rotatelogs appends on a name collision.
+const minEpochDigits = 9
+
+// A backup left by other tooling, with the span its records cover where
+// its name says; zero bounds are open.
+type foreignFile struct {
+	LogFile
+	from, to time.Time
+}
+
+// The backups lumberjack and rotatelogs left of the files the pattern
+// produces, oldest first.
+func foreignFiles(l *layout) ([]foreignFile, error) {
+	glob := l.fp.glob(l.ph)
+	ext := path.Ext(glob)
+	if strings.ContainsRune(ext, '/') {
+		ext = ""
+	}
+	stamp := glob[:len(glob)-len(ext)] + "-" + strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '?'
+		}
+		return r
+	}, lumberjackLayout) + ext
+	var matches []string
+	for _, g := range []string{stamp, stamp + ".gz", glob + ".[0-9]*"} {
+		m, err := filepath.Glob(g)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m...)
+	}
+
+	var lumberjack, epoch, generations []foreignFile
+	for _, p := range matches {
+		lf, ok := statLogFile(p)
+		if !ok {
+			continue
+		}
+		name := strings.TrimSuffix(p, ".gz")
+		if t, ok := parseLumberjack(l, name); ok {
+			lf.Period = t
+			lumberjack = append(lumberjack, foreignFile{LogFile: lf})
+			continue
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i == -1 || strings.TrimLeft(name[i+1:], "0123456789") != "" {
+			continue
+		}
+		n, err := strconv.ParseInt(name[i+1:], 10, 64)
+		if err != nil || n < 1 {
+			continue
+		}
+		t, ok := l.fp.parse(l.ph, name[:i])
+		if !ok {
+			continue
+		}
+		if len(name)-i-1 >= minEpochDigits {
+			lf.Period = time.Unix(n, 0)
+			epoch = append(epoch, foreignFile{LogFile: lf})
+			continue
+		}
+		lf.Period, lf.Seq = t, int(n)
+		ff := foreignFile{LogFile: lf}
+		if !t.IsZero() && !l.static() {
+			ff.from, ff.to = t, l.sched.next(t)
+		}
+		generations = append(generations, ff)
+	}
+
+	// lumberjack names a backup for when it was closed, rotatelogs for
+	// when it was opened
+	sortForeign(lumberjack)
+	for i := range lumberjack {
+		lumberjack[i].to = lumberjack[i].Period
+		if i > 0 {
+			lumberjack[i].from = lumberjack[i-1].Period
+		}
+	}
+	sortForeign(epoch)
+	for i := range epoch {
+		epoch[i].from = epoch[i].Period
+		if i+1 < len(epoch) {
+			epoch[i].to = epoch[i+1].Period
+		}
+	}
+	files := append(append(lumberjack, epoch...), generations...)
+	sortForeign(files)
+	return files, nil
+}
+
+// Recover the time of a lumberjack backup of a file the pattern produces,
+// which lumberjack writes in UTC by default.
+func parseLumberjack(l *layout, name string) (time.Time, bool) {
+	for _, ext := range []string{path.Ext(name), ""} {
+		if strings.ContainsRune(ext, '/') {
+			continue
+		}
+		stem := name[:len(name)-len(ext)]
+		i := len(stem) - len(lumberjackLayout) - 1
+		if i < 0 || stem[i] != '-' {
+			continue
+		}
+		t, err := time.Parse(lumberjackLayout, stem[i+1:])
+		if err != nil {
+			continue
+		}
+		if _, ok := l.fp.parse(l.ph, stem[:i]+ext); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func sortForeign(files []foreignFile) {
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].Period.Equal(files[j].Period) {
+			return files[i].Period.Before(files[j].Period)
+		}
+		if files[i].Seq != files[j].Seq {
+			return files[i].Seq < files[j].Seq
+		}
+		return files[i].Path < files[j].Path
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import "fmt"
+
+// FullPolicy selects what Write does when the disk is full.
+type FullPolicy int
+
+const (
+	// FullFail returns the error, as for any other failed write.
+	FullFail FullPolicy = iota
+	// FullBlock waits, retrying every second, until the write fits or the
+	// writer is closed. Other writers queue up behind it.
+	FullBlock
+	// FullDrop discards the record and reports success. Dropped bytes are
+	// counted by Lost.
+	FullDrop
+	// FullPurge deletes the oldest log files, one at a time, until the
+	// write fits. The active file is never deleted; when nothing else is
+	// left the error is returned.
+	FullPurge
+)
+
+func (p FullPolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case FullFail:
+		return []byte("fail"), nil
+	case FullBlock:
+		return []byte("block"), nil
+	case FullDrop:
+		return []byte("drop"), nil
+	case FullPurge:
+		return []byte("purge"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown disk full policy %d", int(p))
+}
+
+func (p *FullPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "fail", "":
+		*p = FullFail
+	case "block":
+		*p = FullBlock
+	case "drop":
+		*p = FullDrop
+	case "purge":
+		*p = FullPurge
+	default:
+		return fmt.Errorf("rollinglog: unknown disk full policy %q", text)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Logs of a Manager that rotate together.
+type rotationGroup struct {
+	mu      sync.Mutex
+	members []*Writer
+}
+
+// A rotation of another member of the writer's group, for it to follow.
+type groupRotation struct {
+	reason RotateReason // why the other member rotated; zero if none
+	at     time.Time
+}
+
+// Group makes the named logs rotate together: whenever one of them
+// rotates, when its period ends, on request or for its size or
+// RotationPolicy, the others rotate as well, with RotateGroup, and all the
+// new files are named for the same time. Records of one request written
+// to an access, error and audit log thus always land in matching files
+// and dated directories. A log whose pattern is coarser than another's
+// keeps its file when that one's period ends. Cuts for HardMaxBytes are
+// not followed. A log can be in one group, for as long as it is open.
+func (m *Manager) Group(names ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g := &rotationGroup{}
+	for _, name := range names {
+		w, ok := m.writers[name]
+		if !ok {
+			return fmt.Errorf("rollinglog: log %q is not open", name)
+		}
+		w.mu.Lock()
+		grouped := w.group != nil
+		w.mu.Unlock()
+		if grouped {
+			return fmt.Errorf("rollinglog: log %q is already in a group", name)
+		}
+		g.members = append(g.members, w)
+	}
+	for _, w := range g.members {
+		w.mu.Lock()
+		w.group = g
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// Have the other members of the writer's group, if any, follow the
+// rotation for reason it is starting at now. Called from step.
+func (rf *Writer) leadRotation(reason RotateReason, now time.Time) {
+	rf.mu.Lock()
+	g := rf.group
+	rf.mu.Unlock()
+	if g == nil {
+		return
+	}
+	rf.rot.at = now
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, w := range g.members {
+		if w == rf {
+			continue
+		}
+		w.mu.Lock()
+		if !w.closed {
+			w.joining = groupRotation{reason, now}
+			w.poke()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Take up a rotation another member of the writer's group has asked it to
+// follow, unless the writer is rotating already or, for a scheduled one,
+// would only reopen its file. Called from step.
+func (rf *Writer) joinRotation() {
+	rf.mu.Lock()
+	req := rf.joining
+	rf.joining = groupRotation{}
+	rf.mu.Unlock()
+	rs := &rf.rot
+	if req.reason == 0 || rs.current == nil || !rs.reopen.IsZero() {
+		return
+	}
+	if req.reason == RotateScheduled && rf.config.Rollover == RolloverDated {
+		if p, err := rf.layout.pathFor(req.at); err == nil && p == rs.current.base {
+			return
+		}
+	}
+	rs.requested, rs.at = RotateGroup, req.at
+}
@@ -0,0 +1,111 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errHandoffPending is the error of a writer whose predecessor has not yet
+// handed over the log with Config.Handoff.
+var errHandoffPending = errors.New("rollinglog: waiting for the previous process to hand over the log")
+
+// The lock of Config.Handoff, held by whichever process writes the log.
+type handoff struct {
+	f       *os.File
+	owned   atomic.Bool // the lock is ours
+	arrived atomic.Bool // the lock has just become ours, for the rotation goroutine
+
+	mu     sync.Mutex
+	closed bool // the writer has closed
+}
+
+// Take the lock of Config.Handoff for rf. If the process writing the log
+// holds it, the handoff is returned unowned, for awaitHandoff to wait on
+// once the writer is built.
+func (rf *Writer) takeHandoff(p string) (*handoff, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, rf.config.Mode)
+	if err != nil {
+		return nil, err
+	}
+	h := &handoff{f: f}
+	switch err := lockFile(f, false); err {
+	case nil:
+		h.owned.Store(true)
+	case errLocked:
+	default:
+		f.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// How often a writer waiting for Config.Handoff tries the lock again.
+const handoffPoll = 100 * time.Millisecond
+
+// Wait for the lock of h, then have the rotation goroutine open a file.
+// The lock is polled rather than waited on, so that closing the writer
+// ends the wait and lets go of the lock file.
+func (rf *Writer) awaitHandoff(h *handoff) {
+	err := errLocked
+	for err == errLocked && rf.sleep(handoffPoll) {
+		err = lockFile(h.f, false)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.closed:
+		h.f.Close()
+	case err != nil:
+		h.closed = true
+		h.f.Close()
+		rf.logf("taking over %s: %w", h.f.Name(), err)
+	default:
+		h.owned.Store(true)
+		h.arrived.Store(true)
+		rf.poke()
+	}
+}
+
+// Let the next process have the log, once rf has closed its files. A wait
+// still in progress closes the lock file when it ends.
+func (h *handoff) release() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	if h.owned.Load() {
+		h.f.Close()
+	}
+}
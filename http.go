@@ -0,0 +1,136 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HTTPHandler returns a handler serving the current log file of config.
+// A plain GET returns the file, with support for Range requests. With
+// the query parameter follow set, the response instead streams records
+// as they are written, following rotation as Follow does and starting
+// from the end of the current file: as server-sent events, one per line,
+// when the client accepts text/event-stream, and as chunked plain text
+// otherwise. The handler does no authentication of its own.
+func HTTPHandler(config Config) (http.Handler, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &logHandler{l: l}, nil
+}
+
+type logHandler struct {
+	l *layout
+}
+
+func (h *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Has("follow") {
+		h.follow(w, r)
+		return
+	}
+
+	name := newFollower(h.l, false).target()
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+func (h *logHandler) follow(w http.ResponseWriter, r *http.Request) {
+	fr := newFollower(h.l, true)
+	defer fr.Close()
+	stop := context.AfterFunc(r.Context(), func() { fr.Close() })
+	defer stop()
+
+	rc := http.NewResponseController(w)
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if !sse {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := fr.Read(buf)
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return
+				}
+				rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	br := bufio.NewReader(fr)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" || err == nil {
+			if _, err := io.WriteString(w, "data: "+line+"\n\n"); err != nil {
+				return
+			}
+			if br.Buffered() == 0 {
+				rc.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Suffix of the timestamp index written with Config.IndexEvery.
+const indexSuffix = ".idx"
+
+// Maintains the timestamp index of a log file. Each line of the index
+// holds the offset of a record and its time in Unix nanoseconds.
+type fileIndex struct {
+	path  string
+	mode  os.FileMode
+	every int64
+	stamp func([]byte) (time.Time, bool)
+	size  int64 // of the log file, as far as this writer knows
+	next  int64 // offset from which the next record is indexed
+}
+
+func newFileIndex(f *os.File, config *Config) (*fileIndex, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	every := config.IndexEvery
+	return &fileIndex{
+		path:  f.Name() + indexSuffix,
+		mode:  config.Mode,
+		every: every,
+		stamp: config.Timestamp,
+		size:  fi.Size(),
+		next:  (fi.Size() + every - 1) / every * every,
+	}, nil
+}
+
+// Account for n bytes of p written at the end of the file, indexing p if
+// it starts past the next index point.
+func (ix *fileIndex) wrote(p []byte, n int) {
+	if ix.size >= ix.next && n > 0 {
+		t := time.Now()
+		if ix.stamp != nil {
+			if ts, ok := ix.stamp(p); ok {
+				t = ts
+			}
+		}
+		if err := ix.add(ix.size, t); err != nil {
+			log.Printf("rollinglog: indexing %s: %v", ix.path, err)
+		}
+		ix.next = (ix.size/ix.every + 1) * ix.every
+	}
+	ix.size += int64(n)
+}
+
+func (ix *fileIndex) add(off int64, t time.Time) error {
+	f, err := os.OpenFile(ix.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, ix.mode)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d %d\n", off, t.UnixNano())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// IndexOffset returns the offset in the log file at path of the last
+// record indexed at or before t, using the index written with
+// Config.IndexEvery, or 0 if t precedes every entry. Reading from the
+// offset finds the records from t onwards after skipping at most
+// IndexEvery bytes.
+func IndexOffset(path string, t time.Time) (int64, error) {
+	f, err := os.Open(path + indexSuffix)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var best int64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		off, nanos, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		o, err := strconv.ParseInt(off, 10, 64)
+		if err != nil {
+			continue
+		}
+		ns, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			continue
+		}
+		if time.Unix(0, ns).After(t) {
+			break
+		}
+		best = o
+	}
+	return best, sc.Err()
+}
@@ -0,0 +1,147 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// JournaldMode selects what is sent to systemd-journald.
+type JournaldMode int
+
+const (
+	JournaldOff JournaldMode = iota
+	// JournaldEvents sends file opens, rotations and write failures, each
+	// tagged with the affected file in ROLLINGLOG_FILE.
+	JournaldEvents
+	// JournaldWrites also mirrors every write, with ROLLINGLOG_FILE set to
+	// the file it went to, so journalctl can point back at the log files.
+	JournaldWrites
+)
+
+func (m JournaldMode) MarshalText() ([]byte, error) {
+	switch m {
+	case JournaldOff:
+		return []byte("off"), nil
+	case JournaldEvents:
+		return []byte("events"), nil
+	case JournaldWrites:
+		return []byte("writes"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown journald mode %d", int(m))
+}
+
+func (m *JournaldMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "off", "":
+		*m = JournaldOff
+	case "events":
+		*m = JournaldEvents
+	case "writes":
+		*m = JournaldWrites
+	default:
+		return fmt.Errorf("rollinglog: unknown journald mode %q", text)
+	}
+	return nil
+}
+
+// Journal priorities, as for syslog.
+const (
+	journalErr     = 3
+	journalWarning = 4
+	journalInfo    = 6
+)
+
+// A connection to journald's native socket. A nil *journal discards
+// everything.
+type journal struct {
+	conn  net.Conn
+	mode  JournaldMode
+	ident string
+}
+
+func newJournal(mode JournaldMode) (*journal, error) {
+	if mode == JournaldOff {
+		return nil, nil
+	}
+	conn, err := dialJournal()
+	if err != nil {
+		return nil, err
+	}
+	return &journal{conn: conn, mode: mode, ident: filepath.Base(os.Args[0])}, nil
+}
+
+// Send an event about file.
+func (j *journal) event(priority int, file, format string, args ...interface{}) {
+	if j == nil {
+		return
+	}
+	j.send(priority, file, []byte("rollinglog: "+fmt.Sprintf(format, args...)))
+}
+
+// Mirror a record written to file.
+func (j *journal) record(file string, p []byte) {
+	if j == nil || j.mode != JournaldWrites {
+		return
+	}
+	j.send(journalInfo, file, bytes.TrimSuffix(p, []byte("\n")))
+}
+
+// Send one entry in the native protocol. Entries too large for a datagram
+// are dropped: passing them through a memfd is more than mirroring needs.
+func (j *journal) send(priority int, file string, message []byte) {
+	var buf bytes.Buffer
+	field := func(name string, value []byte) {
+		if bytes.IndexByte(value, '\n') < 0 {
+			buf.WriteString(name + "=")
+			buf.Write(value)
+			buf.WriteByte('\n')
+			return
+		}
+		buf.WriteString(name + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.Write(value)
+		buf.WriteByte('\n')
+	}
+	field("MESSAGE", message)
+	field("PRIORITY", []byte(strconv.Itoa(priority)))
+	field("SYSLOG_IDENTIFIER", []byte(j.ident))
+	if file != "" {
+		field("ROLLINGLOG_FILE", []byte(file))
+	}
+	j.conn.Write(buf.Bytes())
+}
+
+func (j *journal) close() {
+	if j != nil {
+		j.conn.Close()
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package kafkaarchive publishes rolled log files to a Kafka topic through
+// an HTTP proxy speaking the Confluent REST Proxy v2 API, such as
+// Confluent's own or Redpanda's built-in HTTP Proxy, so rotation doubles
+// as the ingestion boundary of a streaming pipeline. Like s3archive it
+// needs nothing beyond the standard library.
+//
+//	w, err := rollinglog.New(rollinglog.Config{
+//		FilepathPattern: "logs/{2006-01-02}/app.log",
+//		Archiver: &kafkaarchive.Archiver{
+//			URL:     "http://redpanda:8082",
+//			Topic:   "app-logs",
+//			Records: true,
+//		},
+//	})
+//
+// Delivery is at least once: a file that fails part way is published again
+// from the start when it is retried.
+package kafkaarchive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archiver publishes each file it is given, as a single message or with
+// Records as one message per line.
+type Archiver struct {
+	URL   string // base URL of the proxy
+	Topic string
+
+	// Records publishes each line of the file as a message of its own,
+	// without its newline, instead of the whole file as one message. Files
+	// ending in .gz are decompressed first. Whole files must fit within
+	// the topic's maximum message size.
+	Records bool
+	// BatchRecords limits how many messages go in one request, 500 if
+	// zero.
+	BatchRecords int
+
+	// Key, if set, returns the key of the file's messages. By default it is
+	// the slash-separated local path.
+	Key func(path string) string
+
+	// Header is added to every request, for example for authentication.
+	Header http.Header
+
+	// Remove deletes the local file once it has been published.
+	Remove bool
+
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// The most bytes of message data sent in one request.
+const maxBatchBytes = 1 << 20
+
+// A message in the proxy's binary embedded format; []byte values are
+// base64 encoded by encoding/json as it expects.
+type record struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Archive publishes the file at path.
+func (a *Archiver) Archive(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	key := []byte(filepath.ToSlash(path))
+	if a.Key != nil {
+		key = []byte(a.Key(path))
+	}
+	if !a.Records {
+		value, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		if err := a.produce(ctx, []record{{key, value}}); err != nil {
+			return err
+		}
+	} else if err := a.produceLines(ctx, f, path, key); err != nil {
+		return err
+	}
+
+	if a.Remove {
+		f.Close()
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// Publish each line of f, read from path, in batches.
+func (a *Archiver) produceLines(ctx context.Context, f io.Reader, path string, key []byte) error {
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		f = zr
+	}
+	limit := a.BatchRecords
+	if limit <= 0 {
+		limit = 500
+	}
+
+	r := bufio.NewReader(f)
+	var batch []record
+	var size int
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) > 0 {
+			value := bytes.TrimSuffix(line, []byte("\n"))
+			batch = append(batch, record{key, value})
+			size += len(value)
+		}
+		if len(batch) > 0 && (len(batch) >= limit || size >= maxBatchBytes || err == io.EOF) {
+			if perr := a.produce(ctx, batch); perr != nil {
+				return perr
+			}
+			batch, size = batch[:0], 0
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Send records to the topic in one request, failing if any of them was
+// not accepted.
+func (a *Archiver) produce(ctx context.Context, records []record) error {
+	body, err := json.Marshal(struct {
+		Records []record `json:"records"`
+	}{records})
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(a.URL, "/") + "/topics/" + url.PathEscape(a.Topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range a.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kafkaarchive: POST %s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("kafkaarchive: POST %s: %v", u, err)
+	}
+	for _, o := range result.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("kafkaarchive: producing to %s: %s (error code %d)", a.Topic, o.Error, *o.ErrorCode)
+		}
+	}
+	return nil
+}
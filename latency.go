@@ -0,0 +1,98 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import "time"
+
+// The upper bounds of the latency histogram's buckets double from
+// minLatencyBucket, and one more bucket takes everything slower.
+const (
+	minLatencyBucket = time.Microsecond
+	latencyBuckets   = 25 // up to about 16s
+)
+
+// LatencyHistogram counts writes to log files by how long they took.
+type LatencyHistogram struct {
+	// Counts[i] is the number of writes that took up to Bounds[i], and
+	// no longer than Bounds[i-1]; the last count has no upper bound.
+	Bounds []time.Duration
+	Counts []int64
+
+	Count int64
+	Sum   time.Duration
+	Max   time.Duration
+	Slow  int64 // writes slower than Config.SlowWriteThreshold
+}
+
+// Write latencies, guarded by rf.mu.
+type latencies struct {
+	counts [latencyBuckets + 1]int64
+	count  int64
+	sum    time.Duration
+	max    time.Duration
+	slow   int64
+}
+
+// Account for a write of n bytes to f that took took. Called with rf.mu
+// held.
+func (rf *Writer) observeWrite(f *logFile, n int, took time.Duration) {
+	l := &rf.latency
+	i := 0
+	for bound := minLatencyBucket; i < latencyBuckets && took > bound; bound *= 2 {
+		i++
+	}
+	l.counts[i]++
+	l.count++
+	l.sum += took
+	l.max = max(l.max, took)
+	rf.pressure.observe(took)
+	if threshold := rf.config.SlowWriteThreshold; threshold > 0 && took > threshold {
+		l.slow++
+		if rf.config.OnSlowWrite != nil {
+			rf.config.OnSlowWrite(f.Name(), n, took)
+		}
+	}
+}
+
+// Latency returns the histogram of how long writes to log files have
+// taken since the writer was created.
+func (rf *Writer) Latency() LatencyHistogram {
+	rf.mu.Lock()
+	l := rf.latency
+	rf.mu.Unlock()
+
+	h := LatencyHistogram{
+		Bounds: make([]time.Duration, latencyBuckets),
+		Counts: l.counts[:],
+		Count:  l.count,
+		Sum:    l.sum,
+		Max:    l.max,
+		Slow:   l.slow,
+	}
+	for i, bound := 0, minLatencyBucket; i < latencyBuckets; i, bound = i+1, bound*2 {
+		h.Bounds[i] = bound
+	}
+	return h
+}
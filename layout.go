@@ -0,0 +1,432 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The naming and scheduling rules derived from a Config, shared by the
+// writer and by helpers that need to know which paths a Config produces.
+type layout struct {
+	fp                filePattern
+	ph                placeholders
+	config            *Config
+	sched             schedule
+	stampFromSchedule bool
+	active            string // ActivePath with placeholders filled in
+}
+
+// Fill in defaults on config and compile its pattern and schedule.
+func newLayout(config *Config) (*layout, error) {
+	if config.FilepathPattern == "" {
+		config.FilepathPattern = "logs/{2006/01/2006-01-02}/log.log"
+	} else if config.PatternSyntax == SyntaxStrftime {
+		p, err := strftimeToPattern(config.FilepathPattern)
+		if err != nil {
+			return nil, err
+		}
+		config.FilepathPattern = p
+		config.PatternSyntax = SyntaxGo
+	}
+	fp, err := parsePattern(config.FilepathPattern)
+	if err != nil {
+		return nil, err
+	}
+	if config.Mode == 0 {
+		config.Mode = 0600
+	}
+	if config.DirMode == 0 {
+		config.DirMode = 02700
+	}
+
+	if (config.layered() || config.HMACKey != nil) && (config.Rollover == RolloverNumbered || config.Lock || config.Flags&(FlagCaptureStdout|FlagCaptureStderr) != 0) {
+		return nil, errors.New("rollinglog: StreamCompress, Encrypter and HMACKey cannot be used with RolloverNumbered, Lock or output capture")
+	}
+	if config.layered() && config.HMACKey != nil {
+		return nil, errors.New("rollinglog: HMACKey cannot be combined with StreamCompress or Encrypter")
+	}
+	if config.ForeignBackups && (config.Rollover == RolloverNumbered || config.customNames()) {
+		return nil, errors.New("rollinglog: ForeignBackups cannot be combined with RolloverNumbered, NameTemplate or Namer")
+	}
+	if config.OpenMode == OpenNewSequence && config.Rollover == RolloverNumbered {
+		return nil, errors.New("rollinglog: OpenNewSequence cannot be used with RolloverNumbered")
+	}
+	if config.OpenMode == OpenTruncate && config.HMACKey != nil {
+		return nil, errors.New("rollinglog: OpenTruncate cannot be used with HMACKey")
+	}
+	if config.CrashOutput && (config.layered() || config.HMACKey != nil) {
+		return nil, errors.New("rollinglog: CrashOutput cannot be combined with StreamCompress, Encrypter or HMACKey")
+	}
+	if config.InlineRotation && (config.Async || config.MaxWriteLatency > 0) {
+		return nil, errors.New("rollinglog: InlineRotation cannot be used with Async or MaxWriteLatency")
+	}
+	if config.NameTemplate != nil && config.Namer != nil {
+		return nil, errors.New("rollinglog: NameTemplate and Namer are mutually exclusive")
+	}
+	if config.HardMaxBytes < 0 {
+		return nil, errors.New("rollinglog: HardMaxBytes must not be negative")
+	}
+	if config.HardMaxBytes > 0 && (config.Rollover == RolloverNumbered || config.CopyTruncate || config.Lock ||
+		config.Footer != nil || config.Timestamp != nil || config.layered() || config.HMACKey != nil) {
+		return nil, errors.New("rollinglog: HardMaxBytes cannot be combined with RolloverNumbered, CopyTruncate, Lock, Footer, Timestamp, StreamCompress, Encrypter or HMACKey")
+	}
+	if config.QuotaPolicy != QuotaPurgeOldest && config.MaxTotalBytes == 0 {
+		return nil, errors.New("rollinglog: QuotaPolicy requires MaxTotalBytes")
+	}
+	if config.AsyncSpill != "" && !config.Async && config.MaxWriteLatency == 0 {
+		return nil, errors.New("rollinglog: AsyncSpill requires Async or MaxWriteLatency")
+	}
+	if (config.AsyncOverflow == OverflowSpill) != (config.AsyncOverflowFile != "") {
+		return nil, errors.New("rollinglog: AsyncOverflow spill requires AsyncOverflowFile, and AsyncOverflowFile requires it")
+	}
+	if config.AsyncOverflowFile != "" && config.AsyncSpill != "" {
+		return nil, errors.New("rollinglog: AsyncOverflowFile cannot be combined with AsyncSpill")
+	}
+	if config.AsyncPriority != nil && config.AsyncSpill != "" {
+		return nil, errors.New("rollinglog: AsyncPriority cannot be combined with AsyncSpill")
+	}
+	if config.CompressLevel < gzip.HuffmanOnly || config.CompressLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("rollinglog: invalid CompressLevel %d", config.CompressLevel)
+	}
+	if config.CompressWorkers < 0 {
+		return nil, errors.New("rollinglog: CompressWorkers must not be negative")
+	}
+	if err := config.Retry.validate("Retry"); err != nil {
+		return nil, err
+	}
+	if config.Lock && !lockSupported {
+		return nil, errors.New("rollinglog: Lock is not supported on this system")
+	}
+	if config.Disambiguate && !lockSupported {
+		return nil, errors.New("rollinglog: Disambiguate is not supported on this system")
+	}
+	if config.Disambiguate && config.Lock {
+		return nil, errors.New("rollinglog: Disambiguate cannot be combined with Lock")
+	}
+	if config.Handoff != "" {
+		if !lockSupported {
+			return nil, errors.New("rollinglog: Handoff is not supported on this system")
+		}
+		if config.OutageBuffer == 0 {
+			return nil, errors.New("rollinglog: Handoff requires OutageBuffer")
+		}
+		if config.Lock || config.Disambiguate {
+			return nil, errors.New("rollinglog: Handoff cannot be combined with Lock or Disambiguate")
+		}
+	}
+	if config.Resolver != nil && !config.Disambiguate {
+		return nil, errors.New("rollinglog: Resolver requires Disambiguate")
+	}
+	if strings.ContainsAny(config.InstanceID, `/\`) {
+		return nil, fmt.Errorf("rollinglog: invalid InstanceID %q", config.InstanceID)
+	}
+	if config.JSONEnvelope && config.PrefixTimestamps {
+		return nil, errors.New("rollinglog: JSONEnvelope cannot be combined with PrefixTimestamps")
+	}
+	if config.DirectIO && (config.Flags&(FlagCaptureStdout|FlagCaptureStderr) != 0 || config.SyncWrites != SyncNone ||
+		config.Lock || config.CopyTruncate || config.layered() || config.HMACKey != nil || config.IndexEvery != 0) {
+		return nil, errors.New("rollinglog: DirectIO cannot be combined with output capture, SyncWrites, Lock, CopyTruncate, StreamCompress, Encrypter, HMACKey or IndexEvery")
+	}
+	if config.FS != nil && config.FS != OSFS && (config.Flags&(FlagCaptureStdout|FlagCaptureStderr) != 0 || config.CrashOutput ||
+		config.StampVersion || config.OnFileOpen != nil || config.Owner != "" || config.Group != "" || config.StrictPerms ||
+		config.DurableCreate || config.Lock || config.Disambiguate || config.CopyTruncate || config.Rollover == RolloverNumbered ||
+		config.DirectIO || config.PreallocateBytes != 0 || config.HMACKey != nil || config.IndexEvery != 0 || config.Checksum ||
+		config.CompressFrom != 0 || config.WatchInterval != 0 || config.MinFreeBytes != 0 || config.ProfileTrigger != nil ||
+		config.RecoverOnStart || config.Manifest || config.Handoff != "" || config.MaxFiles != 0 || config.MaxTotalBytes != 0 || config.MaxBackups != 0) {
+		return nil, errors.New("rollinglog: FS cannot be combined with features that need the operating system's files; see Config.FS")
+	}
+	if config.AppendOnly && !appendOnlySupported {
+		return nil, errors.New("rollinglog: AppendOnly is not supported on this system")
+	}
+	if config.AppendOnly && (config.OpenMode == OpenTruncate || config.CopyTruncate || config.Rollover == RolloverNumbered ||
+		config.SkipEmpty || config.CompressFrom != 0 || config.PreallocateBytes != 0 || config.DirectIO ||
+		config.FS != nil && config.FS != OSFS || config.MinFreeBytes != 0 ||
+		config.MaxFiles != 0 || config.MaxTotalBytes != 0 || config.MaxBackups != 0) {
+		return nil, errors.New("rollinglog: AppendOnly cannot be combined with options that truncate, rename or remove log files; see Config.AppendOnly")
+	}
+	if config.Continuity && (config.Rollover == RolloverNumbered || config.CopyTruncate || config.Lock ||
+		config.HardMaxBytes > 0 || config.SkipEmpty || config.layered()) {
+		return nil, errors.New("rollinglog: Continuity cannot be combined with RolloverNumbered, CopyTruncate, Lock, HardMaxBytes, SkipEmpty, StreamCompress or Encrypter")
+	}
+	var active filePattern
+	if config.Rollover == RolloverRenamed {
+		if config.ActivePath == "" {
+			return nil, errors.New("rollinglog: RolloverRenamed requires ActivePath")
+		}
+		if active, err = parsePattern(config.ActivePath); err != nil {
+			return nil, err
+		}
+		for _, seg := range active {
+			if seg.kind == segmentTime || seg.kind == segmentWeek {
+				return nil, errors.New("rollinglog: ActivePath cannot contain time layouts")
+			}
+		}
+		if config.Dedupe || config.OpenMode == OpenNewSequence || config.CopyTruncate || config.Lock ||
+			config.Disambiguate || config.AppendOnly || config.Timestamp != nil || config.HardMaxBytes > 0 ||
+			config.SkipEmpty || config.PrecreateFile || config.IndexEvery != 0 || config.HMACKey != nil || config.Continuity || config.layered() {
+			return nil, errors.New("rollinglog: RolloverRenamed cannot be combined with Dedupe, OpenNewSequence, CopyTruncate, Lock, Disambiguate, AppendOnly, Timestamp, HardMaxBytes, SkipEmpty, PrecreateFile, IndexEvery, HMACKey, Continuity, StreamCompress or Encrypter")
+		}
+	} else if config.ActivePath != "" {
+		return nil, errors.New("rollinglog: ActivePath requires RolloverRenamed")
+	}
+	if config.SlowWriteThreshold < 0 {
+		return nil, errors.New("rollinglog: SlowWriteThreshold must not be negative")
+	}
+	if config.Manifest && config.layered() {
+		return nil, errors.New("rollinglog: Manifest cannot be combined with StreamCompress or Encrypter")
+	}
+	if config.RecoverOnStart && config.customNames() {
+		return nil, errors.New("rollinglog: RecoverOnStart cannot be combined with NameTemplate or Namer")
+	}
+	if err := checkPressureLevels(config.PressureLevels); err != nil {
+		return nil, err
+	}
+	if config.WriteTimeout < 0 {
+		return nil, errors.New("rollinglog: WriteTimeout must not be negative")
+	}
+	if config.WriteTimeout > 0 && (config.layered() || config.DirectIO) {
+		return nil, errors.New("rollinglog: WriteTimeout cannot be combined with StreamCompress, Encrypter or DirectIO")
+	}
+	if _, ok := checksumHash(config.ChecksumAlgorithm); !ok {
+		return nil, fmt.Errorf("rollinglog: unknown ChecksumAlgorithm %q", config.ChecksumAlgorithm)
+	}
+	if config.FlushInterval < 0 {
+		return nil, errors.New("rollinglog: FlushInterval must not be negative")
+	}
+	if config.PreallocateBytes < 0 {
+		return nil, errors.New("rollinglog: PreallocateBytes must not be negative")
+	}
+	if config.OutageBuffer < 0 {
+		return nil, errors.New("rollinglog: OutageBuffer must not be negative")
+	}
+	if config.RotateJitter < 0 {
+		return nil, errors.New("rollinglog: RotateJitter must not be negative")
+	}
+	if config.PrecreateLead < 0 {
+		return nil, errors.New("rollinglog: PrecreateLead must not be negative")
+	}
+	if config.MaxSize < 0 {
+		return nil, errors.New("rollinglog: MaxSize must not be negative")
+	}
+	if config.IndexEvery < 0 {
+		return nil, errors.New("rollinglog: IndexEvery must not be negative")
+	}
+	if config.layered() && config.IndexEvery != 0 {
+		return nil, errors.New("rollinglog: IndexEvery cannot be combined with StreamCompress or Encrypter")
+	}
+
+	l := &layout{
+		fp:     fp,
+		ph:     newPlaceholders(config.Session),
+		config: config,
+		sched:  dailySchedule{},
+	}
+	if active != nil {
+		l.active = active.format(l.ph, time.Time{})
+	}
+	var scheds unionSchedule
+	if config.RotateAt != "" {
+		s, err := parseRotateAt(config.RotateAt)
+		if err != nil {
+			return nil, err
+		}
+		scheds = append(scheds, s)
+	}
+	if config.RotateCron != "" {
+		s, err := parseCron(config.RotateCron)
+		if err != nil {
+			return nil, err
+		}
+		scheds = append(scheds, s)
+	}
+	switch config.RotatePeriod {
+	case PeriodWeek:
+		start, err := parseWeekStart(config.WeekStart)
+		if err != nil {
+			return nil, err
+		}
+		scheds = append(scheds, weekSchedule{start})
+	case PeriodMonth:
+		scheds = append(scheds, monthSchedule{})
+	}
+	if config.RotateEvery != 0 {
+		if config.RotateEvery < time.Second {
+			return nil, errors.New("rollinglog: RotateEvery must be at least a second")
+		}
+		scheds = append(scheds, everySchedule{config.RotateEvery})
+	}
+	switch {
+	case len(scheds) > 1:
+		l.sched = scheds
+	case len(scheds) == 1:
+		l.sched = scheds[0]
+	case !config.customNames():
+		if d := fp.period(); d != 0 {
+			if d < time.Second && config.MaxFiles == 0 && config.MaxTotalBytes == 0 {
+				return nil, errors.New("rollinglog: sub-second patterns require MaxFiles or MaxTotalBytes")
+			}
+			l.sched = periodSchedule{d}
+		}
+	}
+	l.stampFromSchedule = config.RotateAt != "" || config.RotateCron != "" || config.RotateEvery != 0 ||
+		config.RotatePeriod != PeriodDefault
+	return l, nil
+}
+
+// Reports whether files are written through layers that keep state, so
+// a file cannot be shared or appended to blindly.
+func (config *Config) layered() bool {
+	return config.StreamCompress || config.Encrypter != nil
+}
+
+// Reports whether files are named by something other than FilepathPattern.
+func (config *Config) customNames() bool {
+	return config.NameTemplate != nil || config.Namer != nil
+}
+
+// Reports whether the pattern has no time component.
+func (l *layout) static() bool {
+	for _, seg := range l.fp {
+		if seg.kind == segmentTime || seg.kind == segmentWeek {
+			return false
+		}
+	}
+	return true
+}
+
+// The time used to name the file opened at now.
+func (l *layout) stamp(now time.Time) time.Time {
+	if l.stampFromSchedule {
+		return l.sched.prev(now)
+	}
+	return now
+}
+
+// Path of the file for time t with sequence number seq; 0 is the plain name.
+func (l *layout) name(t time.Time, seq int) (string, error) {
+	if tmpl := l.config.NameTemplate; tmpl != nil {
+		p, err := l.ph.execute(tmpl, t, seq)
+		if err != nil || seq == 0 {
+			return p, err
+		}
+		// templates that ignore .Seq still get a unique name
+		if base, _ := l.ph.execute(tmpl, t, 0); base != p {
+			return p, nil
+		}
+		return sequencePath(p, seq), nil
+	}
+	if namer := l.config.Namer; namer != nil {
+		p := namer.Path(t, seq)
+		if seq != 0 && p == namer.Path(t, 0) {
+			return sequencePath(p, seq), nil
+		}
+		return p, nil
+	}
+	return patternNamer{l.fp, l.ph}.Path(t, seq), nil
+}
+
+// Path of the first file of the period containing t.
+func (l *layout) pathFor(t time.Time) (string, error) {
+	return l.name(l.stamp(t), 0)
+}
+
+// Path of the file being written at t: ActivePath with RolloverRenamed,
+// otherwise the plain name of t's period.
+func (l *layout) activePath(t time.Time) (string, error) {
+	if l.active != "" {
+		return l.active, nil
+	}
+	return l.pathFor(t)
+}
+
+// Scheduled rotations to the path already being written, as with a pattern
+// coarser than the schedule or the hour repeated when DST ends, would close
+// and reopen the same file; at most this many of them are skipped.
+const maxSamePath = 10000
+
+// The first scheduled rotation after now of the file whose plain name is
+// base that moves on to another path.
+func (l *layout) nextRotation(now time.Time, base string) time.Time {
+	next := l.sched.next(now)
+	if l.config.Rollover != RolloverDated {
+		return next
+	}
+	for i := 0; i < maxSamePath; i++ {
+		if p, err := l.pathFor(next); err != nil || p != base {
+			break
+		}
+		next = l.sched.next(next)
+	}
+	return next
+}
+
+// How far ahead, and how finely, VerifyUnique samples file names.
+const (
+	verifySpan = 366 * 24 * time.Hour
+	verifyStep = time.Hour
+)
+
+// VerifyUnique reports an error if two of the given configs would write to
+// the same file. File names are sampled hourly over the coming year, so
+// configs that only collide on some dates are caught as well.
+func VerifyUnique(configs ...Config) error {
+	type owner struct {
+		index int
+		when  time.Time
+	}
+	seen := make(map[string]owner)
+	start := time.Now().Truncate(verifyStep)
+	for i := range configs {
+		config := configs[i]
+		l, err := newLayout(&config)
+		if err != nil {
+			return fmt.Errorf("rollinglog: config %d: %v", i, err)
+		}
+		if l.active != "" {
+			if prev, ok := seen[l.active]; ok {
+				return fmt.Errorf("rollinglog: configs %d and %d both write %s", prev.index, i, l.active)
+			}
+			seen[l.active] = owner{i, start}
+		}
+		for t := start; t.Before(start.Add(verifySpan)); t = t.Add(verifyStep) {
+			p, err := l.pathFor(t)
+			if err != nil {
+				return fmt.Errorf("rollinglog: config %d: %v", i, err)
+			}
+			if prev, ok := seen[p]; !ok {
+				seen[p] = owner{i, t}
+			} else if prev.index != i {
+				return fmt.Errorf("rollinglog: configs %d and %d both write %s (at %s and %s)",
+					prev.index, i, p, prev.when.Format(time.RFC3339), t.Format(time.RFC3339))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync/atomic"
+)
+
+// A Classifier reports the severity of a record.
+type Classifier func(p []byte) slog.Level
+
+// How far into a record ClassifyLevel looks for its level.
+const classifyWindow = 128
+
+// Level names ClassifyLevel recognises, upper case.
+var levelNames = map[string]slog.Level{
+	"TRACE":    slog.LevelDebug - 4,
+	"DEBUG":    slog.LevelDebug,
+	"INFO":     slog.LevelInfo,
+	"NOTICE":   slog.LevelInfo + 2,
+	"WARN":     slog.LevelWarn,
+	"WARNING":  slog.LevelWarn,
+	"ERROR":    slog.LevelError,
+	"ERR":      slog.LevelError,
+	"CRITICAL": slog.LevelError + 4,
+	"FATAL":    slog.LevelError + 4,
+	"PANIC":    slog.LevelError + 8,
+}
+
+// ClassifyLevel is the default Classifier. It returns the level named by
+// the first level name near the start of the record that is written in
+// upper case, as slog's text and JSON handlers and most loggers do, or in
+// any case after level=, "level":" or [. Records without one are
+// slog.LevelInfo.
+func ClassifyLevel(p []byte) slog.Level {
+	if len(p) > classifyWindow {
+		p = p[:classifyWindow]
+	}
+	isWord := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for i := 0; i < len(p); {
+		if !isWord(p[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(p) && isWord(p[j]) {
+			j++
+		}
+		word := p[i:j]
+		if len(word) <= len("CRITICAL") && (bytes.Equal(word, bytes.ToUpper(word)) || levelMarked(p[:i])) {
+			if level, ok := levelNames[string(bytes.ToUpper(word))]; ok {
+				return level
+			}
+		}
+		i = j
+	}
+	return slog.LevelInfo
+}
+
+// Reports whether the text before a word marks it as a level.
+func levelMarked(before []byte) bool {
+	for _, mark := range []string{"level=", `"level":"`, "["} {
+		if bytes.HasSuffix(bytes.ToLower(before), []byte(mark)) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Route sends the records of at least level Min to Writer.
+type Route struct {
+	Min    slog.Level
+	Writer io.Writer
+
+	// Final stops the records this route takes from reaching later
+	// routes.
+	Final bool
+}
+
+// A Router writes each record to the writers of the routes whose level it
+// meets, in order, such as errors to one Writer and everything to
+// another. Each Write is one record. Safe for concurrent use if the
+// writers are.
+type Router struct {
+	classify Classifier
+	routes   []Route
+}
+
+// NewRouter returns a Router classifying records with classify, or
+// ClassifyLevel if it is nil.
+func NewRouter(classify Classifier, routes ...Route) *Router {
+	if classify == nil {
+		classify = ClassifyLevel
+	}
+	return &Router{classify: classify, routes: routes}
+}
+
+// Write routes p by the level its Classifier reports.
+func (r *Router) Write(p []byte) (int, error) {
+	return r.WriteLevel(r.classify(p), p)
+}
+
+// WriteLevel routes p as a record of the given level. All matching routes
+// are written even if one fails; the first error is returned.
+func (r *Router) WriteLevel(level slog.Level, p []byte) (int, error) {
+	var err error
+	for _, route := range r.routes {
+		if level < route.Min {
+			continue
+		}
+		if _, werr := route.Writer.Write(p); werr != nil && err == nil {
+			err = werr
+		}
+		if route.Final {
+			break
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the writers of the routes that are io.Closers, each once.
+func (r *Router) Close() error {
+	var err error
+	var closed []io.Closer
+	for _, route := range r.routes {
+		c, ok := route.Writer.(io.Closer)
+		if !ok || slices.ContainsFunc(closed, func(d io.Closer) bool { return sameWriter(c, d) }) {
+			continue
+		}
+		closed = append(closed, c)
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reports whether a and b are the same writer, without panicking on
+// values that cannot be compared.
+func sameWriter(a, b io.Closer) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// A LevelFilter passes records of at least a minimum level on to a
+// writer and drops the rest, so one producer can feed a verbose log and,
+// through a LevelFilter, a second one with only the warnings. Each Write
+// is one record. Safe for concurrent use if the writer is.
+type LevelFilter struct {
+	w        io.Writer
+	min      slog.Leveler
+	classify Classifier
+}
+
+// NewLevelFilter returns a LevelFilter writing the records at or above
+// min to w. min may be a *slog.LevelVar to change it while in use.
+// Records are classified with classify, or ClassifyLevel if it is nil.
+func NewLevelFilter(w io.Writer, min slog.Leveler, classify Classifier) *LevelFilter {
+	if classify == nil {
+		classify = ClassifyLevel
+	}
+	return &LevelFilter{w: w, min: min, classify: classify}
+}
+
+// Write passes p on if its Classifier puts it at or above the minimum
+// level. A dropped record is reported as written.
+func (f *LevelFilter) Write(p []byte) (int, error) {
+	return f.WriteLevel(f.classify(p), p)
+}
+
+// WriteLevel passes p on if level is at or above the minimum.
+func (f *LevelFilter) WriteLevel(level slog.Level, p []byte) (int, error) {
+	if level < f.min.Level() {
+		return len(p), nil
+	}
+	return f.w.Write(p)
+}
+
+// A Sampler passes a fraction of the records of each level on to a
+// writer, such as every error but a tenth of the debug records, so that
+// verbose logging can stay enabled without filling the disk. Records are
+// kept evenly spread rather than at random: at a rate of 0.1, one in ten.
+// Each Write is one record. Safe for concurrent use if the writer is.
+type Sampler struct {
+	w        io.Writer
+	classify Classifier
+	levels   map[slog.Level]*sampleState
+}
+
+type sampleState struct {
+	rate    float64
+	seen    atomic.Int64
+	dropped atomic.Int64
+}
+
+// SampleCount is what a Sampler has done with the records of one level.
+type SampleCount struct {
+	Seen    int64
+	Dropped int64
+}
+
+// NewSampler returns a Sampler writing to w the fraction rates gives, from
+// 0 to 1, of the records of each level; levels without a rate are always
+// written. Records are classified with classify, or ClassifyLevel if it is
+// nil.
+func NewSampler(w io.Writer, classify Classifier, rates map[slog.Level]float64) *Sampler {
+	if classify == nil {
+		classify = ClassifyLevel
+	}
+	s := &Sampler{w: w, classify: classify, levels: make(map[slog.Level]*sampleState, len(rates))}
+	for level, rate := range rates {
+		s.levels[level] = &sampleState{rate: min(max(rate, 0), 1)}
+	}
+	return s
+}
+
+// Write passes p on if its level's rate allows. A dropped record is
+// reported as written.
+func (s *Sampler) Write(p []byte) (int, error) {
+	return s.WriteLevel(s.classify(p), p)
+}
+
+// WriteLevel passes p on, as a record of the given level, if the level's
+// rate allows.
+func (s *Sampler) WriteLevel(level slog.Level, p []byte) (int, error) {
+	if st := s.levels[level]; st != nil {
+		n := float64(st.seen.Add(1))
+		if int64(n*st.rate) == int64((n-1)*st.rate) {
+			st.dropped.Add(1)
+			return len(p), nil
+		}
+	}
+	return s.w.Write(p)
+}
+
+// Counts returns, for each level given a rate, the records seen and
+// dropped so far.
+func (s *Sampler) Counts() map[slog.Level]SampleCount {
+	counts := make(map[slog.Level]SampleCount, len(s.levels))
+	for level, st := range s.levels {
+		counts[level] = SampleCount{Seen: st.seen.Load(), Dropped: st.dropped.Load()}
+	}
+	return counts
+}
@@ -0,0 +1,180 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogFile describes a file found by List.
+type LogFile struct {
+	Path       string
+	Period     time.Time // time encoded in the name; zero without one
+	Seq        int       // Dedupe sequence number or rotatelogs generation, 0 for the plain name
+	Size       int64
+	ModTime    time.Time
+	Compressed bool
+}
+
+// List returns every file config's pattern could have produced that
+// exists on disk, oldest first. Files are ordered by the time in their
+// names, then by sequence number. With RolloverNumbered, or a pattern
+// without a time component, Period is zero and files are ordered from the
+// oldest backup to the active file. With RolloverRenamed the active file
+// comes last, with a zero Period. Sidecars and files whose names the
+// pattern cannot produce are left out, except that with ForeignBackups the
+// backups of other tooling come first. Configs with a NameTemplate or Namer
+// cannot be listed, as the names they produce cannot be matched.
+func List(config Config) ([]LogFile, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	if config.customNames() {
+		return nil, errors.New("rollinglog: files named by NameTemplate or Namer cannot be listed")
+	}
+	return listFiles(l)
+}
+
+func listFiles(l *layout) ([]LogFile, error) {
+	config := l.config
+	if config.Rollover == RolloverNumbered {
+		paths, err := numberedFiles(l, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		var files []LogFile
+		for _, p := range paths {
+			if lf, ok := statLogFile(p); ok {
+				files = append(files, lf)
+			}
+		}
+		return files, nil
+	}
+
+	glob := l.fp.glob(l.ph)
+	matches, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	if config.Dedupe || config.Rollover == RolloverRenamed {
+		ext := path.Ext(glob)
+		if strings.ContainsRune(ext, '/') {
+			ext = ""
+		}
+		seqs, err := filepath.Glob(glob[:len(glob)-len(ext)] + "-[0-9][0-9][0-9]*" + ext)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, seqs...)
+	}
+	var files []LogFile
+	seen := make(map[string]bool)
+	if config.ForeignBackups {
+		foreign, err := foreignFiles(l)
+		if err != nil {
+			return nil, err
+		}
+		for _, ff := range foreign {
+			files = append(files, ff.LogFile)
+		}
+	}
+	n := len(files)
+	for _, p := range matches {
+		if isSidecar(p) || seen[p] {
+			continue
+		}
+		seen[p] = true
+		base, seq := p, 0
+		t, ok := l.fp.parse(l.ph, p)
+		if !ok {
+			if base, seq = splitSequence(p); seq == 0 {
+				continue
+			}
+			if t, ok = l.fp.parse(l.ph, base); !ok {
+				continue
+			}
+		}
+		if lf, ok := statLogFile(p); ok {
+			lf.Period, lf.Seq = t, seq
+			files = append(files, lf)
+		}
+	}
+	native := files[n:]
+	sort.Slice(native, func(i, j int) bool {
+		if !native[i].Period.Equal(native[j].Period) {
+			return native[i].Period.Before(native[j].Period)
+		}
+		if native[i].Seq != native[j].Seq {
+			return native[i].Seq < native[j].Seq
+		}
+		return native[i].Path < native[j].Path
+	})
+	if l.active != "" && !seen[l.active] {
+		if lf, ok := statLogFile(l.active); ok {
+			files = append(files, lf)
+		}
+	}
+	return files, nil
+}
+
+func statLogFile(p string) (LogFile, bool) {
+	fi, err := os.Stat(p)
+	if err != nil || !fi.Mode().IsRegular() {
+		return LogFile{}, false
+	}
+	return LogFile{
+		Path:       p,
+		Size:       fi.Size(),
+		ModTime:    fi.ModTime(),
+		Compressed: strings.HasSuffix(p, ".gz"),
+	}, true
+}
+
+// Undo sequencePath, returning the plain name and the sequence number, or
+// 0 if p has none.
+func splitSequence(p string) (string, int) {
+	ext := path.Ext(p)
+	if strings.ContainsRune(ext, '/') {
+		ext = ""
+	}
+	stem := p[:len(p)-len(ext)]
+	i := strings.LastIndexByte(stem, '-')
+	if i == -1 || len(stem)-i-1 < 3 {
+		return p, 0
+	}
+	seq, err := strconv.Atoi(stem[i+1:])
+	if err != nil || seq < 1 {
+		return p, 0
+	}
+	return stem[:i] + ext, seq
+}
@@ -25,13 +25,20 @@
 package rollinglog
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"log"
 	"os"
 	"path"
-	"regexp"
-	"syscall"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
+	"unsafe"
 )
 
 const (
@@ -39,18 +46,672 @@ const (
 	FlagCaptureStderr
 )
 
-var (
-	pattern = regexp.MustCompile("{.*}")
-)
-
 type Config struct {
-	FilepathPattern string
-	Mode            os.FileMode
-	DirMode         os.FileMode
-	Flags           uint
+	FilepathPattern string      `json:"filepath_pattern" yaml:"filepath_pattern"`
+	Mode            os.FileMode `json:"mode" yaml:"mode"`
+	DirMode         os.FileMode `json:"dir_mode" yaml:"dir_mode"`
+	Flags           uint        `json:"flags" yaml:"flags"`
+
+	// PatternSyntax selects how FilepathPattern is interpreted. The default
+	// is SyntaxGo.
+	PatternSyntax PatternSyntax `json:"pattern_syntax" yaml:"pattern_syntax"`
+
+	// Owner and Group, if set, are given to the log files and directories
+	// the writer creates, each as a name or a numeric ID. Changing the
+	// owner usually requires root; failures are logged.
+	Owner string `json:"owner" yaml:"owner"`
+	Group string `json:"group" yaml:"group"`
+
+	// StrictPerms sets Mode and DirMode on the files and directories the
+	// writer creates with chmod, so they are exactly as configured rather
+	// than reduced by the umask. Setuid, setgid and sticky bits written in
+	// octal, like the setgid bit of the default DirMode of 02700, are only
+	// applied this way.
+	StrictPerms bool `json:"strict_perms" yaml:"strict_perms"`
+
+	// DurableCreate fsyncs the parent directory of every file and
+	// directory the writer creates, so a crash shortly after a rotation
+	// cannot lose the new file's directory entry.
+	DurableCreate bool `json:"durable_create" yaml:"durable_create"`
+
+	// AppendOnly sets the append-only attribute on each log file, as
+	// chattr +a does on Linux and chflags uappend on macOS and the BSDs,
+	// so that what has been logged cannot be rewritten or removed, not
+	// even by the file's owner. Setting it takes privileges, such as
+	// CAP_LINUX_IMMUTABLE on Linux, and failures are reported like other
+	// background errors. Such files can only grow, so it cannot be
+	// combined with OpenTruncate, CopyTruncate, RolloverNumbered,
+	// SkipEmpty, CompressFrom, PreallocateBytes, DirectIO, FS,
+	// MinFreeBytes or the MaxFiles, MaxTotalBytes and MaxBackups retention
+	// limits, and it is refused on other systems.
+	AppendOnly bool `json:"append_only" yaml:"append_only"`
+
+	// SyncWrites opens log files for synchronous writes, so each record is
+	// on stable storage before Write returns, at a large cost in
+	// throughput. See SyncMode.
+	SyncWrites SyncMode `json:"sync_writes" yaml:"sync_writes"`
+
+	// OpenMode chooses between appending to, truncating, or starting a
+	// new sequence beside the current period's file when it already
+	// exists as the writer starts. Later files are always appended to.
+	// With Dedupe, StreamCompress or Encrypter every file already starts
+	// a new sequence.
+	OpenMode OpenMode `json:"open_mode" yaml:"open_mode"`
+
+	// PrecreateLead, if non-zero, creates the directory of the next
+	// period's file this long before the rotation is due, so permission
+	// and quota problems are logged while someone is around to fix them
+	// rather than at the first write after midnight. PrecreateFile creates
+	// the empty file as well; it is ignored with Dedupe, StreamCompress and
+	// Encrypter, whose files must not exist before they are opened.
+	PrecreateLead time.Duration `json:"precreate_lead" yaml:"precreate_lead"`
+	PrecreateFile bool          `json:"precreate_file" yaml:"precreate_file"`
+
+	// SkipEmpty removes a file that no record was written to when its
+	// period ends or the writer is closed, rather than keeping it and
+	// handing it to PostRotate and the Archiver, so idle periods leave
+	// nothing behind. A header and footer alone do not count as records,
+	// but a file that already held data is kept. Retention also removes
+	// empty files left by earlier runs. It has no effect on the files of
+	// RolloverNumbered, where an empty file is never shifted.
+	SkipEmpty bool `json:"skip_empty" yaml:"skip_empty"`
+
+	// NameTemplate, if set, is used instead of FilepathPattern to name each
+	// file. It is executed with a NameData value, for example:
+	//	logs/{{.Now.Format "2006/01"}}/{{.Hostname}}-{{.Seq}}.log
+	NameTemplate *template.Template `json:"-" yaml:"-"`
+
+	// Namer, if set, names each file instead of FilepathPattern, for
+	// schemes the pattern syntax cannot express; see NewPatternNamer for
+	// the default. FilepathPattern can still be set to a glob-compatible
+	// pattern for retention. Namer and NameTemplate may not both be set.
+	Namer Namer `json:"-" yaml:"-"`
+
+	// DegradeAfter, if non-zero, is the number of consecutive failed writes
+	// after which the log falls back to writing on Fallback (os.Stderr by
+	// default) instead of returning errors. While degraded, the file path
+	// is re-opened every ProbeInterval (default one minute) and writing to
+	// the file resumes as soon as it succeeds again. This also allows New
+	// to succeed when the first file cannot be opened. Setting Fallback
+	// alone implies a DegradeAfter of 3.
+	DegradeAfter  int           `json:"degrade_after" yaml:"degrade_after"`
+	ProbeInterval time.Duration `json:"probe_interval" yaml:"probe_interval"`
+	Fallback      io.Writer     `json:"-" yaml:"-"`
+
+	// OutageBuffer, if non-zero, holds up to this many bytes of records
+	// in memory while no file can be written, say during a remount or a
+	// permission race at boot, and writes them to the file once one opens
+	// instead of failing each Write. New succeeds even if the first file
+	// cannot be opened. Records that do not fit are handled as failed
+	// writes, falling back as DegradeAfter says, and counted in
+	// Stats.OutageDropped; anything still held at Close is lost.
+	OutageBuffer int64 `json:"outage_buffer" yaml:"outage_buffer"`
+
+	// Handoff, if set, is the path of a lock file that lets a restarted
+	// process take over the log from the one it replaces without
+	// interleaving or losing lines. The writer holding the lock writes
+	// the log and lets go of it once Close has written out everything;
+	// a new writer that finds the lock held holds its records in
+	// OutageBuffer, which must be set, until then, and appends them to
+	// the same file. The outgoing process still has to be told to stop,
+	// as by its supervisor. It cannot be combined with Lock or
+	// Disambiguate, which share the log instead, and needs file locks,
+	// which AIX, Solaris and Windows lack.
+	Handoff string `json:"handoff" yaml:"handoff"`
+
+	// Retry, if its Base is set, governs every retry made in the
+	// background: opening a file and its directory after a failure
+	// (instead of every ProbeInterval), compressing backups and calling
+	// Archiver. Once Retry.MaxAttempts fail, a file that cannot be opened
+	// is given up on, leaving the writer degraded or failed, and a backup
+	// stays uncompressed or unarchived. Archiving is retried between the
+	// other rotation work, so rotation is not held up and no goroutine is
+	// spent waiting; Close abandons the retries still pending. A Pipeline
+	// archiver keeps its own retries.
+	Retry Backoff `json:"retry" yaml:"retry"`
+
+	// RotateAt moves the daily rotation from midnight to the given local
+	// time of day, written as "HH:MM" or "HH:MM:SS". When set, file names
+	// are formatted from the time of the rotation that started the file, so
+	// with "04:00" a write at 02:00 still lands in the previous day's file.
+	RotateAt string `json:"rotate_at" yaml:"rotate_at"`
+
+	// RotateCron drives rotation from a five field cron specification such
+	// as "0 */6 * * 1-5" instead of once a day. As with RotateAt, file names
+	// are formatted from the scheduled rotation time. With both RotateCron
+	// and RotateAt set, files rotate at the boundaries of either.
+	RotateCron string `json:"rotate_cron" yaml:"rotate_cron"`
+
+	// RotateEvery, if non-zero, rotates at fixed intervals such as 15m or
+	// 6h, at multiples of the interval since the Unix epoch, so instances
+	// cut at the same moments whenever they started. As with RotateCron,
+	// file names are formatted from the boundary time, so the pattern
+	// should be fine enough to tell the files apart, and it combines with
+	// RotateAt and RotateCron. It must be at least a second.
+	RotateEvery time.Duration `json:"rotate_every" yaml:"rotate_every"`
+
+	// RotatePeriod, if PeriodWeek or PeriodMonth, rotates once a week or
+	// once a month at midnight instead of daily, so that low-volume logs
+	// are not spread over a file a day. Weeks start on WeekStart, a day
+	// name such as "sunday", or Monday if empty. File names are formatted
+	// from the start of the period: {2006-01} names monthly files and
+	// {2006-W04} is replaced with the ISO year and week of that day, as in
+	// 2026-W42. It combines with RotateAt, RotateCron and RotateEvery.
+	RotatePeriod RotatePeriod `json:"rotate_period" yaml:"rotate_period"`
+	WeekStart    string       `json:"week_start" yaml:"week_start"`
+
+	// RotateJitter, if non-zero, delays each scheduled rotation by a
+	// random amount up to this long, so that a fleet of instances does not
+	// rotate, and upload, all at once. Records written in the meantime
+	// still go to the old file. It should be well below the period.
+	RotateJitter time.Duration `json:"rotate_jitter" yaml:"rotate_jitter"`
+
+	// MaxSize, if non-zero, also rotates the active file once this many
+	// bytes have been written to it, counted before StreamCompress and
+	// Encrypter, alongside the schedule. Within one period the replacement
+	// takes the next Dedupe sequence name. OnRotate is called with every
+	// rotated file and the reason it was rotated, before PostRotate.
+	MaxSize  int64                                  `json:"max_size" yaml:"max_size"`
+	OnRotate func(path string, reason RotateReason) `json:"-" yaml:"-"`
+
+	// RotationPolicy, if set, can rotate the active file at other times
+	// as well. Its rotations are named like those for MaxSize.
+	RotationPolicy RotationPolicy `json:"-" yaml:"-"`
+
+	// HardMaxBytes, if non-zero, guarantees that no file grows past this
+	// many bytes. Unlike MaxSize it does not wait for the rotation
+	// goroutine: a write that would overflow the file is cut over to the
+	// next Dedupe sequence file there and then, and a record that cannot
+	// fit in a whole file is split across several. Cut files are rotated
+	// with RotateHardLimit. It cannot be combined with RolloverNumbered,
+	// CopyTruncate, Lock, Footer, Timestamp, StreamCompress, Encrypter or
+	// HMACKey, which all leave the writer unsure of how big the file is or
+	// where its data goes.
+	HardMaxBytes int64 `json:"hard_max_bytes" yaml:"hard_max_bytes"`
+
+	// InlineRotation does away with the background goroutine: Write
+	// compares the clock with the next deadline and does any rotation
+	// work that is due itself, hooks and Archiver included, before
+	// writing. Nothing happens between writes, so a file is only rotated,
+	// watched or reopened when it is next written to. It suits short-lived
+	// programs and cannot be combined with Async or MaxWriteLatency.
+	InlineRotation bool `json:"inline_rotation" yaml:"inline_rotation"`
+
+	// Scheduler, if not nil, drives the writer's rotation along with the
+	// other writers registered with it, such as those of SharedScheduler,
+	// instead of a goroutine of the writer's own. One made by
+	// NewPooledScheduler also bounds their compression, archiving and
+	// pruning together.
+	Scheduler *Scheduler `json:"-" yaml:"-"`
+
+	// Clock, if not nil, stands in for the system clock in naming,
+	// scheduling and timestamping files, so that tests can rotate without
+	// waiting; see the rollinglogtest package. Writers on a Scheduler go by
+	// its clock instead, and the Scheduler a Manager creates for itself
+	// goes by the Manager's Clock.
+	Clock Clock `json:"-" yaml:"-"`
+
+	// FS, if not nil, holds the writer's files instead of the operating
+	// system's filesystem, as for in-memory tests or virtual backends. It
+	// only covers creating, renaming and removing files, so it cannot be
+	// combined with the features that work on file descriptors or list
+	// directories: output capture, CrashOutput, StampVersion, OnFileOpen,
+	// Owner, Group, StrictPerms, DurableCreate, Lock, Disambiguate,
+	// CopyTruncate, RolloverNumbered, DirectIO, PreallocateBytes, HMACKey,
+	// IndexEvery, Checksum, CompressFrom, WatchInterval, MinFreeBytes,
+	// ProfileTrigger, RecoverOnStart, Manifest, Handoff and the MaxFiles,
+	// MaxTotalBytes and MaxBackups retention limits.
+	FS FS `json:"-" yaml:"-"`
+
+	// CrashOutput makes each new file the destination of the runtime's
+	// fatal error reports, such as unrecovered panics, in addition to
+	// stderr, using debug.SetCrashOutput. Only one file per process can
+	// receive them, so the writer that opened a file most recently wins.
+	// It cannot be combined with StreamCompress, Encrypter or HMACKey.
+	CrashOutput bool `json:"crash_output" yaml:"crash_output"`
+
+	// Without RotateAt, RotateCron or RotateEvery, a pattern whose finest time element
+	// is smaller than a day rotates whenever that element changes: hourly
+	// for {2006-01-02-15}, every millisecond for {150405.000}. Sub-second
+	// patterns must set MaxFiles or MaxTotalBytes, which bound the files
+	// matching FilepathPattern by deleting the oldest after each rotation.
+	// The active file counts towards both limits but is never deleted.
+	MaxFiles      int   `json:"max_files" yaml:"max_files"`
+	MaxTotalBytes int64 `json:"max_total_bytes" yaml:"max_total_bytes"`
+
+	// QuotaPolicy selects how MaxTotalBytes is kept to: by deleting the
+	// oldest files, the default, by refusing writes, or by compressing the
+	// oldest files instead. Compressed files count towards the quota.
+	QuotaPolicy QuotaPolicy `json:"quota_policy" yaml:"quota_policy"`
+
+	// Dedupe starts a new file instead of appending when the target path
+	// already exists, for example after a restart. The new file gets a
+	// sequence suffix before its extension: app.log, app-001.log,
+	// app-002.log. A NameTemplate referencing .Seq controls the name itself.
+	Dedupe bool `json:"dedupe" yaml:"dedupe"`
+
+	// Rollover selects how files are rotated. With RolloverNumbered the
+	// active file keeps the name produced by FilepathPattern (which then
+	// usually has no time tokens) and each rotation shifts older files to
+	// numbered suffixes: app.log becomes app.log.1, app.log.1 becomes
+	// app.log.2 and so on. MaxBackups limits how many numbered files are
+	// kept (0 keeps all of them) and a non-zero CompressFrom gzips backups
+	// numbered CompressFrom and above.
+	//
+	// With RolloverRenamed the active file is always ActivePath, such as
+	// logs/server.log, for tail -f and agents that expect a constant path.
+	// Each rotation renames it to the path FilepathPattern gives its
+	// period, with a sequence suffix if that is taken, and opens a fresh
+	// one; an active file left from an earlier period is renamed when the
+	// writer starts. ActivePath may use the placeholders of FilepathPattern
+	// other than time layouts. RolloverRenamed cannot be combined with
+	// Dedupe, OpenNewSequence, CopyTruncate, Lock, Disambiguate,
+	// AppendOnly, Timestamp, HardMaxBytes, SkipEmpty, PrecreateFile,
+	// IndexEvery, HMACKey, Continuity, StreamCompress or Encrypter.
+	Rollover     RolloverMode `json:"rollover" yaml:"rollover"`
+	MaxBackups   int          `json:"max_backups" yaml:"max_backups"`
+	CompressFrom int          `json:"compress_from" yaml:"compress_from"`
+	ActivePath   string       `json:"active_path" yaml:"active_path"`
+
+	// ForeignBackups makes List, Open and Export include the backups other
+	// tooling left of the files FilepathPattern names, so archives from
+	// before a migration stay readable: lumberjack's
+	// app-2006-01-02T15-04-05.000.log, gzipped or not, with its time read
+	// as UTC, and rotatelogs' app.log.1 generations and app.log.1136214245
+	// Unix time suffixes. They come before the files the writer produced;
+	// retention leaves them alone. ForeignBackups cannot be combined with
+	// RolloverNumbered, whose backups look alike, or with a NameTemplate
+	// or Namer.
+	ForeignBackups bool `json:"foreign_backups" yaml:"foreign_backups"`
+
+	// Backups due for compression are compressed in the background by up
+	// to CompressWorkers goroutines (default 1), so a backlog, say after
+	// downtime, does not hold up rotation; the next rotation waits for
+	// them to finish. With Lock they are compressed before the rotation
+	// lock is released instead. CompressLevel is the gzip level, from
+	// gzip.HuffmanOnly to gzip.BestCompression; 0 means the default. Both
+	// also apply to QuotaCompressInPlace and to Maintain.
+	CompressWorkers int `json:"compress_workers" yaml:"compress_workers"`
+	CompressLevel   int `json:"compress_level" yaml:"compress_level"`
+
+	// CopyTruncate makes RolloverNumbered copy the active file to app.log.1
+	// and truncate it in place rather than renaming it, so the active path
+	// keeps its inode for other processes holding it open. Lines written
+	// between the copy and the truncate are lost, as with logrotate.
+	CopyTruncate bool `json:"copy_truncate" yaml:"copy_truncate"`
+
+	// Timestamp, if set, extracts the time a record was produced from the
+	// bytes passed to Write. Records for which it reports a time are routed
+	// to the file of that time's period instead of the current one, so
+	// replayed or forwarded events land in the correctly dated file.
+	Timestamp func(p []byte) (time.Time, bool) `json:"-" yaml:"-"`
+
+	// WatchInterval, if non-zero, is how often the active path is checked
+	// with stat. When the file has been removed or replaced by another
+	// process, it is reopened so that logging reappears on disk.
+	WatchInterval time.Duration `json:"watch_interval" yaml:"watch_interval"`
+
+	// Lock takes an exclusive advisory flock on the file around every write,
+	// so several processes can share one pattern without interleaving
+	// records. Rotation work such as numbered shifting and compression is
+	// then guarded by a <path>.lock file so only one process performs it.
+	// It needs flock, which AIX, Solaris and Windows lack.
+	Lock bool `json:"lock" yaml:"lock"`
+
+	// Disambiguate guards against replicas on shared storage, such as NFS,
+	// interleaving their writes in one file: each file is locked as it is
+	// opened, and if another process already holds the lock the writer
+	// uses an instance file of its own beside it instead, named with
+	// InstanceID, or the host name and process id, before the extension,
+	// as in app.web1-1234.log. It cannot be combined with Lock, and needs
+	// flock support, which NFS provides through its lock manager; New
+	// rejects it on AIX, Solaris and Windows, which have none. A restarted
+	// process finds the lock of the one it replaces gone and takes the
+	// same file back.
+	//
+	// Resolver, if set, picks the file to use instead, such as one with
+	// another suffix or in a subdirectory, from the path that is taken.
+	// If its choice is taken too, it is asked again with that. It needs
+	// Disambiguate.
+	Disambiguate bool                     `json:"disambiguate" yaml:"disambiguate"`
+	InstanceID   string                   `json:"instance_id" yaml:"instance_id"`
+	Resolver     func(path string) string `json:"-" yaml:"-"`
+
+	// PreallocateBytes, if non-zero, reserves this much disk for each file
+	// as it is opened, without changing its size, which reduces
+	// fragmentation and reports a full disk at rotation, through the
+	// Errors channel, rather than partway through the day. Space not used
+	// is given back when the file is closed, except with Lock, where other
+	// processes may still be appending. It needs fallocate, so it only
+	// has an effect on Linux, on filesystems that support it.
+	PreallocateBytes int64 `json:"preallocate_bytes" yaml:"preallocate_bytes"`
+
+	// DirectIO writes files with O_DIRECT, bypassing the page cache, so a
+	// very high-volume log does not evict data that is more useful to keep
+	// cached. Records are gathered into aligned blocks of 256KB before
+	// being written, so up to that much can be lost in a crash and readers
+	// of the active file lag behind; what is left is written when the
+	// file is closed. It is only available on Linux and FreeBSD and cannot
+	// be combined with output capture, ActiveFile, SyncWrites, Lock,
+	// CopyTruncate, StreamCompress, Encrypter, HMACKey or IndexEvery.
+	DirectIO bool `json:"direct_io" yaml:"direct_io"`
+
+	// RecentBytes, if non-zero, keeps the last RecentBytes bytes of records
+	// passed to Write in memory, available from Writer.Recent.
+	RecentBytes int `json:"recent_bytes" yaml:"recent_bytes"`
+
+	// OnFileOpen is called with every newly opened file before anything is
+	// written to it, for example to set fcntl flags or write a header.
+	// OnFileClose is called after a file has been closed, whether due to
+	// rotation or Close. Neither may call back into the Writer.
+	OnFileOpen  func(f *os.File, path string)      `json:"-" yaml:"-"`
+	OnFileClose func(path string, stats FileStats) `json:"-" yaml:"-"`
+
+	// Header, if set, writes the opening lines of every new file, such as
+	// the program version, host, PID and start time. It is not called for
+	// an existing file the writer appends to.
+	Header func(w io.Writer) error `json:"-" yaml:"-"`
+
+	// Footer, if set, writes the closing lines of the active file when it
+	// is rotated away from or the writer is closed, so complete files can
+	// be told from truncated ones. next is the file taking over, empty on
+	// Close. It is not used with CopyTruncate, where the file is emptied
+	// rather than retired.
+	Footer func(w io.Writer, next string) error `json:"-" yaml:"-"`
+
+	// Continuity links rotated files together with lines of JSON, so
+	// tools reassembling a sequence can tell that none is missing or
+	// altered; see VerifyContinuity. After any Header, each new file
+	// records the path of the file it replaces and that file's size and
+	// SHA-256 so far, and after any Footer the replaced file records the
+	// path of its successor:
+	//
+	//	{"rollinglog":"continuity","prev":"logs/a.log","size":1234,"sha256":"9f86…"}
+	//	{"rollinglog":"continuity","next":"logs/b.log"}
+	//
+	// The first file a writer opens has no record of the one before. It
+	// cannot be combined with RolloverNumbered, CopyTruncate, Lock,
+	// HardMaxBytes, SkipEmpty, StreamCompress or Encrypter.
+	Continuity bool `json:"continuity" yaml:"continuity"`
+
+	// PrefixTimestamps starts every line written to the file with the time
+	// it was written, formatted with PrefixFormat (default
+	// "2006-01-02T15:04:05.000Z07:00") and followed by a space. Lines split
+	// across several Write calls get one prefix. Output captured with
+	// FlagCaptureStdout or FlagCaptureStderr goes straight to the file and
+	// is not prefixed; pipe it through a Writer instead.
+	PrefixTimestamps bool   `json:"prefix_timestamps" yaml:"prefix_timestamps"`
+	PrefixFormat     string `json:"prefix_format" yaml:"prefix_format"`
+
+	// JSONEnvelope writes each record as a JSON object on a line of its
+	// own, {"msg":…,"stream":…,"ts":…}, with the record without its final
+	// newline, the EnvelopeStream name (default "stdout") and the time it
+	// was written, so that output can go straight into JSON-based log
+	// pipelines. The keys are sorted and the time is in UTC, RFC 3339 with
+	// nanoseconds, so records compress well and archives diff cleanly.
+	// Like PrefixTimestamps it does not apply to captured output, and the
+	// two cannot be combined.
+	JSONEnvelope   bool   `json:"json_envelope" yaml:"json_envelope"`
+	EnvelopeStream string `json:"envelope_stream" yaml:"envelope_stream"`
+
+	// Filters are applied in order to every record, before the file or
+	// anything mirroring it sees the record, to redact secrets or drop
+	// noise in one place rather than at every call site. Each returns the
+	// record to pass on, which may be p itself but must not reuse its
+	// bytes, or false to drop it; a dropped record counts as written.
+	// They run with the writer locked, on the flushing goroutine with
+	// Async, so they must not write to the writer. Captured output is not
+	// filtered.
+	Filters []func(p []byte) ([]byte, bool) `json:"-" yaml:"-"`
+
+	// StripANSI removes terminal escape sequences, such as colors and
+	// cursor movement, from records before they are written to the file.
+	StripANSI bool `json:"strip_ansi" yaml:"strip_ansi"`
+
+	// MaxLineBytes, if non-zero, cuts lines longer than this many bytes,
+	// ending them with " [truncated]". The limit applies to the line as
+	// written by the application, before any timestamp prefix.
+	MaxLineBytes int `json:"max_line_bytes" yaml:"max_line_bytes"`
+
+	// RotateOnNewline holds off rotation, for up to a second, while the
+	// active file ends partway through a line, so that a line written with
+	// several Write calls does not straddle two files.
+	RotateOnNewline bool `json:"rotate_on_newline" yaml:"rotate_on_newline"`
+
+	// StreamCompress writes files through gzip as they are written, rather
+	// than compressing them afterwards; the pattern should end in .gz.
+	// Data reaches the disk in compressed blocks, so the end of the active
+	// file only becomes readable when it is rotated or closed and is lost
+	// in a crash. Each run of the program starts a new file, as with
+	// Dedupe, rather than appending to the possibly truncated one. It
+	// cannot be combined with RolloverNumbered, Lock or output capture.
+	StreamCompress bool `json:"stream_compress" yaml:"stream_compress"`
+
+	// FlushInterval, if non-zero, writes out what StreamCompress and
+	// DirectIO hold back at least this often, so that the active file can
+	// be followed on a quiet service. Each flush of a compressed file
+	// costs a few bytes of output. With InlineRotation flushes only happen
+	// as records are written.
+	FlushInterval time.Duration `json:"flush_interval" yaml:"flush_interval"`
+
+	// Encrypter, if set, encrypts files as they are written, after
+	// StreamCompress if both are used. It has the same restrictions as
+	// StreamCompress. AESGCM is the reference implementation.
+	Encrypter Encrypter `json:"-" yaml:"-"`
+
+	// HMACKey, if set, makes files tamper-evident: every record, header
+	// and footer is chained with HMAC-SHA256 in a .hmac file next to the
+	// log file, and closing the file seals its chain. VerifyHMAC checks a
+	// file against it. It has the same restrictions as StreamCompress.
+	HMACKey []byte `json:"-" yaml:"-"`
+
+	// Checksum writes a checksum of every rotated file next to it, by
+	// default as path.sha256, before PostRotate and Archiver see the file. Numbered
+	// backups keep theirs as they are shifted and compressed. Verify and
+	// VerifyRange check files against them. ChecksumAlgorithm picks the
+	// hash, which names the sidecar: "sha256", the default, "sha512",
+	// "xxh64" or one added with RegisterChecksum.
+	Checksum          bool   `json:"checksum" yaml:"checksum"`
+	ChecksumAlgorithm string `json:"checksum_algorithm" yaml:"checksum_algorithm"`
+
+	// RecoverOnStart scans the log's files when the writer starts for
+	// what a crash left behind: unfinished checksum and compression
+	// files are removed and sidecars whose log file is gone are deleted.
+	// With Checksum or Manifest, a rotated file without one is taken to
+	// have missed its rotation and is given one and handed to OnRotate,
+	// PostRotate, PostRotateCmd and Archiver with RotateRecovered;
+	// turning either on for an existing log does the same for its old
+	// files. It cannot
+	// be combined with NameTemplate or Namer.
+	RecoverOnStart bool `json:"recover_on_start" yaml:"recover_on_start"`
+
+	// Manifest writes a Manifest of every rotated file next to it, as
+	// path.meta.json, with its line and byte counts, SHA-256, the times
+	// it was opened and closed and the host that wrote it, before
+	// PostRotate and Archiver see the file. The counts and checksum come
+	// from one pass over the finished file, shared with Checksum.
+	// ReadManifest reads it back. It cannot be combined with
+	// StreamCompress or Encrypter, whose files have no lines to count.
+	Manifest bool `json:"manifest" yaml:"manifest"`
+
+	// IndexEvery, if non-zero, keeps a timestamp index next to each file,
+	// as path.idx, recording the offset and time of the first record
+	// written past every IndexEvery bytes. The time comes from Timestamp
+	// when it recognises the record, otherwise from the clock. Open and
+	// IndexOffset use it to skip to the records of interest. Offsets
+	// refer to the file on disk, so it cannot be combined with
+	// StreamCompress or Encrypter, and numbered backups lose their index
+	// when they are compressed.
+	IndexEvery int64 `json:"index_every" yaml:"index_every"`
+
+	// RepeatWindow, if non-zero, collapses runs of identical lines: a line
+	// repeating the previous one within RepeatWindow of its first
+	// appearance is counted rather than written, and "last message
+	// repeated N times" follows when the run ends, the file is rotated or
+	// the writer is closed.
+	RepeatWindow time.Duration `json:"repeat_window" yaml:"repeat_window"`
+
+	// PostRotate is called with the path of each file that has been rolled,
+	// once the writer has moved on to the next file and closed it. With
+	// RolloverNumbered the path is that of the new .1 backup (.1.gz when
+	// CompressFrom is 1).
+	// PostRotateCmd is run the same way, with the path appended as its last
+	// argument, in the manner of logrotate's postrotate script. Both run on
+	// the rotation goroutine; failures are reported with the log package.
+	PostRotate    func(path string) error `json:"-" yaml:"-"`
+	PostRotateCmd []string                `json:"post_rotate_cmd" yaml:"post_rotate_cmd"`
+
+	// Archiver, if set, is handed each rolled file after the PostRotate
+	// hooks have run. The context is cancelled when the writer is closed.
+	// A Pipeline left unfinished by an earlier run is resumed as the
+	// writer starts.
+	Archiver Archiver `json:"-" yaml:"-"`
+
+	// ProfileTrigger captures goroutine and CPU profiles when error lines
+	// arrive faster than a threshold.
+	ProfileTrigger *ProfileTrigger `json:"-" yaml:"-"`
+
+	// Syslog, if set, also forwards every write to syslog.
+	Syslog *SyslogConfig `json:"-" yaml:"-"`
+
+	// StampVersion records FormatVersion and the library version on each
+	// file the writer creates; see Stamp.
+	StampVersion bool `json:"stamp_version" yaml:"stamp_version"`
+
+	// Journald sends rotation events, and optionally every write, to
+	// systemd-journald via its native socket. Linux only.
+	Journald JournaldMode `json:"journald" yaml:"journald"`
+
+	// Bytes that Write fails to get into the log file, including those sent
+	// to Fallback while degraded, are always counted; see Lost. StrictLoss
+	// enforces a budget for them: once more than LossBudget bytes have been
+	// lost, OnLossExceeded is called with the total, or the writer panics if
+	// it is nil. This happens once per writer.
+	StrictLoss     bool             `json:"strict_loss" yaml:"strict_loss"`
+	LossBudget     int64            `json:"loss_budget" yaml:"loss_budget"`
+	OnLossExceeded func(lost int64) `json:"-" yaml:"-"`
+
+	// FailureReports, if non-zero, is how many failures of the writer
+	// itself (failed writes, files that cannot be opened) are reported to
+	// the system log: the Windows Event Log on Windows, syslog elsewhere.
+	// Operators then learn that file logging is down even though the log
+	// file cannot tell them. Reporting stops once the limit is reached.
+	FailureReports int `json:"failure_reports" yaml:"failure_reports"`
+
+	// Also receives a copy of every write, for example os.Stdout in a
+	// container. The rolling file stays primary: errors from these writers
+	// are ignored and never change what Write returns.
+	Also []io.Writer `json:"-" yaml:"-"`
+
+	// OnFull selects what happens when a write fails because the disk is
+	// full. The default, FullFail, treats it as any other failure.
+	OnFull FullPolicy `json:"on_full" yaml:"on_full"`
+
+	// MinFreeBytes, if non-zero, is checked at each rotation: while the
+	// filesystem holding the log has less space available, the oldest log
+	// files are deleted before the next file is created. Supported on
+	// Linux, macOS and Windows.
+	MinFreeBytes uint64 `json:"min_free_bytes" yaml:"min_free_bytes"`
+
+	// Tracer, if set, is told about rotations and archive operations.
+	Tracer Tracer `json:"-" yaml:"-"`
+
+	// Async makes Write hand records to a background goroutine instead of
+	// writing them itself, so it never waits on the disk. The queue holds
+	// up to AsyncQueue records (default 4096) and RealTimeBuffer bytes
+	// (default 4MB); AsyncOverflow decides what happens to records that do
+	// not fit, and dropped ones are counted in Lost. Queued records are on
+	// disk once the goroutine catches up, or when Close returns.
+	Async         bool           `json:"async" yaml:"async"`
+	AsyncQueue    int            `json:"async_queue" yaml:"async_queue"`
+	AsyncOverflow OverflowPolicy `json:"async_overflow" yaml:"async_overflow"`
+
+	// AsyncOverflowFile names the file that OverflowSpill appends records
+	// to while the queue is full. New creates it afresh, and Close removes
+	// it once everything has been written out. Unlike AsyncSpill it does
+	// not survive a crash, so it cannot be combined with AsyncSpill.
+	AsyncOverflowFile string `json:"async_overflow_file" yaml:"async_overflow_file"`
+
+	// AsyncPriority, if set, sends the records it reports true for, such
+	// as errors and audit records, through a lane of their own that is
+	// written out ahead of the rest, so they may reach the file before
+	// records written earlier. The lane has AsyncQueue slots of its own,
+	// and shares RealTimeBuffer with the other records, taking their room,
+	// oldest first, when it runs out, so that these are the last records
+	// to be dropped. To favour errors, for example:
+	//
+	//	func(p []byte) bool { return rollinglog.ClassifyLevel(p) >= slog.LevelError }
+	//
+	// It cannot be combined with AsyncSpill.
+	AsyncPriority func(p []byte) bool `json:"-" yaml:"-"`
+
+	// DropSummary, if non-zero, writes a line to the file accounting for
+	// the records the Async queue has dropped, with the times of the first
+	// and last and how many were dropped for each reason:
+	//
+	//	rollinglog: dropped 1532 records (98304 bytes) between 2024-05-01T12:00:00.000Z and 2024-05-01T12:00:04.250Z, reasons: queue full 1500, displaced 32
+	//
+	// It is written once the first drop it counts is DropSummary old, by
+	// the next record to reach the file or, should the queue have gone
+	// quiet, by the rotation goroutine, and when the writer is closed.
+	DropSummary time.Duration `json:"drop_summary" yaml:"drop_summary"`
+
+	// AsyncSpill, if set, names a file that mirrors the Async queue
+	// through a memory mapping, so that records accepted by Write survive
+	// the process crashing before they reach the log. New appends any it
+	// finds there to the log before writing anything else, each to the
+	// file of the period it was written in with RolloverDated; a crash
+	// while writing out may repeat a record, but never loses one. Records
+	// that Shutdown gives up on are kept for the next process too. The
+	// file takes twice RealTimeBuffer and must not be shared between
+	// writers. It is supported on Linux, macOS and the BSDs.
+	AsyncSpill string `json:"async_spill" yaml:"async_spill"`
+
+	// MaxWriteLatency, if non-zero, implies Async for callers that must
+	// bound the time spent logging. Write calls slower than it are counted
+	// in Stats.
+	MaxWriteLatency time.Duration `json:"max_write_latency" yaml:"max_write_latency"`
+	RealTimeBuffer  int           `json:"real_time_buffer" yaml:"real_time_buffer"`
+
+	// OnSlowWrite, if set, is called with the path, size and duration of
+	// every write to a log file that takes longer than SlowWriteThreshold,
+	// to tell a stalling disk or NFS server from a stalling application.
+	// It runs with the writer locked, so it must not write to the writer.
+	// Writer.Latency has the histogram of all writes.
+	SlowWriteThreshold time.Duration                                `json:"slow_write_threshold" yaml:"slow_write_threshold"`
+	OnSlowWrite        func(path string, n int, took time.Duration) `json:"-" yaml:"-"`
+
+	// WriteTimeout, if non-zero, bounds how long a write may wait on a log
+	// file, so that a hung NFS mount does not freeze every goroutine that
+	// logs. A write that takes longer fails with ErrWriteTimeout, as do
+	// later writes to the file until it has returned; they are treated
+	// like any other failed write, going to Fallback after DegradeAfter
+	// failures and being held with OutageBuffer. Data is copied for each
+	// write. It cannot be combined with StreamCompress, Encrypter or
+	// DirectIO.
+	WriteTimeout time.Duration `json:"write_timeout" yaml:"write_timeout"`
+
+	// OnPressure, if set, is called when Writer.Pressure moves from one
+	// level to another, with the pressure and the number of
+	// PressureLevels it is at or above, so that an application can shed
+	// verbose logging while the writer struggles and take it back up
+	// afterwards. PressureLevels default to 0.5 and 0.9. Pressure is
+	// sampled every second by whatever drives rotation.
+	OnPressure     func(pressure float64, level int) `json:"-" yaml:"-"`
+	PressureLevels []float64                         `json:"pressure_levels" yaml:"pressure_levels"`
+
+	// Session is substituted for {session} in FilepathPattern. Sessions
+	// sets it for each writer it opens.
+	Session string `json:"session" yaml:"session"`
+
+	// Expvar, if set, publishes the writer's Stats under this name with
+	// the expvar package, and so on /debug/vars. Close unregisters the
+	// writer, leaving the variable null until a later writer reuses the
+	// name.
+	Expvar string `json:"expvar" yaml:"expvar"`
 }
 
-func NewMust(config Config) io.WriteCloser {
+func NewMust(config Config) *Writer {
 	wc, err := New(config)
 	if err != nil {
 		log.Panic("Failed to create log: ", err)
@@ -58,125 +719,1021 @@ func NewMust(config Config) io.WriteCloser {
 	return wc
 }
 
-// Create a new io.WriteCloser that targets a rolling log file. Uses path as a
+// Create a new Writer that targets a rolling log file. Uses path as a
 // template, adding the current date.
 //		data/server.log becomes data/2006/01/2006-01-02/server.log
-func New(config Config) (io.WriteCloser, error) {
-	if config.FilepathPattern == "" {
-		config.FilepathPattern = "logs/{2006/01/2006-01-02}/log.log"
+//
+// Besides date layouts, the pattern may contain {hostname}, {pid},
+// {session} (see Config.Session) and {env:NAME} placeholders:
+//		logs/{hostname}/{2006-01-02}/app-{pid}.log
+func New(config Config) (*Writer, error) {
+	return newWriter(context.Background(), config, nil)
+}
+
+// NewContext is like New, but the Writer is closed when ctx is done. The
+// context passed to Config.Tracer and Config.Archiver derives from ctx.
+// Calling Close first is still allowed and releases the context.
+func NewContext(ctx context.Context, config Config) (*Writer, error) {
+	rf, err := newWriter(ctx, config, nil)
+	if err != nil {
+		return nil, err
+	}
+	rf.mu.Lock()
+	rf.stopContext = context.AfterFunc(ctx, func() { rf.close() })
+	rf.mu.Unlock()
+	return rf, nil
+}
+
+// Create the writer. With Config.Scheduler set, dropped, if not nil, is
+// called once the Scheduler lets go of the writer.
+func newWriter(ctx context.Context, config Config, dropped func()) (*Writer, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	if l.static() && !config.customNames() && config.Rollover != RolloverNumbered {
+		log.Printf("%v: %q", ErrNoTimeComponent, config.FilepathPattern)
+	}
+	if config.ProbeInterval == 0 {
+		config.ProbeInterval = time.Minute
+	}
+	if config.Fallback == nil {
+		config.Fallback = os.Stderr
+	} else if config.DegradeAfter == 0 {
+		config.DegradeAfter = 3
 	}
-	if config.Mode == 0 {
-		config.Mode = 0600
+
+	clock := config.Clock
+	if config.Scheduler != nil {
+		clock = config.Scheduler.clock
+	} else if clock == nil {
+		clock = systemClock{}
+	}
+
+	rf := &Writer{
+		config:   config,
+		layout:   l,
+		clock:    clock,
+		fs:       config.FS,
+		chClosed: make(chan struct{}),
+		chProbe:  make(chan struct{}, 1),
+		chRotate: make(chan RotateReason, 1),
+		compress: newCompressor(&config),
+		errs:     make(chan error, 64),
 	}
-	if config.DirMode == 0 {
-		config.DirMode = 02700
+	if rf.fs == nil {
+		rf.fs = OSFS
+	}
+	rf.compress.report = func(err error) {
+		log.Print(err)
+		rf.sendError(err)
+	}
+	if config.Scheduler == nil && !config.InlineRotation {
+		rf.wake = make(chan struct{}, 1) // for the writer's own goroutine
+	}
+	if rf.uid, rf.gid, err = resolveOwner(config.Owner, config.Group); err != nil {
+		return nil, err
+	}
+	rf.ctx, rf.cancel = context.WithCancel(ctx)
+	l.config = &rf.config
+	if config.RecentBytes > 0 {
+		rf.recent = &recentRing{max: config.RecentBytes}
+	}
+	if config.ProfileTrigger != nil {
+		if rf.prof, err = newProfiler(*config.ProfileTrigger, l, rf.chClosed); err != nil {
+			return nil, err
+		}
 	}
 
-	chFile := make(chan *os.File)
-	chErr := make(chan error)
-	chClosed := make(chan struct{})
+	if rf.journal, err = newJournal(config.Journald); err != nil {
+		return nil, err
+	}
 
-	go func() {
-		defer close(chFile)
-		for {
-			select {
-			case <-chClosed:
-				return
-			default:
+	if config.Handoff != "" {
+		if rf.handoff, err = rf.takeHandoff(config.Handoff); err != nil {
+			rf.journal.close()
+			return nil, err
+		}
+	}
+	now := clock.Now()
+	if rf.f, err = rf.openFile(l.stamp(now), false); err != nil {
+		if config.DegradeAfter == 0 && config.OutageBuffer == 0 {
+			rf.journal.close()
+			return nil, err
+		}
+		rf.lastErr = err
+		if err != errHandoffPending {
+			rf.failures = config.DegradeAfter
+		}
+	} else {
+		rf.claimed, rf.f.claim = rf.f.claim, nil
+	}
+	if config.Syslog != nil {
+		if rf.syslog, err = dialSyslog(config.Syslog); err != nil {
+			if rf.f != nil {
+				rf.f.close()
 			}
+			rf.handoff.release()
+			rf.journal.close()
+			return nil, err
+		}
+	}
+
+	if config.Expvar != "" {
+		if err := publishExpvar(config.Expvar, rf); err != nil {
+			rf.Close()
+			return nil, err
+		}
+	}
+	if config.Async || config.MaxWriteLatency > 0 {
+		if rf.async, err = newAsyncQueue(rf); err != nil {
+			rf.Close()
+			return nil, err
+		}
+	}
+
+	rf.rot = rotation{current: rf.f, openedAt: now}
+	if config.InlineRotation {
+		rf.tick()
+	}
+	switch {
+	case config.Scheduler != nil:
+		rf.slot = &schedEntry{sched: config.Scheduler, rf: rf, dropped: dropped, index: -1}
+		config.Scheduler.add(rf.slot)
+	case !config.InlineRotation:
+		go rf.run()
+	}
+	if h := rf.handoff; h != nil && !h.owned.Load() {
+		go rf.awaitHandoff(h) // pokes the writer, so not before now
+	}
+	return rf, nil
+}
+
+// FileStats describes the lifetime of a single log file.
+type FileStats struct {
+	Opened time.Time
+	Closed time.Time
+	Writes int64 // Write calls that reached the file
+	Bytes  int64 // bytes written by this process
+}
+
+// An open log file.
+type logFile struct {
+	File
+	base    string // path before any Dedupe sequence suffix
+	opened  time.Time
+	writes  int64
+	bytes   int64
+	onClose func(path string, stats FileStats)
+	clock   Clock
+
+	// With Config.StreamCompress or Encrypter, data is written to w, the
+	// top of a stack of layers over the file, outermost first.
+	w      io.Writer
+	layers []io.WriteCloser
+	chain  *hmacChain // with Config.HMACKey
+	index  *fileIndex // with Config.IndexEvery
+	timed  *timedFile // with Config.WriteTimeout
+	size   int64      // bytes in the file, for Config.MaxSize
+	due    bool       // a rotation has been requested by checkRotation
+	stamp  time.Time  // the time the file is named for
+	start  int64      // size once the header was written
 
-			now := time.Now()
-			p := pattern.ReplaceAllStringFunc(config.FilepathPattern, func(s string) string {
-				return now.Format(s[1 : len(s)-1])
-			})
-			if err := os.MkdirAll(path.Dir(p), config.DirMode); err != nil && !os.IsExist(err) {
-				select {
-				case chErr <- err:
-				case <-chClosed:
-				}
-				return
+	prealloc bool      // space past the end was reserved by Config.PreallocateBytes
+	sum      hash.Hash // of the whole file, with Config.Continuity
+	flushed  int64     // writes as of the last Config.FlushInterval flush
+	claim    *os.File  // the lock of Config.Disambiguate, until installed
+}
+
+func (lf *logFile) Write(p []byte) (int, error) {
+	n, err := lf.write(p)
+	if lf.sum != nil {
+		lf.sum.Write(p[:n])
+	}
+	return n, err
+}
+
+func (lf *logFile) write(p []byte) (int, error) {
+	if lf.w != nil {
+		return lf.w.Write(p)
+	}
+	var n int
+	var err error
+	if lf.timed != nil {
+		n, err = lf.timed.Write(p)
+	} else {
+		n, err = lf.File.Write(p)
+	}
+	if lf.chain != nil && n > 0 {
+		if cerr := lf.chain.add(p[:n]); err == nil {
+			err = cerr
+		}
+	}
+	if lf.index != nil {
+		lf.index.wrote(p, n)
+	}
+	return n, err
+}
+
+// The file as opened by OSFS. Features that need one are refused with any
+// other Config.FS.
+func (lf *logFile) osFile() *os.File {
+	f, _ := lf.File.(*os.File)
+	return f
+}
+
+// Write out what the layers over the file hold back, outermost first.
+func (lf *logFile) flush() error {
+	for _, layer := range lf.layers {
+		if f, ok := layer.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
 			}
+		}
+	}
+	return nil
+}
 
-			f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, config.Mode)
+func (lf *logFile) close() error {
+	var err error
+	for _, layer := range lf.layers {
+		if cerr := layer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if lf.prealloc {
+		// give back what was reserved and not used
+		if fi, serr := lf.Stat(); serr == nil {
+			lf.osFile().Truncate(fi.Size())
+		}
+	}
+	if lf.timed != nil {
+		lf.timed.stop()
+	}
+	if lf.claim != nil {
+		lf.claim.Close()
+	}
+	if cerr := lf.File.Close(); err == nil {
+		err = cerr
+	}
+	if lf.chain != nil {
+		if cerr := lf.chain.close(); err == nil {
+			err = cerr
+		}
+	}
+	if lf.onClose != nil {
+		lf.onClose(lf.Name(), FileStats{
+			Opened: lf.opened,
+			Closed: lf.clock.Now(),
+			Writes: lf.writes,
+			Bytes:  lf.bytes,
+		})
+	}
+	return err
+}
+
+// ErrClosed is returned by writes to a Writer after Close.
+var ErrClosed = errors.New("rollinglog: writer is closed")
+
+// Writer is an io.WriteCloser that targets a rolling log file. It is safe
+// for concurrent use.
+type Writer struct {
+	config Config
+	layout *layout
+
+	mu       sync.Mutex
+	f        *logFile
+	lastErr  error
+	closed   bool
+	closeErr error // returned by Close once closed
+	uid, gid int   // Config.Owner and Group, -1 if unset
+	started  bool  // a file has been opened; Config.OpenMode no longer applies
+	failures int
+	untried  bool
+	past     *logFile // last file written for an earlier period
+	recent   *recentRing
+	prof     *profiler
+	async    *asyncQueue
+	syslog   io.WriteCloser
+	journal  *journal
+	latency  latencies
+	stats    Stats // counters only; Path and Size are filled in by Stats
+	overLoss bool  // OnLossExceeded has fired
+	reported int   // failures reported to the system log
+	eventLog func(msg string) error
+	midLine  bool // the file does not end in a newline from Write
+	ansi     ansiState
+	repeats  repeatState
+	quota    quotaState   // with QuotaStopWriting
+	outage   outageBuffer // with Config.OutageBuffer
+	compress *compressor  // for numbered backups
+	lineLen  lineLimit
+	bufs     filterBufs  // reused by filter
+	finished closedFiles // for Config.Manifest
+	pressure pressureState
+	atClose  []func() // run by Close before the writer shuts down
+	handoff  *handoff // with Config.Handoff
+
+	// for Status
+	writeErr   error
+	writeErrAt time.Time
+	rotatedAt  time.Time
+	rotateErr  error
+	group      *rotationGroup // see Manager.Group
+	joining    groupRotation  // waiting to be taken up by step
+
+	maintainedAt time.Time     // when the last maintenance began, see maintain
+	maintainTook time.Duration // and how long it ran
+
+	chClosed chan struct{}
+	chProbe  chan struct{}
+	chRotate chan RotateReason // requests from Rotate and Config.MaxSize
+	wake     chan struct{}     // poked along with the above
+	slot     *schedEntry       // with Config.Scheduler, the writer's place in it
+	clock    Clock             // Config.Clock, or that of Config.Scheduler
+	fs       FS                // Config.FS, or OSFS
+	ctx      context.Context   // cancelled by Close
+	cancel   context.CancelFunc
+	errs     chan error // see Errors
+	cutParts []string   // files cut by Config.HardMaxBytes, not yet rotated
+	claimed  *os.File   // holds the lock of Config.Disambiguate
+
+	stopContext  func() bool  // with NewContext, stops ctx closing the writer
+	rot          rotation     // state of the goroutine driving rotation
+	stepMu       sync.Mutex   // serializes step with Update and inline rotations
+	due          atomic.Int64 // unix nanoseconds at which step is next due
+	nextAt       atomic.Int64 // unix nanoseconds of the next rotation, see NextRotation
+	policyAt     atomic.Int64 // unix nanoseconds of the RotationPolicy deadline, 0 for none
+	archiveQueue atomic.Int64 // archives awaiting a retry by step
+}
+
+// Write writes p to the log. Rotation happens between Write calls, so the
+// data of one call always lands in a single file unless Config.HardMaxBytes
+// has to cut it; with Config.RotateOnNewline lines built from several calls
+// are kept whole too.
+func (rf *Writer) Write(p []byte) (int, error) {
+	if rf.async != nil {
+		return rf.async.write(p)
+	}
+	if rf.config.InlineRotation {
+		rf.tick()
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.write(p)
+}
+
+// WriteString writes s to the log like Write, without copying it to a byte
+// slice first. The writer, and any writers it mirrors to, only read the
+// bytes, as io.Writer requires.
+func (rf *Writer) WriteString(s string) (int, error) {
+	return rf.Write(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+// Write p to the log. Called with rf.mu held.
+func (rf *Writer) write(p []byte) (int, error) {
+	return rf.writeAt(p, time.Time{})
+}
+
+// Write p as written at t, into the file of t's period as with
+// Config.Timestamp, or with the zero t as Timestamp says. Called with rf.mu
+// held.
+func (rf *Writer) writeAt(p []byte, t time.Time) (int, error) {
+	rf.stats.Writes++
+	if len(rf.config.Filters) > 0 {
+		q, ok := rf.applyFilters(p)
+		if !ok {
+			return len(p), nil
+		}
+		rf.mirror(q)
+		n, err := rf.writeRecord(q, t)
+		return consumed(p, q, n), err
+	}
+	rf.mirror(p)
+	return rf.writeRecord(p, t)
+}
+
+// WriteBatch writes each of bufs to the log as if by separate Write calls,
+// but joins them into a single write to the file. It returns the total
+// number of bytes written.
+func (rf *Writer) WriteBatch(bufs [][]byte) (int, error) {
+	if rf.async != nil {
+		var total int
+		for _, p := range bufs {
+			n, err := rf.async.write(p)
+			total += n
 			if err != nil {
-				select {
-				case chErr <- err:
-				case <-chClosed:
-				}
-				return
+				return total, err
 			}
+		}
+		return total, nil
+	}
+	if rf.config.InlineRotation {
+		rf.tick()
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
 
-			if config.Flags&FlagCaptureStdout != 0 {
-				fd, stdout := int(f.Fd()), int(os.Stdout.Fd())
-				syscall.Close(stdout)
-				syscall.Dup2(fd, stdout)
+	rf.stats.Writes += int64(len(bufs))
+	if len(rf.config.Filters) > 0 {
+		size := 0
+		kept := make([][]byte, 0, len(bufs))
+		for _, p := range bufs {
+			size += len(p)
+			if q, ok := rf.applyFilters(p); ok {
+				kept = append(kept, q)
 			}
-			if config.Flags&FlagCaptureStderr != 0 {
-				fd, stderr := int(f.Fd()), int(os.Stderr.Fd())
-				syscall.Close(stderr)
-				syscall.Dup2(fd, stderr)
+		}
+		n, err := rf.writeBatch(kept)
+		if err == nil || n > size {
+			n = size
+		}
+		return n, err
+	}
+	return rf.writeBatch(bufs)
+}
+
+// Write bufs to the log, joined unless their records may belong to
+// different files. Called with rf.mu held.
+func (rf *Writer) writeBatch(bufs [][]byte) (int, error) {
+	size := 0
+	for _, p := range bufs {
+		rf.mirror(p)
+		size += len(p)
+	}
+	if rf.config.Timestamp != nil {
+		// each record may belong to a different file
+		var total int
+		for _, p := range bufs {
+			n, err := rf.writeRecord(p, time.Time{})
+			total += n
+			if err != nil {
+				return total, err
 			}
+		}
+		return total, nil
+	}
+	joined := make([]byte, 0, size)
+	for _, p := range bufs {
+		joined = append(joined, p...)
+	}
+	return rf.writeRecord(joined, time.Time{})
+}
+
+// Pass p to everything that sees records besides the file. Called with
+// rf.mu held.
+func (rf *Writer) mirror(p []byte) {
+	if rf.recent != nil {
+		rf.recent.add(p)
+	}
+	if rf.syslog != nil {
+		rf.syslog.Write(p)
+	}
+	for _, w := range rf.config.Also {
+		w.Write(p)
+	}
+	if rf.journal != nil && rf.f != nil {
+		rf.journal.record(rf.f.Name(), p)
+	}
+	if rf.prof != nil && rf.f != nil {
+		rf.prof.observe(p, rf.f.Name())
+	}
+}
+
+// Write record p, already mirrored, to the file, or to that of the period
+// of t if that is not zero. Called with rf.mu held.
+func (rf *Writer) writeRecord(p []byte, t time.Time) (int, error) {
+	q := rf.filter(p)
+	if len(q) == 0 {
+		return len(p), nil
+	}
+	if err := rf.checkQuota(len(q)); err != nil {
+		rf.stats.Errors++
+		rf.lose(len(q))
+		return 0, err
+	}
 
-			// wait for tomorrow
-			tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
-
-			timeout := time.After(tomorrow.Sub(now))
-			select {
-			case chFile <- f:
-				// wait for timeout
-				<-timeout
-			case <-chClosed:
-				return
-			case <-timeout:
-				f.Close()
+	if t.IsZero() && rf.config.Timestamp != nil {
+		if ts, ok := rf.config.Timestamp(p); ok {
+			t = ts
+		}
+	}
+	if !t.IsZero() {
+		if n, routed, err := rf.writeFor(t, q); routed {
+			if err != nil {
+				rf.lose(len(q) - n)
 			}
+			return consumed(p, q, n), err
 		}
-	}()
+	}
 
-	var f *os.File
+	var n int
+	err := rf.lastErr
+	if err == nil && (!rf.degraded() || rf.untried) {
+		rf.untried = false
+		if err = rf.replayOutage(); err == nil {
+			n, err = rf.writeLimited(q)
+		}
+		if err == nil {
+			rf.failures = 0
+			rf.clearWriteError()
+			rf.midLine = q[len(q)-1] != '\n'
+			rf.checkRotation()
+			return len(p), nil
+		}
+	}
+
+	if err == errHandoffPending && rf.hold(q[n:]) {
+		return len(p), nil // not a failure
+	}
+	rf.failures++
+	rf.stats.Errors++
+	rf.noteWriteError(err)
+	if err != ErrClosed && rf.hold(q[n:]) {
+		// keep it until a file can be written again
+		rf.reportFailure(err)
+		rf.askForFile()
+		return len(p), nil
+	}
+	rf.lose(len(q) - n)
+	if err != ErrClosed {
+		rf.reportFailure(err)
+	}
+	if rf.config.DegradeAfter == 0 || rf.failures < rf.config.DegradeAfter {
+		return consumed(p, q, n), err
+	}
+
+	// the file is persistently failing: ask for a fresh one and fall
+	// back in the meantime
+	if rf.failures == rf.config.DegradeAfter && rf.f != nil {
+		rf.journal.event(journalWarning, rf.f.Name(), "writes failing, falling back: %v", err)
+	}
+	rf.askForFile()
+	return rf.config.Fallback.Write(p)
+}
+
+// Ask the rotation goroutine to replace a file that is failing.
+func (rf *Writer) askForFile() {
 	select {
-	case f = <-chFile:
-	case err := <-chErr:
-		return nil, err
+	case rf.chProbe <- struct{}{}:
+		rf.poke()
+	default:
+	}
+}
+
+// Apply Config.OnFull to a write of p that failed with a disk full error
+// after n bytes.
+func (rf *Writer) writeFull(p []byte, n int, err error) (int, error) {
+	for isDiskFull(err) {
+		switch rf.config.OnFull {
+		case FullDrop:
+			rf.lose(len(p) - n)
+			return len(p), nil
+		case FullBlock:
+			if !rf.pause(time.Second) {
+				return n, ErrClosed
+			}
+		case FullPurge:
+			purged, perr := purgeOldest(rf.layout, rf.f.Name())
+			if perr != nil {
+				rf.logf("freeing space: %w", perr)
+			}
+			if !purged {
+				return n, err
+			}
+		}
+		var m int
+		m, err = rf.writeFile(rf.f, p[n:])
+		n += m
 	}
+	return n, err
+}
 
-	return &rollingFile{
-		f:        f,
-		chFile:   chFile,
-		chErr:    chErr,
-		chClosed: chClosed,
-	}, nil
+// Release the lock for d and report whether the writer is still open.
+func (rf *Writer) pause(d time.Duration) bool {
+	rf.mu.Unlock()
+	ok := rf.sleep(d)
+	rf.mu.Lock()
+	return ok && !rf.closed
 }
 
-type rollingFile struct {
-	f        *os.File
-	lastErr  error
-	chFile   <-chan *os.File
-	chErr    <-chan error
-	chClosed chan<- struct{}
+// Report a failure in the background, one that no Write returns, to the
+// standard logger and on the Errors channel.
+func (rf *Writer) logf(format string, args ...interface{}) {
+	err := fmt.Errorf("rollinglog: "+format, args...)
+	log.Print(err)
+	rf.sendError(err)
 }
 
-func (rf *rollingFile) Write(p []byte) (int, error) {
+// Queue err for Errors, unless it is full.
+func (rf *Writer) sendError(err error) {
 	select {
-	case rf.lastErr = <-rf.chErr:
-	case f := <-rf.chFile:
-		rf.f.Close()
-		rf.f = f
+	case rf.errs <- err:
 	default:
 	}
+}
+
+// Errors returns a channel on which the writer reports failures that no
+// Write returns, such as a directory or file that could not be created, a
+// rotated file that could not be compressed or archived, or a hook that
+// failed, as well as the settings each Update changed. They are logged as
+// well. The channel buffers 64 errors and drops further ones while it is
+// full, so it need not be read; it is never closed.
+func (rf *Writer) Errors() <-chan error {
+	return rf.errs
+}
+
+// Report a failure of the writer to the system log, if configured and the
+// limit has not been reached. Called with rf.mu held.
+func (rf *Writer) reportFailure(err error) {
+	if rf.reported >= rf.config.FailureReports {
+		return
+	}
+	rf.reported++
+	if rf.eventLog == nil {
+		var oerr error
+		if rf.eventLog, oerr = openEventLog(filepath.Base(os.Args[0])); oerr != nil {
+			rf.logf("opening system log: %w", oerr)
+			rf.reported = rf.config.FailureReports
+			return
+		}
+	}
+	msg := fmt.Sprintf("rollinglog: logging to %s failed: %v", rf.config.FilepathPattern, err)
+	if rf.reported == rf.config.FailureReports {
+		msg += " (further failures will not be reported)"
+	}
+	rf.eventLog(msg)
+}
+
+// Account for n bytes that did not reach the log file.
+func (rf *Writer) lose(n int) {
+	rf.stats.Lost += int64(n)
+	if !rf.config.StrictLoss || rf.overLoss || rf.stats.Lost <= rf.config.LossBudget {
+		return
+	}
+	rf.overLoss = true
+	if rf.config.OnLossExceeded != nil {
+		rf.config.OnLossExceeded(rf.stats.Lost)
+		return
+	}
+	panic(fmt.Sprintf("rollinglog: lost %d bytes, over the budget of %d", rf.stats.Lost, rf.config.LossBudget))
+}
+
+// Write a record stamped with t to the file of its period when that is not
+// the current file. Reports whether the record was handled.
+func (rf *Writer) writeFor(t time.Time, p []byte) (int, bool, error) {
+	name, err := rf.layout.pathFor(t)
+	if err != nil {
+		return 0, true, err
+	}
+	if rf.f != nil && name == rf.f.base {
+		return 0, false, nil
+	}
+
+	if rf.past == nil || rf.past.Name() != name {
+		if rf.past != nil {
+			rf.past.close()
+			rf.past = nil
+		}
+		if err := rf.mkdirAll(path.Dir(name)); err != nil {
+			return 0, true, err
+		}
+		f, err := rf.openLog(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+		if err != nil {
+			return 0, true, err
+		}
+		if osf, _ := f.(*os.File); osf != nil {
+			if rf.config.StampVersion {
+				stampFile(osf)
+			}
+			if rf.config.AppendOnly {
+				rf.setAppendOnly(osf)
+			}
+			if rf.config.OnFileOpen != nil {
+				rf.config.OnFileOpen(osf, name)
+			}
+		}
+		if rf.past, err = rf.newLogFile(f, name, name); err != nil {
+			return 0, true, err
+		}
+		rf.writeHeader(rf.past)
+	}
+	n, err := rf.writeFile(rf.past, p)
+	return n, true, err
+}
 
-	if rf.lastErr != nil {
-		return 0, rf.lastErr
+func (rf *Writer) writeFile(f *logFile, p []byte) (int, error) {
+	if rf.config.Lock {
+		if err := lockFile(f.osFile(), true); err != nil {
+			return 0, err
+		}
+		defer unlockFile(f.osFile())
 	}
+	start := time.Now()
+	rf.pressure.writing.Store(start.UnixNano())
+	n, err := f.Write(p)
+	rf.pressure.writing.Store(0)
+	rf.observeWrite(f, n, time.Since(start))
+	f.writes++
+	f.bytes += int64(n)
+	f.size += int64(n)
+	rf.stats.Bytes += int64(n)
+	return n, err
+}
+
+func (rf *Writer) degraded() bool {
+	return rf.config.DegradeAfter != 0 && rf.failures >= rf.config.DegradeAfter
+}
 
-	return rf.f.Write(p)
+// Close writes out pending records and closes the log, returning the
+// first error from closing its files. Later calls return the same error,
+// and writes fail with ErrClosed.
+func (rf *Writer) Close() error {
+	_, err := rf.close()
+	return err
+}
+
+// Shutdown closes the writer like Close, but with Config.Async it gives
+// up writing out the queued records once ctx is done. It then returns the
+// number of bytes dropped, which are counted in Stats.Lost, with the
+// context's error.
+func (rf *Writer) Shutdown(ctx context.Context) (int64, error) {
+	var dropped int64
+	if rf.async != nil {
+		dropped = rf.async.stopContext(ctx)
+	}
+	if err := rf.Close(); err != nil {
+		return dropped, err
+	}
+	if dropped > 0 {
+		return dropped, ctx.Err()
+	}
+	return 0, nil
 }
 
-func (rf *rollingFile) Close() error {
+// Close the writer, returning the name of the last file if it was kept
+// rather than discarded by Config.SkipEmpty. The name is empty if the
+// writer had been closed already.
+func (rf *Writer) close() (string, error) {
+	rf.mu.Lock()
+	stop := rf.stopContext
+	rf.mu.Unlock()
+	if stop != nil {
+		stop() // a no-op when called from the context's own AfterFunc
+	}
+	for _, fn := range rf.atClose {
+		fn()
+	}
+	if rf.async != nil {
+		rf.async.stop()
+	}
+	rf.compress.abandon()
+	rf.compress.wait()
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.closed {
+		return "", rf.closeErr
+	}
+	var err error
+	var kept string
+	if rf.f != nil && rf.lastErr == nil {
+		rf.replayOutage()
+	}
+	rf.dropOutage()
+	if rf.claimed != nil {
+		rf.claimed.Close()
+		rf.claimed = nil
+	}
 	if rf.f != nil {
-		rf.f.Close()
+		rf.flushRepeats(rf.f)
+		rf.writeFooter(rf.f, "")
+		err = rf.f.close()
+		if !rf.discardEmpty(rf.f) {
+			kept = rf.f.Name()
+		}
+	}
+	if rf.past != nil {
+		if perr := rf.past.close(); err == nil {
+			err = perr
+		}
 	}
-	rf.lastErr = io.EOF
+	if rf.syslog != nil {
+		rf.syslog.Close()
+	}
+	rf.journal.close()
+	rf.handoff.release()
+	rf.closed = true
+	rf.closeErr = err
+	rf.lastErr = ErrClosed
+	rf.clearCrashOutput()
 	close(rf.chClosed)
-	return nil
+	rf.poke()
+	rf.cancel()
+	return kept, err
+}
+
+// OpenCurrentForRead opens the active file for reading, positioned at
+// offset. A negative offset is relative to the end of the file, so -4096
+// reads the last 4KB. Everything Write has returned for is visible to the
+// reader, except with Config.Async where records reach the file shortly
+// after. The file remains valid across rotations but no longer grows once
+// the writer has moved on.
+func (rf *Writer) OpenCurrentForRead(offset int64) (io.ReadCloser, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		if rf.lastErr != nil {
+			return nil, rf.lastErr
+		}
+		return nil, os.ErrNotExist
+	}
+
+	file, err := rf.fs.OpenFile(rf.f.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := file.(io.ReadSeekCloser)
+	if !ok {
+		file.Close()
+		return nil, errors.New("rollinglog: files of this Config.FS cannot be read back")
+	}
+
+	whence := io.SeekStart
+	if offset < 0 {
+		fi, err := file.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if -offset > fi.Size() {
+			offset = -fi.Size()
+		}
+		whence = io.SeekEnd
+	}
+	if _, err := f.Seek(offset, whence); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stats holds cumulative counters for a Writer, along with the active file.
+type Stats struct {
+	Writes    int64 // Write calls
+	Bytes     int64 // bytes written to log files
+	Rotations int64
+	Errors    int64 // failed writes and failed attempts to open a file
+	Lost      int64 // see Writer.Lost
+	Filtered  int64 // records dropped by Config.Filters
+
+	// With Config.MaxWriteLatency: Write calls that took longer, and the
+	// slowest seen.
+	SlowWrites int64
+	MaxLatency time.Duration
+
+	// With Config.Async: records and bytes waiting in the queue, and those
+	// dropped because it was full.
+	Queued       int64
+	QueuedBytes  int64
+	Dropped      int64
+	DroppedBytes int64
+
+	// With OverflowSpill: records and bytes waiting in the overflow file.
+	Overflowed      int64
+	OverflowedBytes int64
+
+	// With Config.OutageBuffer: bytes held in memory while no file can be
+	// written, and records that did not fit.
+	OutageHeld    int64
+	OutageDropped int64
+
+	Path string // active file, empty if none could be opened
+	Size int64  // current size of the active file
+}
+
+// Stats returns the writer's counters since it was created.
+func (rf *Writer) Stats() Stats {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	s := rf.stats
+	s.OutageHeld = rf.outage.bytes
+	if q := rf.async; q != nil {
+		s.SlowWrites = q.slow.Load()
+		s.MaxLatency = time.Duration(q.maxLatency.Load())
+		s.Queued = int64(len(q.ch) + len(q.urgent))
+		s.QueuedBytes = q.queued.Load()
+		s.Dropped = q.droppedRecords.Load()
+		s.DroppedBytes = q.droppedBytes.Load()
+		if q.overflow != nil {
+			s.Overflowed = q.overflow.records.Load()
+			s.OverflowedBytes = q.overflow.bytes.Load()
+		}
+	}
+	if rf.f != nil {
+		s.Path = rf.f.Name()
+		if fi, err := rf.f.Stat(); err == nil {
+			s.Size = fi.Size()
+		}
+	}
+	return s
+}
+
+// NextRotation returns when the active file is next due to be rotated by
+// its schedule or Config.RotationPolicy, or the zero time before the first
+// file has been scheduled. Rotations by size or on request can come sooner.
+func (rf *Writer) NextRotation() time.Time {
+	if at := rf.nextAt.Load(); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
+}
+
+// CurrentSize returns the size of the file being written, including what
+// it held when opened, or 0 while no file is open.
+func (rf *Writer) CurrentSize() int64 {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return 0
+	}
+	return rf.f.size
+}
+
+// CurrentPath returns the path of the file being written. While no file
+// is open, such as when the writer has degraded, it is the path the writer
+// is trying to open.
+func (rf *Writer) CurrentPath() string {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f != nil {
+		return rf.f.Name()
+	}
+	name, _ := rf.layout.activePath(rf.clock.Now())
+	return name
+}
+
+// ActiveFile opens the file being written again for appending, for handing
+// to a child process as exec.Cmd's Stdout or Stderr. The file is the
+// caller's to close and keeps referring to the same file after the writer
+// has rotated; Config.OnFileOpen is told of every new file, so children
+// started later can be given that instead, and WrapCmd pipes output
+// through the writer so that it follows rotation. Files written through
+// StreamCompress, Encrypter or HMACKey would be corrupted by writes that
+// bypass the writer, so they are refused.
+func (rf *Writer) ActiveFile() (*os.File, error) {
+	if rf.config.layered() || rf.config.HMACKey != nil || rf.config.DirectIO || rf.fs != OSFS {
+		return nil, errors.New("rollinglog: ActiveFile cannot be used with StreamCompress, Encrypter, HMACKey, DirectIO or FS")
+	}
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.closed {
+		return nil, ErrClosed
+	}
+	if rf.f == nil {
+		return nil, rf.lastErr
+	}
+	active, err := rf.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(rf.f.Name(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || !os.SameFile(fi, active) {
+		f.Close()
+		return nil, fmt.Errorf("rollinglog: %s has been moved or replaced", rf.f.Name())
+	}
+	return f, nil
+}
+
+// Lost returns the number of bytes Write has failed to get into the log
+// file since the writer was created.
+func (rf *Writer) Lost() int64 {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	return rf.stats.Lost
+}
+
+// Recent returns the most recently written records, oldest first, when
+// Config.RecentBytes is set. Each element is the data of one Write call; a
+// record larger than the limit keeps only its tail.
+func (rf *Writer) Recent() [][]byte {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.recent == nil {
+		return nil
+	}
+	return rf.recent.snapshot()
 }
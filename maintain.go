@@ -0,0 +1,160 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A MaintainAction is a change Maintain made, or would make, to the files
+// on disk.
+type MaintainAction struct {
+	Op   string // "remove" or "compress", and with Plan "rotate"
+	Path string
+	At   time.Time // with "rotate", when it is due
+}
+
+// Maintain applies config's retention and compression policies to the
+// files already on disk, as a Writer does after each rotation: MaxFiles
+// and MaxTotalBytes prune the files the pattern produces, or with
+// QuotaCompressInPlace compress them, SkipEmpty removes the empty ones,
+// and with RolloverNumbered, backups beyond
+// MaxBackups are removed and those numbered CompressFrom and above are
+// compressed. The file the pattern names for the current period is never
+// touched. With dryRun the actions are only reported. It is meant for
+// processes that do not run long enough to maintain their own logs, and
+// should not race a Writer rotating the same files.
+func Maintain(config Config, dryRun bool) ([]MaintainAction, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	active, err := l.pathFor(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []MaintainAction
+	if config.Rollover == RolloverNumbered {
+		files, err := numberedFiles(l, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range files {
+			if p == active {
+				continue
+			}
+			suffix := p[len(active)+1:]
+			n, _ := strconv.Atoi(strings.TrimSuffix(suffix, ".gz"))
+			switch {
+			case config.MaxBackups > 0 && n > config.MaxBackups:
+				actions = append(actions, MaintainAction{Op: "remove", Path: p})
+			case config.CompressFrom > 0 && n >= config.CompressFrom && !strings.HasSuffix(suffix, ".gz"):
+				actions = append(actions, MaintainAction{Op: "compress", Path: p})
+			}
+		}
+	}
+	doomed, err := pruneCandidates(l, active)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range doomed {
+		actions = append(actions, MaintainAction{Op: "remove", Path: p})
+	}
+	if dryRun {
+		compressed, err := compressToQuota(l, active, true)
+		for _, p := range compressed {
+			actions = append(actions, MaintainAction{Op: "compress", Path: p})
+		}
+		return actions, err
+	}
+
+	// removals go first, in order; compressions share the worker pool
+	var done []MaintainAction
+	var compress []string
+	for _, a := range actions {
+		switch a.Op {
+		case "remove":
+			if err := removeLog(a.Path); err != nil && !os.IsNotExist(err) {
+				return done, err
+			}
+			done = append(done, a)
+		case "compress":
+			compress = append(compress, a.Path)
+		}
+	}
+	errs := newCompressor(&config).run(compress, config.Mode)
+	for i, p := range compress {
+		if errs[i] == nil {
+			done = append(done, MaintainAction{Op: "compress", Path: p})
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return done, err
+	}
+	actions = done
+	compressed, err := compressToQuota(l, active, false)
+	for _, p := range compressed {
+		actions = append(actions, MaintainAction{Op: "compress", Path: p})
+	}
+	return actions, err
+}
+
+// Plan reports what a Writer with config would do to the files on disk,
+// without changing anything, to check new settings before using them:
+// the rotation of the active file, with when it is due, followed by the
+// actions of a dry run of Maintain. A file over MaxSize, or with
+// RolloverRenamed one left from an earlier period, is due now.
+func Plan(config Config) ([]MaintainAction, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var actions []MaintainAction
+	if active, err := l.activePath(now); err != nil {
+		return nil, err
+	} else if fi, err := os.Stat(active); err == nil && fi.Mode().IsRegular() {
+		base, err := l.pathFor(now)
+		if err != nil {
+			return nil, err
+		}
+		at := l.nextRotation(now, base)
+		if config.MaxSize > 0 && fi.Size() >= config.MaxSize {
+			at = now
+		} else if config.Rollover == RolloverRenamed && fi.Size() > 0 {
+			if old, err := l.pathFor(fi.ModTime()); err == nil && old != base {
+				at = now
+			}
+		}
+		actions = append(actions, MaintainAction{Op: "rotate", Path: active, At: at})
+	}
+	maintained, err := Maintain(config, true)
+	return append(actions, maintained...), err
+}
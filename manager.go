@@ -0,0 +1,158 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A Manager runs many rolling logs, such as an access log, an error log
+// and an audit log, from one base config on a single Scheduler instead of
+// a goroutine per Writer: that of the base config, or one of the
+// Manager's own. Each log is named by the {session} placeholder of the
+// base pattern:
+//
+//	logs/{session}/{2006-01-02}.log
+//
+// Rotation work of one log, such as PostRotate and Archiver, delays the
+// others, so slow hooks are better handed to another goroutine. A base
+// config with a Scheduler from NewPooledScheduler throttles the
+// maintenance of all the logs together.
+type Manager struct {
+	config Config
+	live   sync.WaitGroup // logs the Scheduler has not let go of
+
+	mu      sync.Mutex
+	writers map[string]*Writer
+	closed  bool
+}
+
+// NewManager creates a Manager creating its logs from config.
+func NewManager(config Config) *Manager {
+	if config.Scheduler == nil {
+		config.Scheduler = NewScheduler()
+		if config.Clock != nil {
+			config.Scheduler.clock = config.Clock
+		}
+	}
+	return &Manager{
+		config:  config,
+		writers: make(map[string]*Writer),
+	}
+}
+
+// Open creates the log called name. Its config is the Manager's with
+// Session set to name, after adjust, if not nil, has made any changes of
+// its own other than to Scheduler. Names are used in paths, so they must be non-empty and may not
+// contain path separators.
+func (m *Manager) Open(name string, adjust func(config *Config)) (*Writer, error) {
+	if err := validSessionID(name); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errors.New("rollinglog: manager is closed")
+	}
+	if _, ok := m.writers[name]; ok {
+		return nil, fmt.Errorf("rollinglog: log %q is already open", name)
+	}
+
+	config := m.config
+	config.Session = name
+	if adjust != nil {
+		adjust(&config)
+	}
+	config.Scheduler = m.config.Scheduler
+	var w *Writer
+	m.live.Add(1)
+	w, err := newWriter(context.Background(), config, func() {
+		// closed or can no longer rotate
+		m.mu.Lock()
+		if m.writers[name] == w {
+			delete(m.writers, name)
+		}
+		m.mu.Unlock()
+		m.live.Done()
+	})
+	if err != nil {
+		m.live.Done()
+		return nil, err
+	}
+	m.writers[name] = w
+	return w, nil
+}
+
+// Lookup returns the open log called name, or nil.
+func (m *Manager) Lookup(name string) *Writer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writers[name]
+}
+
+// Close closes every log and stops the Manager. Logs closed on their own
+// beforehand are simply forgotten.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	open := make([]*Writer, 0, len(m.writers))
+	for _, w := range m.writers {
+		open = append(open, w)
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, w := range open {
+		errs = append(errs, w.Close())
+	}
+	m.live.Wait()
+	return errors.Join(errs...)
+}
+
+// Tell whatever drives the writer's rotation that it has work to do: its
+// goroutine or Scheduler, or with Config.InlineRotation the next write.
+func (rf *Writer) poke() {
+	if rf.config.InlineRotation {
+		rf.due.Store(0)
+	}
+	if rf.slot != nil {
+		rf.slot.sched.due(rf.slot)
+		return
+	}
+	if rf.wake == nil {
+		return
+	}
+	select {
+	case rf.wake <- struct{}{}:
+	default:
+	}
+}
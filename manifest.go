@@ -0,0 +1,197 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Suffix of the manifest sidecar written with Config.Manifest.
+const manifestSuffix = ".meta.json"
+
+// A Manifest describes a rotated log file. With Config.Manifest it is
+// written next to the file as path.meta.json before PostRotate and
+// Archiver see the file.
+type Manifest struct {
+	Start      time.Time `json:"start"` // when the writer opened the file; zero if not known
+	End        time.Time `json:"end"`   // when the writer closed the file
+	Lines      int64     `json:"lines"` // of the data as written
+	Bytes      int64     `json:"bytes"`
+	SHA256     string    `json:"sha256"`
+	Compressed bool      `json:"compressed,omitempty"` // Bytes and SHA256 are of the gzipped file
+	Reason     string    `json:"reason"`
+	Host       string    `json:"host"`
+	PID        int       `json:"pid"`
+}
+
+// ReadManifest returns the manifest written next to the log file at path.
+func ReadManifest(path string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(path + manifestSuffix)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// Times the writer opened and closed its files, kept for their manifests.
+type closedFiles struct {
+	mu    sync.Mutex
+	stats map[string]FileStats
+}
+
+func (c *closedFiles) note(p string, stats FileStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil {
+		c.stats = make(map[string]FileStats)
+	}
+	c.stats[p] = stats
+}
+
+// Carry the stats of the file closed as src over to its new name dst.
+func (c *closedFiles) move(src, dst string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stats, ok := c.stats[src]; ok {
+		delete(c.stats, src)
+		c.stats[dst] = stats
+	}
+}
+
+func (c *closedFiles) take(p string) (FileStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats, ok := c.stats[p]
+	delete(c.stats, p)
+	return stats, ok
+}
+
+// Write the manifest of rolled, and with Config.Checksum its checksum,
+// from a single pass over the file.
+func (rf *Writer) writeManifest(rolled string, reason RotateReason) error {
+	sum, lines, size, err := scanLog(rolled)
+	if err != nil {
+		return err
+	}
+	m := Manifest{
+		Lines:  lines,
+		Bytes:  size,
+		SHA256: hex.EncodeToString(sum),
+		Reason: reason.String(),
+		Host:   rf.layout.ph.hostname,
+		PID:    rf.layout.ph.pid,
+	}
+	if stats, ok := rf.finished.take(rolled); ok {
+		m.Start, m.End = stats.Opened, stats.Closed
+	} else if fi, err := os.Stat(rolled); err == nil {
+		m.End = fi.ModTime()
+	}
+	if rf.config.Checksum {
+		var err error
+		if algorithm := rf.config.ChecksumAlgorithm; algorithm == "" || algorithm == defaultChecksum {
+			err = saveChecksum(rolled, defaultChecksum, m.SHA256, rf.config.Mode)
+		} else {
+			err = writeChecksum(rolled, algorithm, rf.config.Mode) // another pass
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return saveManifest(rolled, m, rf.config.Mode)
+}
+
+// Return the SHA-256, line count and size of the file at p.
+func scanLog(p string) ([]byte, int64, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	var lines, size int64
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+		size += int64(n)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return h.Sum(nil), lines, size, nil
+}
+
+// Replace the manifest sidecar of p with m.
+func saveManifest(p string, m Manifest, mode os.FileMode) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p + manifestSuffix + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p+manifestSuffix); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Move the manifest of src, if it has one, to dst, which holds the same
+// log compressed, recording the compressed file's size and checksum.
+func refreshManifest(src, dst string, mode os.FileMode) error {
+	m, err := ReadManifest(src)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	sum, err := fileSHA256(dst)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	m.Bytes, m.SHA256, m.Compressed = fi.Size(), hex.EncodeToString(sum), true
+	if err := saveManifest(dst, m, mode); err != nil {
+		return err
+	}
+	return os.Remove(src + manifestSuffix)
+}
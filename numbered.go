@@ -0,0 +1,281 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned by lockFile when the lock is held elsewhere and it was asked not
+// to wait.
+var errLocked = errors.New("rollinglog: file is locked by another process")
+
+// RolloverMode selects how a finished file is moved out of the way.
+type RolloverMode int
+
+const (
+	// RolloverDated leaves finished files in place; every period gets its
+	// own path from the pattern.
+	RolloverDated RolloverMode = iota
+	// RolloverNumbered keeps a fixed active name and shifts finished files
+	// to numbered suffixes (app.log.1, app.log.2, ...).
+	RolloverNumbered
+	// RolloverRenamed keeps the fixed active name Config.ActivePath and
+	// renames finished files to the paths of their periods.
+	RolloverRenamed
+)
+
+func (m RolloverMode) MarshalText() ([]byte, error) {
+	switch m {
+	case RolloverDated:
+		return []byte("dated"), nil
+	case RolloverNumbered:
+		return []byte("numbered"), nil
+	case RolloverRenamed:
+		return []byte("renamed"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown rollover mode %d", int(m))
+}
+
+func (m *RolloverMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "dated", "":
+		*m = RolloverDated
+	case "numbered":
+		*m = RolloverNumbered
+	case "renamed":
+		*m = RolloverRenamed
+	default:
+		return fmt.Errorf("rollinglog: unknown rollover mode %q", text)
+	}
+	return nil
+}
+
+// Path of the numbered backup n of active, and whether it is compressed.
+func numberedPath(active string, n int) (string, bool) {
+	p := active + "." + strconv.Itoa(n)
+	if _, err := os.Lstat(p + ".gz"); err == nil {
+		return p + ".gz", true
+	}
+	return p, false
+}
+
+// The numbered backups of the active file for time t, oldest first,
+// followed by the active file itself if it exists.
+func numberedFiles(l *layout, t time.Time) ([]string, error) {
+	active, err := l.name(t, 0)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(globEscape(active) + ".*")
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		n    int
+		path string
+	}
+	var backups []backup
+	for _, p := range matches {
+		if n, err := strconv.Atoi(strings.TrimSuffix(p[len(active)+1:], ".gz")); err == nil {
+			backups = append(backups, backup{n, p})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].n > backups[j].n })
+	files := make([]string, 0, len(backups)+1)
+	for _, b := range backups {
+		files = append(files, b.path)
+	}
+	return append(files, existing(globEscape(active))...), nil
+}
+
+// Perform a numbered rotation of active, which was opened as the file
+// described by opened, and report whether it was shifted to active.1. When
+// another process sharing the pattern has already rotated it, or holds the
+// rotation lock, nothing is done. Backups due for compression are handed
+// to c, which finishes them in the background unless config.Lock has
+// other processes shifting them too.
+func rotateNumbered(active string, opened os.FileInfo, config *Config, c *compressor) (bool, error) {
+	if fi, err := os.Stat(active); err != nil || opened == nil || !os.SameFile(fi, opened) {
+		return false, nil
+	}
+	var shifted bool
+	var pending []string
+	err := withRotationLock(active, config, func() (err error) {
+		// check again now that no one else can be rotating
+		if fi, err := os.Stat(active); err != nil || !os.SameFile(fi, opened) {
+			return nil
+		}
+		// backups cannot be renamed from under a compression
+		c.wait()
+		if shifted, pending, err = shiftNumbered(active, config); err != nil || !config.Lock {
+			return err
+		}
+		errs := c.run(pending, config.Mode)
+		pending = nil
+		return errors.Join(errs...)
+	})
+	c.start(pending, config.Mode)
+	return shifted, err
+}
+
+// Run fn while holding the rotation lock of active, if config.Lock is set.
+// fn is skipped when another process holds the lock.
+func withRotationLock(active string, config *Config, fn func() error) error {
+	if !config.Lock {
+		return fn()
+	}
+
+	lf, err := os.OpenFile(active+".lock", os.O_CREATE|os.O_RDWR, config.Mode)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	if err := lockFile(lf, false); err == errLocked {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlockFile(lf)
+	return fn()
+}
+
+// Rotate active to active.1, shifting existing backups up by one. Backups
+// beyond MaxBackups are removed, and those numbered CompressFrom or higher
+// that are not compressed yet are returned. An empty active file is left
+// alone.
+func shiftNumbered(active string, config *Config) (bool, []string, error) {
+	maxBackups, compressFrom := config.MaxBackups, config.CompressFrom
+	if fi, err := os.Stat(active); err != nil || fi.Size() == 0 {
+		return false, nil, nil
+	}
+
+	// find the highest existing backup
+	last := 0
+	for {
+		if _, err := os.Lstat(active + "." + strconv.Itoa(last+1)); err != nil {
+			if _, err := os.Lstat(active + "." + strconv.Itoa(last+1) + ".gz"); err != nil {
+				break
+			}
+		}
+		last++
+	}
+
+	for n := last; n >= 1; n-- {
+		src, compressed := numberedPath(active, n)
+		if maxBackups > 0 && n+1 > maxBackups {
+			if err := removeLog(src); err != nil {
+				return false, nil, err
+			}
+			continue
+		}
+		dst := active + "." + strconv.Itoa(n+1)
+		if compressed {
+			dst += ".gz"
+		}
+		if err := renameLog(src, dst); err != nil {
+			return false, nil, err
+		}
+	}
+
+	if config.CopyTruncate {
+		if err := copyFile(active, active+".1", config.Mode); err != nil {
+			return false, nil, err
+		}
+		if err := os.Truncate(active, 0); err != nil {
+			return false, nil, err
+		}
+		if err := renameSidecars(active, active+".1"); err != nil {
+			return false, nil, err
+		}
+	} else if err := renameLog(active, active+".1"); err != nil {
+		return false, nil, err
+	}
+
+	if compressFrom <= 0 {
+		return true, nil, nil
+	}
+	var pending []string
+	for n := compressFrom; n <= last+1; n++ {
+		if p, compressed := numberedPath(active, n); !compressed {
+			if _, err := os.Lstat(p); err == nil {
+				pending = append(pending, p)
+			}
+		}
+	}
+	return true, pending, nil
+}
+
+// Compress the numbered backup p to p.gz, carrying its checksum and
+// manifest over.
+func compressBackup(p string, mode os.FileMode, level int) error {
+	if err := compressFile(p, mode, level); err != nil {
+		return err
+	}
+	if err := refreshChecksum(p, p+".gz", mode); err != nil {
+		return err
+	}
+	if err := refreshManifest(p, p+".gz", mode); err != nil {
+		return err
+	}
+	// offsets into the uncompressed file are of no use
+	if err := os.Remove(p + indexSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Copy the contents of src to a new file dst.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Open returns a reader over the log files config produces for the
+// periods between from and to, inclusive, concatenated oldest first with
+// compressed backups decompressed. Whole files are included, except that
+// files with an index written with Config.IndexEvery start at the last
+// record indexed before from; Export can narrow the output to individual
+// records. Files are opened one at a time as the reader reaches them, and
+// any removed in the meantime are skipped.
+func Open(config Config, from, to time.Time) (io.ReadCloser, error) {
+	l, err := newLayout(&config)
+	if err != nil {
+		return nil, err
+	}
+	files, err := exportFiles(l, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReader{files: files, from: from}, nil
+}
+
+// Reads a list of log files in turn.
+type rangeReader struct {
+	files []string
+	from  time.Time
+	f     *os.File
+	zr    *gzip.Reader
+	r     io.Reader
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	for {
+		if rr.r == nil {
+			if len(rr.files) == 0 {
+				return 0, io.EOF
+			}
+			name := rr.files[0]
+			rr.files = rr.files[1:]
+			if err := rr.open(name); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		n, err := rr.r.Read(p)
+		if err == io.EOF {
+			if err := rr.closeFile(); err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (rr *rangeReader) open(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	rr.f, rr.r = f, f
+	if off, err := IndexOffset(name, rr.from); err == nil && off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			f.Close()
+			rr.f, rr.r = nil, nil
+			return err
+		}
+	}
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			rr.f, rr.r = nil, nil
+			return err
+		}
+		rr.zr, rr.r = zr, zr
+	}
+	return nil
+}
+
+func (rr *rangeReader) closeFile() error {
+	if rr.f == nil {
+		return nil
+	}
+	var err error
+	if rr.zr != nil {
+		err = rr.zr.Close()
+	}
+	if cerr := rr.f.Close(); err == nil {
+		err = cerr
+	}
+	rr.f, rr.zr, rr.r = nil, nil, nil
+	return err
+}
+
+func (rr *rangeReader) Close() error {
+	rr.files = nil
+	return rr.closeFile()
+}
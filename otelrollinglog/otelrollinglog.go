@@ -0,0 +1,102 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package otelrollinglog reports a rollinglog.Writer's rotations and archive
+// operations to OpenTelemetry. Each operation becomes a span, and its
+// duration and failures are recorded as metrics:
+//
+//	rollinglog.operation.duration  histogram, seconds
+//	rollinglog.operation.failures  counter
+//
+// both with a rollinglog.operation attribute of "rotate" or "archive".
+//
+//	t, err := otelrollinglog.New(otel.Tracer("rollinglog"), otel.Meter("rollinglog"))
+//	...
+//	config.Tracer = t
+package otelrollinglog
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer implements rollinglog.Tracer.
+type Tracer struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	failures metric.Int64Counter
+}
+
+// New returns a Tracer that starts spans with tracer and records metrics
+// with meter. Either may be nil to skip spans or metrics.
+func New(tracer trace.Tracer, meter metric.Meter) (*Tracer, error) {
+	t := &Tracer{tracer: tracer}
+	if meter == nil {
+		return t, nil
+	}
+	var err error
+	t.duration, err = meter.Float64Histogram("rollinglog.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of log rotations and archive operations."))
+	if err != nil {
+		return nil, err
+	}
+	t.failures, err = meter.Int64Counter("rollinglog.operation.failures",
+		metric.WithDescription("Failed log rotations and archive operations."))
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tracer) Start(ctx context.Context, op, path string) (context.Context, func(err error)) {
+	var span trace.Span
+	if t.tracer != nil {
+		ctx, span = t.tracer.Start(ctx, "rollinglog."+op, trace.WithAttributes(attribute.String("rollinglog.path", path)))
+	}
+	start := time.Now()
+
+	return ctx, func(err error) {
+		attrs := metric.WithAttributes(attribute.String("rollinglog.operation", op))
+		if t.duration != nil {
+			t.duration.Record(ctx, time.Since(start).Seconds(), attrs)
+		}
+		if err != nil && t.failures != nil {
+			t.failures.Add(ctx, 1, attrs)
+		}
+		if span == nil {
+			return
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+// Records held in memory by Config.OutageBuffer while no file can be
+// written, oldest first.
+type outageBuffer struct {
+	records [][]byte
+	bytes   int64
+}
+
+// Hold a copy of p, the unwritten part of a record, reporting whether it
+// fit within Config.OutageBuffer. Called with mu held.
+func (rf *Writer) hold(p []byte) bool {
+	limit := rf.config.OutageBuffer
+	if limit == 0 {
+		return false
+	}
+	ob := &rf.outage
+	if ob.bytes+int64(len(p)) > limit {
+		rf.stats.OutageDropped++
+		return false
+	}
+	ob.records = append(ob.records, append([]byte(nil), p...))
+	ob.bytes += int64(len(p))
+	return true
+}
+
+// Write the records held during an outage to the current file, keeping
+// any that fail for later. Called with mu held.
+func (rf *Writer) replayOutage() error {
+	ob := &rf.outage
+	for len(ob.records) > 0 {
+		p := ob.records[0]
+		n, err := rf.writeLimited(p)
+		if err != nil {
+			ob.records[0] = p[n:]
+			ob.bytes -= int64(n)
+			return err
+		}
+		ob.records[0] = nil
+		ob.records = ob.records[1:]
+		ob.bytes -= int64(len(p))
+		rf.midLine = p[len(p)-1] != '\n'
+	}
+	ob.records = nil
+	return nil
+}
+
+// Count whatever is still held as lost, as the writer closes.
+func (rf *Writer) dropOutage() {
+	if ob := &rf.outage; ob.bytes > 0 {
+		rf.lose(int(ob.bytes))
+		rf.stats.OutageDropped += int64(len(ob.records))
+		*ob = outageBuffer{}
+	}
+}
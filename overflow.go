@@ -0,0 +1,123 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// The file of OverflowSpill, which takes the records that do not fit in
+// the Async queue while the log is slow to write. Once a record has gone
+// to it, later records follow it there until the queue has caught up, so
+// that they reach the log in the order written. Each record is a
+// little-endian uint32 length and its data.
+type overflowFile struct {
+	mu sync.Mutex // held from the check for waiting records until p is placed
+	f  *os.File
+	r  int64 // offset of the oldest record
+	w  int64 // offset past the newest
+
+	records atomic.Int64 // waiting in the file, for Stats
+	bytes   atomic.Int64
+}
+
+// Create the overflow file at p afresh.
+func createOverflow(p string, mode os.FileMode) (*overflowFile, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_RDWR, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &overflowFile{f: f}, nil
+}
+
+// Append p. Called with o.mu held.
+func (o *overflowFile) push(p []byte) error {
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(p)))
+	if _, err := o.f.WriteAt(length[:], o.w); err != nil {
+		return err
+	}
+	if _, err := o.f.WriteAt(p, o.w+4); err != nil {
+		return err
+	}
+	o.w += 4 + int64(len(p))
+	o.records.Add(1)
+	o.bytes.Add(int64(len(p)))
+	return nil
+}
+
+// Take the oldest record, appended to buf, reporting false once there are
+// none, when the file is emptied for reuse.
+func (o *overflowFile) pop(buf []byte) ([]byte, bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.r == o.w {
+		if o.r != 0 {
+			o.r, o.w = 0, 0
+			if err := o.f.Truncate(0); err != nil {
+				return nil, false, err
+			}
+		}
+		return nil, false, nil
+	}
+	var length [4]byte
+	if _, err := o.f.ReadAt(length[:], o.r); err != nil {
+		return nil, false, fmt.Errorf("reading overflow file: %w", err)
+	}
+	n := int(binary.LittleEndian.Uint32(length[:]))
+	p := append(buf, make([]byte, n)...)
+	if _, err := o.f.ReadAt(p[len(buf):], o.r+4); err != nil {
+		return nil, false, fmt.Errorf("reading overflow file: %w", err)
+	}
+	o.r += 4 + int64(n)
+	o.records.Add(-1)
+	o.bytes.Add(-int64(n))
+	return p, true, nil
+}
+
+// Give up on the records in the file, returning how many there were and
+// their size.
+func (o *overflowFile) discard() (int64, int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.r, o.w = 0, 0
+	o.f.Truncate(0)
+	return o.records.Swap(0), o.bytes.Swap(0)
+}
+
+// Close and remove the file.
+func (o *overflowFile) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := o.f.Close()
+	if rerr := os.Remove(o.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,501 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Values substituted for the non-date placeholders of a pattern. These are
+// resolved once when the log is created.
+type placeholders struct {
+	hostname string
+	pid      int
+	session  string
+}
+
+func newPlaceholders(session string) placeholders {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return placeholders{
+		hostname: hostname,
+		pid:      os.Getpid(),
+		session:  session,
+	}
+}
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentTime
+	segmentHostname
+	segmentPID
+	segmentEnv
+	segmentSession
+	segmentWeek // {2006-W04}, the ISO year and week
+)
+
+// The token for an ISO week, and the length of what it formats to.
+const (
+	weekToken = "2006-W04"
+	weekLen   = len("2006-W04")
+)
+
+// A single piece of a parsed pattern. text holds the literal text, the
+// time layout or the environment variable name depending on kind.
+type segment struct {
+	kind segmentKind
+	text string
+}
+
+// A FilepathPattern split into literal text and {...} tokens.
+type filePattern []segment
+
+// ErrNoTimeComponent is returned by ValidatePattern for a pattern that does
+// not contain any time layout, meaning the file name never changes.
+var ErrNoTimeComponent = errors.New("rollinglog: pattern contains no time component and will never roll")
+
+// PatternError describes a malformed FilepathPattern.
+type PatternError struct {
+	Pattern string
+	Token   string // offending token, if any
+	Offset  int    // byte offset of the problem within Pattern
+	Reason  string
+}
+
+func (e *PatternError) Error() string {
+	if e.Token != "" {
+		return fmt.Sprintf("rollinglog: bad token %s at offset %d in pattern %q: %s", e.Token, e.Offset, e.Pattern, e.Reason)
+	}
+	return fmt.Sprintf("rollinglog: %s at offset %d in pattern %q", e.Reason, e.Offset, e.Pattern)
+}
+
+// ValidatePattern checks that p is a well formed FilepathPattern. Malformed
+// patterns return a *PatternError naming the offending token. A pattern that
+// is well formed but contains no time layout returns ErrNoTimeComponent.
+func ValidatePattern(p string) error {
+	fp, err := parsePattern(p)
+	if err != nil {
+		return err
+	}
+	for _, seg := range fp {
+		if seg.kind == segmentTime || seg.kind == segmentWeek {
+			return nil
+		}
+	}
+	return ErrNoTimeComponent
+}
+
+// Split p into segments. Any number of {...} tokens may appear; braces must
+// be balanced and tokens may not nest. {hostname}, {pid}, {session} and
+// {env:NAME} are placeholders, {2006-W04} is the ISO week and any other
+// token is a time.Format layout.
+func parsePattern(p string) (filePattern, error) {
+	var fp filePattern
+	literal := 0
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '}':
+			return nil, &PatternError{Pattern: p, Offset: i, Reason: "unexpected }"}
+		case '{':
+			end := strings.IndexAny(p[i+1:], "{}")
+			if end == -1 || p[i+1+end] != '}' {
+				return nil, &PatternError{Pattern: p, Offset: i, Reason: "unterminated {"}
+			}
+			if literal < i {
+				fp = append(fp, segment{kind: segmentLiteral, text: p[literal:i]})
+			}
+			seg := tokenSegment(p[i+1 : i+1+end])
+			if reason := seg.check(); reason != "" {
+				return nil, &PatternError{Pattern: p, Token: p[i : i+2+end], Offset: i, Reason: reason}
+			}
+			fp = append(fp, seg)
+			i += 1 + end
+			literal = i + 1
+		}
+	}
+	if literal < len(p) {
+		fp = append(fp, segment{kind: segmentLiteral, text: p[literal:]})
+	}
+	return fp, nil
+}
+
+// Two instants that differ in every field time.Format knows about. A layout
+// that formats both identically contains no layout elements at all.
+var (
+	probeA = time.Date(2001, 2, 3, 4, 5, 6, 7000000, time.FixedZone("AAA", 3600))
+	probeB = time.Date(2012, 11, 12, 23, 59, 58, 999000000, time.FixedZone("BBB", -7200))
+)
+
+// Report why a token segment is unusable, or "" if it is fine.
+func (seg segment) check() string {
+	switch seg.kind {
+	case segmentTime:
+		if seg.text == "" {
+			return "empty token"
+		}
+		if probeA.Format(seg.text) == probeB.Format(seg.text) {
+			return "no time layout elements"
+		}
+	case segmentEnv:
+		if seg.text == "" {
+			return "missing environment variable name"
+		}
+	}
+	return ""
+}
+
+func tokenSegment(token string) segment {
+	switch {
+	case token == "hostname":
+		return segment{kind: segmentHostname}
+	case token == "pid":
+		return segment{kind: segmentPID}
+	case token == "session":
+		return segment{kind: segmentSession}
+	case strings.HasPrefix(token, "env:"):
+		return segment{kind: segmentEnv, text: token[len("env:"):]}
+	case token == weekToken:
+		return segment{kind: segmentWeek}
+	}
+	return segment{kind: segmentTime, text: token}
+}
+
+// Produce the path for time t.
+func (fp filePattern) format(ph placeholders, t time.Time) string {
+	var buf bytes.Buffer
+	for _, seg := range fp {
+		switch seg.kind {
+		case segmentLiteral:
+			buf.WriteString(seg.text)
+		case segmentTime:
+			buf.WriteString(t.Format(seg.text))
+		case segmentHostname:
+			buf.WriteString(ph.hostname)
+		case segmentPID:
+			buf.WriteString(strconv.Itoa(ph.pid))
+		case segmentEnv:
+			buf.WriteString(os.Getenv(seg.text))
+		case segmentSession:
+			buf.WriteString(ph.session)
+		case segmentWeek:
+			year, week := t.ISOWeek()
+			fmt.Fprintf(&buf, "%04d-W%02d", year, week)
+		}
+	}
+	return buf.String()
+}
+
+// The Monday starting ISO week s, formatted as by segmentWeek.
+func parseISOWeek(s string) (time.Time, bool) {
+	var year, week int
+	if len(s) != weekLen || s[4:6] != "-W" {
+		return time.Time{}, false
+	}
+	if _, err := fmt.Sscanf(s, "%04d-W%02d", &year, &week); err != nil || week < 1 || week > 53 {
+		return time.Time{}, false
+	}
+	// January 4th is always in week 1
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.Local)
+	monday := 4 - (int(jan4.Weekday())+6)%7
+	return time.Date(year, time.January, monday+(week-1)*7, 0, 0, 0, 0, time.Local), true
+}
+
+// Recover the time encoded in p, reporting whether p is a path the pattern
+// produces. Literal text must match exactly and each time layout takes the
+// text up to the next literal; the result must then format back to p, so
+// layouts repeated with conflicting values are rejected. An ISO week is
+// taken to mean its Monday unless the other layouts say more.
+func (fp filePattern) parse(ph placeholders, p string) (time.Time, bool) {
+	orig := p
+	// alternate literal text and merged layouts
+	var parts []segment
+	for _, seg := range fp {
+		if seg.kind != segmentTime && seg.kind != segmentWeek {
+			seg = segment{kind: segmentLiteral, text: (filePattern{seg}).format(ph, time.Time{})}
+			if seg.text == "" {
+				continue
+			}
+		}
+		if n := len(parts); n > 0 && parts[n-1].kind == seg.kind && seg.kind != segmentWeek {
+			parts[n-1].text += seg.text
+			continue
+		}
+		parts = append(parts, seg)
+	}
+
+	var layouts, values, weeks []string
+	for i, seg := range parts {
+		if seg.kind == segmentLiteral {
+			if !strings.HasPrefix(p, seg.text) {
+				return time.Time{}, false
+			}
+			p = p[len(seg.text):]
+			continue
+		}
+		if seg.kind == segmentWeek {
+			end := min(weekLen, len(p))
+			weeks = append(weeks, p[:end])
+			p = p[end:]
+			continue
+		}
+		end := len(p)
+		if i+1 < len(parts) {
+			if end = strings.Index(p, parts[i+1].text); end == -1 {
+				return time.Time{}, false
+			}
+		}
+		layouts = append(layouts, seg.text)
+		values = append(values, p[:end])
+		p = p[end:]
+	}
+	if p != "" {
+		return time.Time{}, false
+	}
+	var candidates []time.Time
+	if len(weeks) > 0 {
+		t, ok := parseISOWeek(weeks[0])
+		if !ok {
+			return time.Time{}, false
+		}
+		candidates = append(candidates, t)
+	}
+	if len(layouts) > 0 || len(weeks) == 0 {
+		t, err := time.ParseInLocation(strings.Join(layouts, "\x00"), strings.Join(values, "\x00"), time.Local)
+		if err == nil {
+			candidates = append(candidates, t)
+		}
+	}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if t := candidates[i]; fp.format(ph, t) == orig {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Units a pattern may resolve, finest first.
+var patternUnits = []time.Duration{
+	time.Nanosecond, time.Microsecond, time.Millisecond,
+	time.Second, time.Minute, time.Hour,
+}
+
+// The finest unit of time the pattern distinguishes, or 0 if it is a day or
+// coarser.
+func (fp filePattern) period() time.Duration {
+	base := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	for _, unit := range patternUnits {
+		for _, seg := range fp {
+			if seg.kind == segmentTime && base.Format(seg.text) != base.Add(unit).Format(seg.text) {
+				return unit
+			}
+		}
+	}
+	return 0
+}
+
+// A glob matching every path the pattern can produce. Time layouts become
+// wildcards, one per path element they span.
+func (fp filePattern) glob(ph placeholders) string {
+	var buf bytes.Buffer
+	for _, seg := range fp {
+		switch seg.kind {
+		case segmentWeek:
+			buf.WriteByte('*')
+		case segmentTime:
+			for i, part := range strings.Split(seg.text, "/") {
+				if i > 0 {
+					buf.WriteByte('/')
+				}
+				if part != "" {
+					buf.WriteByte('*')
+				}
+			}
+		default:
+			buf.WriteString(globEscape((filePattern{seg}).format(ph, time.Time{})))
+		}
+	}
+	return buf.String()
+}
+
+// A Namer produces the path of the file for time t with Dedupe sequence
+// number seq, where 0 is the plain name. A Namer that ignores seq gets a
+// sequence suffix added to its paths, as with NameTemplate.
+type Namer interface {
+	Path(t time.Time, seq int) string
+}
+
+// NewPatternNamer returns the Namer used for FilepathPattern by default,
+// so that custom Namers can build on it.
+func NewPatternNamer(pattern string) (Namer, error) {
+	fp, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return patternNamer{fp, newPlaceholders("")}, nil
+}
+
+// Names files from a parsed FilepathPattern.
+type patternNamer struct {
+	fp filePattern
+	ph placeholders
+}
+
+func (n patternNamer) Path(t time.Time, seq int) string {
+	p := n.fp.format(n.ph, t)
+	if seq != 0 {
+		p = sequencePath(p, seq)
+	}
+	return p
+}
+
+// NameData is the value passed to Config.NameTemplate when naming a file.
+type NameData struct {
+	Now      time.Time
+	Hostname string
+	PID      int
+	Seq      int
+	Session  string // see Sessions
+}
+
+// Execute tmpl to produce the path of the file for time t.
+func (ph placeholders) execute(tmpl *template.Template, t time.Time, seq int) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, NameData{
+		Now:      t,
+		Hostname: ph.hostname,
+		PID:      ph.pid,
+		Seq:      seq,
+		Session:  ph.session,
+	})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// PatternSyntax selects how date components of FilepathPattern are written.
+type PatternSyntax int
+
+const (
+	// SyntaxGo uses {layout} tokens with Go reference-time layouts.
+	SyntaxGo PatternSyntax = iota
+	// SyntaxStrftime uses strftime-style %-specifiers such as %Y/%m/%d.
+	// {hostname}, {pid}, {session} and {env:NAME} placeholders remain
+	// available.
+	SyntaxStrftime
+)
+
+func (s PatternSyntax) MarshalText() ([]byte, error) {
+	switch s {
+	case SyntaxGo:
+		return []byte("go"), nil
+	case SyntaxStrftime:
+		return []byte("strftime"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown pattern syntax %d", int(s))
+}
+
+func (s *PatternSyntax) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "go", "":
+		*s = SyntaxGo
+	case "strftime":
+		*s = SyntaxStrftime
+	default:
+		return fmt.Errorf("rollinglog: unknown pattern syntax %q", text)
+	}
+	return nil
+}
+
+var strftimeLayouts = map[byte]string{
+	'a': "Mon",
+	'A': "Monday",
+	'b': "Jan",
+	'B': "January",
+	'd': "02",
+	'D': "01/02/06",
+	'e': "_2",
+	'F': "2006-01-02",
+	'h': "Jan",
+	'H': "15",
+	'I': "03",
+	'j': "002",
+	'm': "01",
+	'M': "04",
+	'p': "PM",
+	'R': "15:04",
+	'S': "05",
+	'T': "15:04:05",
+	'y': "06",
+	'Y': "2006",
+	'z': "-0700",
+	'Z': "MST",
+}
+
+// Rewrite a strftime-style pattern into the equivalent {layout} pattern.
+func strftimeToPattern(p string) (string, error) {
+	var buf bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		if p[i] != '%' {
+			buf.WriteByte(p[i])
+			continue
+		}
+		i++
+		if i == len(p) {
+			return "", &PatternError{Pattern: p, Offset: i - 1, Reason: "trailing %"}
+		}
+		if p[i] == '%' {
+			buf.WriteByte('%')
+			continue
+		}
+		layout, ok := strftimeLayouts[p[i]]
+		if !ok {
+			return "", &PatternError{Pattern: p, Token: p[i-1 : i+1], Offset: i - 1, Reason: "unsupported strftime specifier"}
+		}
+		buf.WriteString("{" + layout + "}")
+	}
+	return buf.String(), nil
+}
+
+// Insert a -NNN sequence suffix before the extension of p.
+func sequencePath(p string, seq int) string {
+	ext := path.Ext(p)
+	if strings.ContainsRune(ext, '/') {
+		ext = ""
+	}
+	return fmt.Sprintf("%s-%03d%s", p[:len(p)-len(ext)], seq, ext)
+}
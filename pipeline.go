@@ -0,0 +1,258 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Stage is one step of a Pipeline. Run is given the path the previous
+// stage left the file at and returns the path for the next, so a stage
+// that compresses a file can hand on the compressed one. Stages may be run
+// again for a file after a crash, so they must tolerate having already
+// been applied to it.
+type Stage struct {
+	Name string
+	Run  func(ctx context.Context, path string) (string, error)
+}
+
+// A Pipeline is an Archiver that takes each rolled file through its stages
+// in order, such as compress, checksum, upload and delete. A failing
+// stage is retried Retries times, RetryDelay apart, or as Retry says if
+// its Base is set; if it still fails the file stays queued at that stage. With QueueFile set the queue is kept on
+// disk, and files a crash or failure left part way through are finished by
+// Resume, which a Writer calls as it starts.
+type Pipeline struct {
+	Stages     []Stage
+	Retries    int
+	RetryDelay time.Duration
+	Retry      Backoff
+	QueueFile  string
+
+	mu sync.Mutex
+}
+
+// A file waiting in a Pipeline for stage next.
+type pipelineItem struct {
+	next int
+	path string
+}
+
+func (pl *Pipeline) Archive(ctx context.Context, path string) error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	queue, err := pl.load()
+	if err != nil {
+		return err
+	}
+	queue = append(queue, pipelineItem{0, path})
+	return pl.process(ctx, queue, len(queue)-1)
+}
+
+// Resume runs the remaining stages for every file left in QueueFile.
+func (pl *Pipeline) Resume(ctx context.Context) error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	queue, err := pl.load()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := 0; i < len(queue); {
+		n := len(queue)
+		if err := pl.process(ctx, queue, i); err != nil {
+			errs = append(errs, err)
+		}
+		if queue, err = pl.load(); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if len(queue) == n {
+			i++ // still queued after a failure
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run the stages left for queue[i], saving its progress after each one and
+// dropping it from the queue once done.
+func (pl *Pipeline) process(ctx context.Context, queue []pipelineItem, i int) error {
+	if err := pl.save(queue); err != nil {
+		return err
+	}
+	item := &queue[i]
+	for item.next < len(pl.Stages) {
+		stage := pl.Stages[item.next]
+		p, err := pl.run(ctx, stage, item.path)
+		if err != nil {
+			return fmt.Errorf("rollinglog: %s %s: %w", stage.Name, item.path, err)
+		}
+		item.next, item.path = item.next+1, p
+		if err := pl.save(queue); err != nil {
+			return err
+		}
+	}
+	return pl.save(append(queue[:i:i], queue[i+1:]...))
+}
+
+// Run stage for path, retrying as configured.
+func (pl *Pipeline) run(ctx context.Context, stage Stage, path string) (string, error) {
+	if pl.Retry.enabled() {
+		var p string
+		var err error
+		run := func() error {
+			p, err = stage.Run(ctx, path)
+			return err
+		}
+		return p, pl.Retry.retry(ctx, nil, run(), run)
+	}
+	for attempt := 0; ; attempt++ {
+		p, err := stage.Run(ctx, path)
+		if err == nil || attempt >= pl.Retries {
+			return p, err
+		}
+		t := time.NewTimer(pl.RetryDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return "", errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// Read the queue from QueueFile. Each line holds the index of the next
+// stage and the path of a file.
+func (pl *Pipeline) load() ([]pipelineItem, error) {
+	if pl.QueueFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(pl.QueueFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var queue []pipelineItem
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		field, path, ok := strings.Cut(sc.Text(), " ")
+		next, err := strconv.Atoi(field)
+		if !ok || err != nil || next < 0 {
+			return nil, fmt.Errorf("rollinglog: malformed pipeline queue %s: %q", pl.QueueFile, sc.Text())
+		}
+		queue = append(queue, pipelineItem{next, path})
+	}
+	return queue, sc.Err()
+}
+
+// Replace QueueFile with queue.
+func (pl *Pipeline) save(queue []pipelineItem) error {
+	if pl.QueueFile == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, item := range queue {
+		fmt.Fprintf(&buf, "%d %s\n", item.next, item.path)
+	}
+	tmp := pl.QueueFile + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, pl.QueueFile)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// CompressStage gzips the file to path.gz, carrying any checksum sidecar
+// over. Files already ending in .gz are passed on as they are.
+func CompressStage() Stage {
+	return Stage{"compress", func(ctx context.Context, p string) (string, error) {
+		if strings.HasSuffix(p, ".gz") {
+			return p, nil
+		}
+		fi, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			if _, gzErr := os.Stat(p + ".gz"); gzErr == nil {
+				return p + ".gz", nil // finished before a crash
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		return p + ".gz", compressBackup(p, fi.Mode().Perm(), 0)
+	}}
+}
+
+// ChecksumStage writes a SHA-256 checksum next to the file, as
+// Config.Checksum does.
+func ChecksumStage() Stage {
+	return Stage{"checksum", func(ctx context.Context, p string) (string, error) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return "", err
+		}
+		return p, writeChecksum(p, defaultChecksum, fi.Mode().Perm())
+	}}
+}
+
+// UploadStage hands the file to a, which must leave it in place.
+func UploadStage(a Archiver) Stage {
+	return Stage{"upload", func(ctx context.Context, p string) (string, error) {
+		return p, a.Archive(ctx, p)
+	}}
+}
+
+// DeleteStage removes the file and its sidecars.
+func DeleteStage() Stage {
+	return Stage{"delete", func(ctx context.Context, p string) (string, error) {
+		if err := removeLog(p); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return p, nil
+	}}
+}
@@ -0,0 +1,163 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"time"
+)
+
+// A RotationPolicy adds triggers of its own to the writer's schedule, for
+// example to rotate when an upstream epoch changes. Next is asked about the
+// active file when it is opened and after every write to it, with the
+// bytes it holds. It returns when the file should be rotated, or the zero
+// time for no deadline, and whether to rotate it right away. Next is
+// called with the writer's lock held and must not call into the Writer.
+type RotationPolicy interface {
+	Next(now time.Time, currentSize int64) (rotateAt time.Time, rotateNow bool)
+}
+
+// RotateReason says why a file was rotated.
+type RotateReason int
+
+const (
+	// RotateScheduled means the file's period ended.
+	RotateScheduled RotateReason = iota + 1
+	// RotateSize means the file reached Config.MaxSize.
+	RotateSize
+	// RotateManual means Writer.Rotate was called.
+	RotateManual
+	// RotateClosed means the file was finished by Session.End.
+	RotateClosed
+	// RotatePolicy means Config.RotationPolicy asked for it.
+	RotatePolicy
+	// RotateHardLimit means a write was cut over to the next file to keep
+	// the file within Config.HardMaxBytes.
+	RotateHardLimit
+	// RotateReconfigured means Writer.Update changed the active file's
+	// name.
+	RotateReconfigured
+	// RotateGroup means another log of its Manager.Group rotated.
+	RotateGroup
+	// RotateRecovered means Config.RecoverOnStart found a rotated file
+	// that had not been through its hooks.
+	RotateRecovered
+)
+
+func (r RotateReason) String() string {
+	switch r {
+	case RotateScheduled:
+		return "scheduled"
+	case RotateSize:
+		return "size"
+	case RotateManual:
+		return "manual"
+	case RotateClosed:
+		return "closed"
+	case RotatePolicy:
+		return "policy"
+	case RotateHardLimit:
+		return "hard-limit"
+	case RotateReconfigured:
+		return "reconfigured"
+	case RotateGroup:
+		return "group"
+	case RotateRecovered:
+		return "recovered"
+	}
+	return fmt.Sprintf("RotateReason(%d)", int(r))
+}
+
+// Rotate asks for the active file to be finished and replaced now, as when
+// its period ends, and returns without waiting for that to happen. Within
+// one period the replacement takes the next Dedupe sequence name, such as
+// app-001.log; with RolloverNumbered the active file is shifted to
+// app.log.1 as usual.
+func (rf *Writer) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.closed {
+		return ErrClosed
+	}
+	rf.requestRotation(RotateManual)
+	return nil
+}
+
+// Ask the background goroutine to rotate for reason, unless a request is
+// already waiting. Called with rf.mu held.
+func (rf *Writer) requestRotation(reason RotateReason) {
+	select {
+	case rf.chRotate <- reason:
+		rf.poke()
+	default:
+	}
+}
+
+// Ask for a rotation once the active file reaches Config.MaxSize or the
+// RotationPolicy says it is due. Called with rf.mu held after each
+// successful write.
+func (rf *Writer) checkRotation() {
+	f := rf.f
+	if f == nil || f.due {
+		return
+	}
+	if rf.config.MaxSize > 0 && f.size >= rf.config.MaxSize {
+		f.due = true
+		rf.requestRotation(RotateSize)
+		return
+	}
+	if rf.config.RotationPolicy != nil {
+		now := rf.clock.Now()
+		at, rotateNow := rf.askPolicy(now)
+		if rotateNow || !at.IsZero() && !at.After(now) {
+			f.due = true
+			rf.requestRotation(RotatePolicy)
+			return
+		}
+		// a deadline set or moved by the write goes to the rotation
+		// goroutine, which sets its timer by it
+		if rf.policyAt.Swap(unixNano(at)) != unixNano(at) {
+			rf.poke()
+		}
+	}
+}
+
+// t in unix nanoseconds, or 0 for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// Consult Config.RotationPolicy about the active file. Called with rf.mu
+// held.
+func (rf *Writer) askPolicy(now time.Time) (time.Time, bool) {
+	var size int64
+	if rf.f != nil {
+		size = rf.f.size
+	}
+	return rf.config.RotationPolicy.Next(now, size)
+}
@@ -0,0 +1,132 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often Config.OnPressure is considered.
+	pressureInterval = time.Second
+	// Write latency taken as full pressure without Config.SlowWriteThreshold
+	// or MaxWriteLatency.
+	pressureLatency = 100 * time.Millisecond
+	// Free space taken as full pressure without Config.MinFreeBytes.
+	pressureFreeBytes = 64 << 20
+)
+
+// The default Config.PressureLevels.
+var defaultPressureLevels = []float64{0.5, 0.9}
+
+// What Pressure is measured from, readable without rf.mu so that a stalled
+// write does not stall Pressure too.
+type pressureState struct {
+	latency atomic.Int64 // moving average of write latency, in nanoseconds
+	writing atomic.Int64 // unix nanoseconds the write in progress began, or 0
+	failing atomic.Bool  // the last write or open failed
+
+	level int // the level last reported to Config.OnPressure
+}
+
+// Pressure returns how much the writer is struggling, from 0 for not at
+// all to 1 for writes failing or about to, so that applications can shed
+// verbose logging while it is high. It is the highest of how full the
+// Async queue is, how the recent latency of writes compares with
+// SlowWriteThreshold or MaxWriteLatency, or 100ms without either, and how
+// close free space is to MinFreeBytes, or 64MiB without it, rising from
+// four times that. It measures free space, so it costs a system call.
+func (rf *Writer) Pressure() float64 {
+	ps := &rf.pressure
+	if ps.failing.Load() {
+		return 1
+	}
+	p := 0.0
+	if q := rf.async; q != nil && q.limit > 0 {
+		p = max(p, float64(q.queued.Load())/float64(q.limit))
+	}
+
+	latency := time.Duration(ps.latency.Load())
+	if start := ps.writing.Load(); start != 0 {
+		latency = max(latency, time.Since(time.Unix(0, start)))
+	}
+	limit := pressureLatency
+	if rf.config.SlowWriteThreshold > 0 {
+		limit = rf.config.SlowWriteThreshold
+	} else if rf.config.MaxWriteLatency > 0 {
+		limit = rf.config.MaxWriteLatency
+	}
+	p = max(p, float64(latency)/float64(limit))
+
+	floor := uint64(pressureFreeBytes)
+	if rf.config.MinFreeBytes > 0 {
+		floor = rf.config.MinFreeBytes
+	}
+	if active, err := rf.layout.activePath(rf.clock.Now()); err == nil {
+		if free, err := freeBytes(path.Dir(active)); err == nil && free < 4*floor {
+			p = max(p, float64(4*floor-free)/float64(3*floor))
+		}
+	}
+	return min(p, 1)
+}
+
+// Account for a write to a log file that took took in Pressure. Called
+// with rf.mu held.
+func (ps *pressureState) observe(took time.Duration) {
+	avg := ps.latency.Load()
+	ps.latency.Store(avg + (int64(took)-avg)/8)
+}
+
+// Tell Config.OnPressure if Pressure has moved to another level, and
+// return when to look again. Called by whatever drives rotation.
+func (rf *Writer) samplePressure(now time.Time) time.Time {
+	levels := rf.config.PressureLevels
+	if levels == nil {
+		levels = defaultPressureLevels
+	}
+	p := rf.Pressure()
+	level := 0
+	for level < len(levels) && p >= levels[level] {
+		level++
+	}
+	if ps := &rf.pressure; level != ps.level {
+		ps.level = level
+		rf.config.OnPressure(p, level)
+	}
+	return now.Add(pressureInterval)
+}
+
+// Report an error for PressureLevels that are not increasing fractions.
+func checkPressureLevels(levels []float64) error {
+	for i, level := range levels {
+		if level <= 0 || level > 1 || i > 0 && level <= levels[i-1] {
+			return errors.New("rollinglog: PressureLevels must be increasing and between 0 and 1")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// ProfileTrigger configures profile capture during error storms. Once
+// Threshold lines matched by Match are written within Window, a goroutine
+// profile and a CPU profile of CPUDuration are written to files named by
+// Pattern, with .goroutine.pprof and .cpu.pprof appended. No further
+// capture starts until Cooldown has passed.
+type ProfileTrigger struct {
+	Threshold int
+	Window    time.Duration // default one minute
+	Cooldown  time.Duration // default ten minutes
+
+	// Match reports whether a record is an error line. The default matches
+	// records containing "ERROR".
+	Match func(p []byte) bool
+
+	// Pattern is a FilepathPattern for the profile files. The default is
+	// profiles/{2006-01-02T15-04-05} next to the active log file.
+	Pattern     string
+	CPUDuration time.Duration // default ten seconds
+}
+
+type profiler struct {
+	ProfileTrigger
+	fp      filePattern // nil for the default pattern
+	ph      placeholders
+	mode    os.FileMode
+	dirMode os.FileMode
+	closed  <-chan struct{}
+
+	// guarded by the writer's mutex
+	start time.Time
+	count int
+	last  time.Time
+
+	busy atomic.Bool
+}
+
+func newProfiler(t ProfileTrigger, l *layout, closed <-chan struct{}) (*profiler, error) {
+	if t.Window == 0 {
+		t.Window = time.Minute
+	}
+	if t.Cooldown == 0 {
+		t.Cooldown = 10 * time.Minute
+	}
+	if t.CPUDuration == 0 {
+		t.CPUDuration = 10 * time.Second
+	}
+	if t.Match == nil {
+		t.Match = func(p []byte) bool { return bytes.Contains(p, []byte("ERROR")) }
+	}
+	pr := &profiler{
+		ProfileTrigger: t,
+		ph:             l.ph,
+		mode:           l.config.Mode,
+		dirMode:        l.config.DirMode,
+		closed:         closed,
+	}
+	if t.Pattern != "" {
+		fp, err := parsePattern(t.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		pr.fp = fp
+	}
+	return pr, nil
+}
+
+// Count p if it is an error line and start a capture if the rate has been
+// exceeded. active is the path of the active log file.
+func (pr *profiler) observe(p []byte, active string) {
+	if pr.Threshold <= 0 || !pr.Match(p) {
+		return
+	}
+	now := time.Now()
+	if now.Sub(pr.start) > pr.Window {
+		pr.start, pr.count = now, 0
+	}
+	pr.count++
+	if pr.count < pr.Threshold || now.Sub(pr.last) < pr.Cooldown || !pr.busy.CompareAndSwap(false, true) {
+		return
+	}
+	pr.last, pr.count = now, 0
+
+	name := path.Join(path.Dir(active), "profiles", now.Format("2006-01-02T15-04-05"))
+	if pr.fp != nil {
+		name = pr.fp.format(pr.ph, now)
+	}
+	go func() {
+		defer pr.busy.Store(false)
+		if err := pr.capture(name); err != nil {
+			log.Printf("rollinglog: profile capture: %v", err)
+		}
+	}()
+}
+
+func (pr *profiler) capture(name string) error {
+	if err := os.MkdirAll(path.Dir(name), pr.dirMode); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	g, err := os.OpenFile(name+".goroutine.pprof", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, pr.mode)
+	if err != nil {
+		return err
+	}
+	err = pprof.Lookup("goroutine").WriteTo(g, 0)
+	if cerr := g.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := os.OpenFile(name+".cpu.pprof", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, pr.mode)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := pprof.StartCPUProfile(c); err != nil {
+		return err
+	}
+	t := time.NewTimer(pr.CPUDuration)
+	select {
+	case <-t.C:
+	case <-pr.closed:
+		t.Stop()
+	}
+	pprof.StopCPUProfile()
+	return c.Close()
+}
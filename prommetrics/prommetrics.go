@@ -0,0 +1,84 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package prommetrics exports the counters of a rollinglog.Writer as
+// Prometheus metrics. The values are read from Writer.Stats at scrape time.
+//
+//	w := rollinglog.NewMust(config)
+//	prometheus.MustRegister(prommetrics.NewCollector(w, "access"))
+package prommetrics
+
+import (
+	"github.com/mendsley/rollinglog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector for one Writer.
+type Collector struct {
+	w *rollinglog.Writer
+
+	bytes     *prometheus.Desc
+	writes    *prometheus.Desc
+	rotations *prometheus.Desc
+	errors    *prometheus.Desc
+	lost      *prometheus.Desc
+	size      *prometheus.Desc
+}
+
+// NewCollector returns a collector for w. Its metrics carry a log label
+// set to name, so several writers can be registered side by side.
+func NewCollector(w *rollinglog.Writer, name string) *Collector {
+	labels := prometheus.Labels{"log": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("rollinglog_"+metric, help, nil, labels)
+	}
+	return &Collector{
+		w:         w,
+		bytes:     desc("bytes_written_total", "Bytes written to log files."),
+		writes:    desc("writes_total", "Write calls."),
+		rotations: desc("rotations_total", "Rotations performed."),
+		errors:    desc("write_errors_total", "Failed writes and failed attempts to open a log file."),
+		lost:      desc("lost_bytes_total", "Bytes that did not reach the log file."),
+		size:      desc("current_file_size_bytes", "Size of the active log file."),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bytes
+	ch <- c.writes
+	ch <- c.rotations
+	ch <- c.errors
+	ch <- c.lost
+	ch <- c.size
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.w.Stats()
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.CounterValue, float64(s.Bytes))
+	ch <- prometheus.MustNewConstMetric(c.writes, prometheus.CounterValue, float64(s.Writes))
+	ch <- prometheus.MustNewConstMetric(c.rotations, prometheus.CounterValue, float64(s.Rotations))
+	ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors))
+	ch <- prometheus.MustNewConstMetric(c.lost, prometheus.CounterValue, float64(s.Lost))
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(s.Size))
+}
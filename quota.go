@@ -0,0 +1,212 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuotaPolicy selects what happens once the log files take more than
+// Config.MaxTotalBytes.
+type QuotaPolicy int
+
+const (
+	// QuotaPurgeOldest deletes the oldest files after each rotation until
+	// the rest fit.
+	QuotaPurgeOldest QuotaPolicy = iota
+	// QuotaStopWriting deletes nothing and instead fails writes that would
+	// take the files over the quota with a *QuotaError, until space is
+	// freed by other means.
+	QuotaStopWriting
+	// QuotaCompressInPlace deletes nothing and instead gzips the oldest
+	// files after each rotation until the files fit. Once everything but
+	// the active file is compressed the quota is allowed to be exceeded.
+	QuotaCompressInPlace
+)
+
+func (p QuotaPolicy) MarshalText() ([]byte, error) {
+	switch p {
+	case QuotaPurgeOldest:
+		return []byte("purge-oldest"), nil
+	case QuotaStopWriting:
+		return []byte("stop-writing"), nil
+	case QuotaCompressInPlace:
+		return []byte("compress-in-place"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown quota policy %d", int(p))
+}
+
+func (p *QuotaPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "purge-oldest", "":
+		*p = QuotaPurgeOldest
+	case "stop-writing":
+		*p = QuotaStopWriting
+	case "compress-in-place":
+		*p = QuotaCompressInPlace
+	default:
+		return fmt.Errorf("rollinglog: unknown quota policy %q", text)
+	}
+	return nil
+}
+
+// A QuotaError is returned by writes refused under QuotaStopWriting.
+type QuotaError struct {
+	Limit int64 // Config.MaxTotalBytes
+	Used  int64 // bytes taken by the log files, the active one included
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("rollinglog: log files take %d bytes, the write would exceed the quota of %d", e.Used, e.Limit)
+}
+
+// How often a writer refusing writes under QuotaStopWriting looks again
+// at what the other files take, in case space has been freed.
+const quotaRecheck = time.Second
+
+// What the files other than the active one took when last measured.
+type quotaState struct {
+	others  int64
+	active  *logFile // the active file at the time
+	checked time.Time
+}
+
+// Refuse a write of n bytes that would take the files over the quota with
+// QuotaStopWriting. Called with rf.mu held.
+func (rf *Writer) checkQuota(n int) error {
+	config := &rf.config
+	f := rf.f
+	if config.QuotaPolicy != QuotaStopWriting || config.MaxTotalBytes == 0 || f == nil {
+		return nil
+	}
+	q := &rf.quota
+	now := rf.clock.Now()
+	over := q.others+f.size+int64(n) > config.MaxTotalBytes
+	if q.active != f || over && now.Sub(q.checked) >= quotaRecheck {
+		files, err := quotaFiles(rf.layout, f.Name())
+		if err != nil {
+			return err
+		}
+		q.others, q.active, q.checked = 0, f, now
+		for _, qf := range files {
+			q.others += qf.size
+		}
+	}
+	if used := q.others + f.size; used+int64(n) > config.MaxTotalBytes {
+		return &QuotaError{Limit: config.MaxTotalBytes, Used: used}
+	}
+	return nil
+}
+
+// A file counted towards Config.MaxTotalBytes.
+type quotaFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// The files other than active that count towards the quota, whether
+// compressed or not, oldest first.
+func quotaFiles(l *layout, active string) ([]quotaFile, error) {
+	pattern := l.fp.glob(l.ph)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := filepath.Glob(pattern + ".gz")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var files []quotaFile
+	for _, p := range append(matches, compressed...) {
+		if seen[p] || p == active || isSidecar(p) {
+			continue
+		}
+		seen[p] = true
+		fi, err := os.Stat(p)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, quotaFile{p, fi.Size(), fi.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.Before(files[j].modTime)
+		}
+		return files[i].path < files[j].path
+	})
+	return files, nil
+}
+
+// With QuotaCompressInPlace, gzip the oldest files other than active until
+// the files fit in the quota, and return the files compressed. With dryRun
+// nothing is compressed and each file is taken to free all it takes, so
+// the files returned are the least that would be compressed.
+func compressToQuota(l *layout, active string, dryRun bool) ([]string, error) {
+	config := l.config
+	if config.QuotaPolicy != QuotaCompressInPlace || config.MaxTotalBytes == 0 {
+		return nil, nil
+	}
+	files, err := quotaFiles(l, active)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if fi, err := os.Stat(active); err == nil {
+		total = fi.Size()
+	}
+	for _, qf := range files {
+		total += qf.size
+	}
+
+	var done []string
+	for _, qf := range files {
+		if total <= config.MaxTotalBytes {
+			break
+		}
+		if strings.HasSuffix(qf.path, ".gz") {
+			continue
+		}
+		total -= qf.size
+		done = append(done, qf.path)
+		if dryRun {
+			continue
+		}
+		if err := compressBackup(qf.path, config.Mode, config.CompressLevel); err != nil {
+			return done[:len(done)-1], err
+		}
+		if fi, err := os.Stat(qf.path + ".gz"); err == nil {
+			total += fi.Size()
+		}
+	}
+	return done, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a RateLimiter. Each limit is a token bucket holding
+// up to Burst seconds of its rate; zero disables it.
+type RateLimit struct {
+	LinesPerSecond float64 `json:"lines_per_second" yaml:"lines_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second" yaml:"bytes_per_second"`
+	Burst          float64 `json:"burst" yaml:"burst"` // seconds, default 1
+
+	// SampleEvery, if non-zero, lets one in SampleEvery records through
+	// while over the limit instead of dropping them all.
+	SampleEvery int `json:"sample_every" yaml:"sample_every"`
+
+	// SummaryEvery, if non-zero, also writes the line accounting for
+	// dropped records while they are still being dropped, once the first
+	// it counts is this old.
+	SummaryEvery time.Duration `json:"summary_every" yaml:"summary_every"`
+}
+
+// A RateLimiter passes records to a writer, such as a Writer, no faster
+// than its RateLimit and drops the rest. When records flow again after
+// some were dropped, a line accounting for them is written first, giving
+// the times of the first and last and the limits they exceeded, as with
+// Config.DropSummary. Safe for concurrent use.
+type RateLimiter struct {
+	w     io.Writer
+	limit RateLimit
+
+	mu      sync.Mutex
+	last    time.Time
+	lines   float64 // tokens available
+	bytes   float64
+	over    int         // records seen over the limit since it was last under
+	pending dropSummary // records dropped since the last summary
+	dropped int64       // records dropped in total
+}
+
+// NewRateLimiter returns a RateLimiter writing to w.
+func NewRateLimiter(w io.Writer, limit RateLimit) *RateLimiter {
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	return &RateLimiter{
+		w:     w,
+		limit: limit,
+		last:  time.Now(),
+		lines: limit.LinesPerSecond * limit.Burst,
+		bytes: limit.BytesPerSecond * limit.Burst,
+	}
+}
+
+// Write passes p on if it is within the limit. A dropped record is
+// reported as written.
+func (r *RateLimiter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lines := float64(bytes.Count(p, []byte{'\n'}))
+	if lines == 0 {
+		lines = 1
+	}
+	now := time.Now()
+	if reason := r.admit(now, lines, float64(len(p))); reason != "" {
+		r.pending.add(reason, len(p), now)
+		r.dropped++
+		if every := r.limit.SummaryEvery; every > 0 && now.Sub(r.pending.first) >= every {
+			r.summarize()
+		}
+		return len(p), nil
+	}
+
+	r.summarize()
+	return r.w.Write(p)
+}
+
+// Write the line accounting for the records dropped since the last, if
+// any.
+func (r *RateLimiter) summarize() {
+	if r.pending.records == 0 {
+		return
+	}
+	if _, err := r.w.Write(r.pending.line()); err == nil {
+		r.pending.reset()
+	}
+}
+
+// Refill the buckets up to now and report whether a record of the given
+// size may pass, taking its tokens if so, or else the limit it exceeds. A
+// record larger than a bucket passes when the bucket is full. Sampled
+// records take no tokens.
+func (r *RateLimiter) admit(now time.Time, lines, size float64) string {
+	elapsed := now.Sub(r.last).Seconds()
+	r.last = now
+	var reason string
+	if rate := r.limit.LinesPerSecond; rate > 0 {
+		full := rate * r.limit.Burst
+		r.lines = min(r.lines+rate*elapsed, full)
+		if r.lines < lines && r.lines != full {
+			reason = "line rate"
+		}
+	}
+	if rate := r.limit.BytesPerSecond; rate > 0 {
+		full := rate * r.limit.Burst
+		r.bytes = min(r.bytes+rate*elapsed, full)
+		if r.bytes < size && r.bytes != full && reason == "" {
+			reason = "byte rate"
+		}
+	}
+
+	if reason != "" {
+		r.over++
+		if r.limit.SampleEvery > 0 && r.over%r.limit.SampleEvery == 0 {
+			return ""
+		}
+		return reason
+	}
+	r.over = 0
+	r.lines = max(r.lines-lines, 0)
+	r.bytes = max(r.bytes-size, 0)
+	return ""
+}
+
+// Dropped returns the number of records dropped so far.
+func (r *RateLimiter) Dropped() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.dropped
+}
@@ -0,0 +1,85 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog_test
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+)
+
+// A drop summary line, with its times.
+var summaryLine = regexp.MustCompile(`^rollinglog: dropped (\d+ records?) \((\d+) bytes\) between \S+ and \S+, reasons: (.*)\n$`)
+
+func TestRateLimiterSummary(t *testing.T) {
+	var buf bytes.Buffer
+	r := rollinglog.NewRateLimiter(&buf, rollinglog.RateLimit{LinesPerSecond: 20, Burst: 0.05})
+	io.WriteString(r, "first\n")
+	io.WriteString(r, "second\n")
+	time.Sleep(2 * time.Millisecond)
+	io.WriteString(r, "third\n")
+	time.Sleep(100 * time.Millisecond)
+	io.WriteString(r, "fourth\n")
+
+	lines := bytes.SplitAfter(buf.Bytes(), []byte("\n"))
+	if len(lines) != 4 || string(lines[0]) != "first\n" || string(lines[2]) != "fourth\n" {
+		t.Fatalf("got %q", buf.Bytes())
+	}
+	m := summaryLine.FindSubmatch(lines[1])
+	if m == nil {
+		t.Fatalf("got summary %q", lines[1])
+	}
+	if string(m[1]) != "2 records" || string(m[2]) != "13" || string(m[3]) != "line rate 2" {
+		t.Errorf("got summary %q", lines[1])
+	}
+	if n := r.Dropped(); n != 2 {
+		t.Errorf("dropped %d records, want 2", n)
+	}
+}
+
+func TestRateLimiterSummaryEvery(t *testing.T) {
+	var buf bytes.Buffer
+	r := rollinglog.NewRateLimiter(&buf, rollinglog.RateLimit{
+		BytesPerSecond: 1,
+		Burst:          8,
+		SummaryEvery:   time.Millisecond,
+	})
+	io.WriteString(r, "first\n")
+	io.WriteString(r, "second\n")
+	time.Sleep(2 * time.Millisecond)
+	io.WriteString(r, "third\n") // summarized with second while still dropping
+
+	lines := bytes.SplitAfter(buf.Bytes(), []byte("\n"))
+	if len(lines) != 3 || string(lines[0]) != "first\n" {
+		t.Fatalf("got %q", buf.Bytes())
+	}
+	if m := summaryLine.FindSubmatch(lines[1]); m == nil || string(m[1]) != "2 records" || string(m[3]) != "byte rate 2" {
+		t.Errorf("got summary %q", lines[1])
+	}
+}
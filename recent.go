@@ -0,0 +1,71 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+// Keeps copies of the most recent records up to a total size.
+type recentRing struct {
+	records [][]byte
+	size    int
+	max     int
+	spare   [][]byte // buffers of dropped records, for reuse
+}
+
+// Most buffers kept in recentRing.spare.
+const maxRecentSpare = 16
+
+func (r *recentRing) add(p []byte) {
+	if len(p) > r.max {
+		p = p[len(p)-r.max:]
+	}
+	var buf []byte
+	if n := len(r.spare); n > 0 {
+		buf, r.spare = r.spare[n-1], r.spare[:n-1]
+	}
+	r.records = append(r.records, append(buf[:0], p...))
+	r.size += len(p)
+
+	drop := 0
+	for r.size > r.max {
+		r.size -= len(r.records[drop])
+		if len(r.spare) < maxRecentSpare {
+			r.spare = append(r.spare, r.records[drop])
+		}
+		r.records[drop] = nil
+		drop++
+	}
+	if drop > 0 {
+		r.records = append(r.records[:0], r.records[drop:]...)
+	}
+}
+
+// Copy of the retained records, oldest first. Their buffers are reused,
+// so the records are copied too.
+func (r *recentRing) snapshot() [][]byte {
+	out := make([][]byte, len(r.records))
+	for i, p := range r.records {
+		out[i] = append([]byte(nil), p...)
+	}
+	return out
+}
@@ -0,0 +1,234 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tidy the numbered backups of active after a crash during rotation:
+// abandoned compression outputs are removed, a backup that exists both
+// compressed and uncompressed keeps only the finished .gz, and gaps left by
+// an interrupted shift are closed so MaxBackups sees every file.
+func recoverNumbered(active string, config *Config) error {
+	matches, err := filepath.Glob(globEscape(active) + ".*")
+	if err != nil {
+		return err
+	}
+
+	var indexes []int
+	seen := make(map[int]bool)
+	for _, p := range matches {
+		suffix := p[len(active)+1:]
+		if strings.HasSuffix(suffix, compressTempSuffix) {
+			// the uncompressed source is still in place and will be
+			// compressed again at the next rotation
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(suffix, ".gz"))
+		if err != nil || n < 1 || seen[n] {
+			continue
+		}
+		seen[n] = true
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+
+	for i, n := range indexes {
+		p := active + "." + strconv.Itoa(n)
+		if _, err := os.Lstat(p + ".gz"); err == nil {
+			// compression finished but the source was never removed
+			if err := removeLog(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		src, compressed := numberedPath(active, n)
+		if n == i+1 {
+			continue
+		}
+		dst := active + "." + strconv.Itoa(i+1)
+		if compressed {
+			dst += ".gz"
+		}
+		if err := renameLog(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Escape the glob metacharacters in p.
+func globEscape(p string) string {
+	var b strings.Builder
+	for _, c := range p {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Suffixes of the files written next to a log file and renamed into place
+// once finished, which a crash can leave behind.
+func tempSuffixes() []string {
+	suffixes := []string{manifestSuffix + ".tmp", compressTempSuffix}
+	for _, suffix := range checksumSuffixes() {
+		suffixes = append(suffixes, suffix+".tmp")
+	}
+	return suffixes
+}
+
+// Tidy the files of the log after a crash, for Config.RecoverOnStart, and
+// run the hooks of rotated files that missed them.
+func (rf *Writer) recoverFiles(active string) {
+	var missed []string
+	err := withRotationLock(active, &rf.config, func() error {
+		var err error
+		missed, err = recoverFiles(rf.layout, active)
+		return err
+	})
+	if err != nil {
+		rf.logf("recovering files of %s: %w", active, err)
+	}
+	for _, p := range missed {
+		rf.postRotate(rf.ctx, p, RotateRecovered)
+	}
+}
+
+// Remove the unfinished and orphaned files next to the log's files and,
+// with Config.Checksum or Manifest, return the rotated files without one.
+func recoverFiles(l *layout, active string) ([]string, error) {
+	active = filepath.Clean(active)
+	files, err := listFiles(l)
+	if err != nil {
+		return nil, err
+	}
+	dirs := map[string]bool{filepath.Dir(active): true}
+	for _, lf := range files {
+		dirs[filepath.Dir(lf.Path)] = true
+	}
+	for dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			p := filepath.Join(dir, e.Name())
+			base, temp := sidecarBase(p)
+			if base == "" || !ownsPath(l, active, base) {
+				continue
+			}
+			if !temp {
+				if _, err := os.Lstat(base); !os.IsNotExist(err) {
+					continue
+				}
+			}
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	config := l.config
+	if !config.Checksum && !config.Manifest {
+		return nil, nil
+	}
+	algorithm := config.ChecksumAlgorithm
+	if algorithm == "" {
+		algorithm = defaultChecksum
+	}
+	lacks := func(p, suffix string) bool {
+		_, err := os.Lstat(p + suffix)
+		return os.IsNotExist(err)
+	}
+	var missed []string
+	for _, lf := range files {
+		if filepath.Clean(lf.Path) == active {
+			continue
+		}
+		noChecksum := config.Checksum && lacks(lf.Path, "."+algorithm)
+		if !noChecksum && !(config.Manifest && lacks(lf.Path, manifestSuffix)) {
+			continue
+		}
+		if lf.Compressed && config.Rollover == RolloverNumbered {
+			if !noChecksum {
+				continue
+			}
+			// compressed by a later rotation, after its hooks had run
+			if err := writeChecksum(lf.Path, algorithm, config.Mode); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		missed = append(missed, lf.Path)
+	}
+	return missed, nil
+}
+
+// Return the log file p is written next to, and whether p is unfinished,
+// or "" if p is neither a sidecar nor a temporary file.
+func sidecarBase(p string) (string, bool) {
+	for _, suffix := range tempSuffixes() {
+		if strings.HasSuffix(p, suffix) {
+			return strings.TrimSuffix(p, suffix), true
+		}
+	}
+	for _, suffix := range sidecarSuffixes() {
+		if strings.HasSuffix(p, suffix) {
+			return strings.TrimSuffix(p, suffix), false
+		}
+	}
+	return "", false
+}
+
+// Reports whether p is a name the log whose active file is active could
+// have given one of its files.
+func ownsPath(l *layout, active, p string) bool {
+	if p == active || l.active != "" && p == filepath.Clean(l.active) {
+		return true
+	}
+	if l.config.Rollover == RolloverNumbered {
+		return strings.HasPrefix(p, active+".")
+	}
+	if _, ok := l.fp.parse(l.ph, p); ok {
+		return true
+	}
+	if base, seq := splitSequence(p); seq != 0 {
+		_, ok := l.fp.parse(l.ph, base)
+		return ok
+	}
+	return false
+}
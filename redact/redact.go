@@ -0,0 +1,210 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package redact masks secrets, such as API keys, bearer tokens and card
+// numbers, in records before a rollinglog.Writer persists them.
+//
+//	config.Filters = append(config.Filters, redact.New(redact.Defaults...).Filter)
+//
+// Matches are replaced with [REDACTED:name], naming the rule that found
+// them, so that what was removed can still be audited.
+package redact
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A Rule describes one kind of secret.
+type Rule struct {
+	Name string
+
+	// Pattern matches the secret. When it has a subexpression only the
+	// text of the first is masked, so that a rule can keep the key of a
+	// key=value pair and mask the value.
+	Pattern *regexp.Regexp
+
+	// Valid, if set, confirms a match before it is masked, to rule out
+	// look-alikes such as numbers failing a checksum.
+	Valid func(match []byte) bool
+}
+
+// Defaults are the rules for common secrets.
+var Defaults = []Rule{
+	{Name: "private-key", Pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{Name: "bearer", Pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`)},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{Name: "aws-access-key", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	Keyword("credential", "password", "passwd", "secret", "api_key", "apikey", "access_key", "access_token", "client_secret"),
+	{Name: "card", Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), Valid: Card},
+}
+
+// Keyword returns a rule named name masking the values assigned to any of
+// keywords, matched regardless of case, in forms such as password=hunter2,
+// secret: hunter2 and "api_key": "hunter2".
+func Keyword(name string, keywords ...string) Rule {
+	quoted := make([]string, len(keywords))
+	for i, k := range keywords {
+		quoted[i] = regexp.QuoteMeta(k)
+	}
+	return Rule{
+		Name:    name,
+		Pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b["']?\s*[:=]\s*["']?([^\s"',;&]+)`),
+	}
+}
+
+// Luhn reports whether the digits of match, ignoring spaces and dashes,
+// pass the Luhn checksum of payment card numbers.
+func Luhn(match []byte) bool {
+	sum, n := 0, 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// Card reports whether the digits of match, ignoring spaces and dashes,
+// form a payment card number: a known issuer prefix at a length that issuer
+// uses, passing the Luhn checksum. The prefix check keeps other long
+// numbers, such as Unix timestamps in milliseconds or nanoseconds, which
+// pass the checksum one time in ten, from being masked.
+func Card(match []byte) bool {
+	digits := make([]byte, 0, 19)
+	for _, c := range match {
+		if c >= '0' && c <= '9' {
+			digits = append(digits, c)
+		}
+	}
+	for _, is := range issuers {
+		if len(digits) < is.min || len(digits) > is.max {
+			continue
+		}
+		prefix := 0
+		for _, c := range digits[:is.width] {
+			prefix = prefix*10 + int(c-'0')
+		}
+		if prefix >= is.lo && prefix <= is.hi {
+			return Luhn(digits)
+		}
+	}
+	return false
+}
+
+// The issuer prefix ranges of card numbers, over their first width digits,
+// and the lengths each issues.
+var issuers = []struct {
+	width, lo, hi int
+	min, max      int
+}{
+	{1, 4, 4, 13, 19},       // Visa
+	{2, 51, 55, 16, 16},     // Mastercard
+	{4, 2221, 2720, 16, 16}, // Mastercard
+	{2, 34, 34, 15, 15},     // American Express
+	{2, 37, 37, 15, 15},     // American Express
+	{3, 300, 305, 14, 19},   // Diners Club
+	{2, 36, 36, 14, 19},     // Diners Club
+	{2, 38, 39, 16, 19},     // Diners Club
+	{4, 3528, 3589, 16, 19}, // JCB
+	{4, 6011, 6011, 16, 19}, // Discover
+	{3, 644, 649, 16, 19},   // Discover
+	{2, 65, 65, 16, 19},     // Discover
+	{2, 62, 62, 16, 19},     // UnionPay
+	{2, 50, 50, 13, 19},     // Maestro
+	{2, 56, 69, 13, 19},     // Maestro
+}
+
+// A Redactor masks the secrets its rules find.
+type Redactor struct {
+	rules []rule
+}
+
+type rule struct {
+	Rule
+	mask []byte
+}
+
+// New returns a Redactor applying rules in order.
+func New(rules ...Rule) *Redactor {
+	r := &Redactor{rules: make([]rule, len(rules))}
+	for i, rl := range rules {
+		r.rules[i] = rule{rl, []byte("[REDACTED:" + rl.Name + "]")}
+	}
+	return r
+}
+
+// Filter masks the secrets in p, for use in rollinglog.Config.Filters. It
+// returns p itself when there are none, and never drops a record.
+func (r *Redactor) Filter(p []byte) ([]byte, bool) {
+	for i := range r.rules {
+		p = r.rules[i].redact(p)
+	}
+	return p, true
+}
+
+// Redact returns s with its secrets masked.
+func (r *Redactor) Redact(s string) string {
+	p, _ := r.Filter([]byte(s))
+	return string(p)
+}
+
+// Mask the matches of the rule in p, into a new slice if there are any.
+func (rl *rule) redact(p []byte) []byte {
+	locs := rl.Pattern.FindAllSubmatchIndex(p, -1)
+	if locs == nil {
+		return p
+	}
+	var out []byte
+	last := 0
+	for _, loc := range locs {
+		start, end := loc[0], loc[1]
+		if len(loc) > 2 {
+			if start, end = loc[2], loc[3]; start < 0 {
+				continue
+			}
+		}
+		if rl.Valid != nil && !rl.Valid(p[start:end]) {
+			continue
+		}
+		out = append(out, p[last:start]...)
+		out = append(out, rl.mask...)
+		last = end
+	}
+	if out == nil {
+		return p
+	}
+	return append(out, p[last:]...)
+}
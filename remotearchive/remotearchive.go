@@ -0,0 +1,213 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package remotearchive pushes rolled log files to a central log host, for
+// environments without object storage. SFTP drives the system sftp client,
+// so it uses the host's ssh configuration, keys and known_hosts; Dir copies
+// into a directory, such as an sshfs or NFS mount.
+//
+//	w, err := rollinglog.New(rollinglog.Config{
+//		FilepathPattern: "logs/{2006-01-02}/app.log",
+//		Archiver: &remotearchive.SFTP{
+//			Host:   "loghost.internal",
+//			User:   "logs",
+//			Dir:    "/srv/logs/" + hostname,
+//			Remove: true,
+//		},
+//	})
+package remotearchive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Remote path for a local file: the slash-separated local path with any
+// leading / removed, beneath dir.
+func remotePath(dir, local string, key func(string) string) string {
+	k := strings.TrimPrefix(filepath.ToSlash(local), "/")
+	if key != nil {
+		k = key(local)
+	}
+	return path.Join(dir, k)
+}
+
+// SFTP uploads each file with a batch run of sftp(1). The file is written
+// under a temporary name and renamed into place, so the log host never sees
+// a partial file.
+type SFTP struct {
+	Host string
+	Port int    // 22 if zero
+	User string // defaults to the ssh configuration
+
+	// Dir is the remote directory files are placed under. Key, if set,
+	// replaces the default mapping from local path to the path below Dir.
+	Dir string
+	Key func(path string) string
+
+	IdentityFile string
+	Options      []string // extra -o options, such as "StrictHostKeyChecking=yes"
+	Command      string   // sftp binary, "sftp" if empty
+
+	// Remove deletes the local file once it has been uploaded.
+	Remove bool
+}
+
+// Archive uploads file.
+func (s *SFTP) Archive(ctx context.Context, file string) error {
+	remote := remotePath(s.Dir, file, s.Key)
+
+	// sftp has no mkdir -p; the leading - ignores errors for directories
+	// that already exist
+	var batch bytes.Buffer
+	var dirs []string
+	for d := path.Dir(remote); d != "." && d != "/"; d = path.Dir(d) {
+		dirs = append(dirs, d)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fmt.Fprintf(&batch, "-mkdir %s\n", quote(dirs[i]))
+	}
+	tmp := remote + ".part"
+	fmt.Fprintf(&batch, "-rm %s\n", quote(tmp))
+	fmt.Fprintf(&batch, "put %s %s\n", quote(file), quote(tmp))
+	fmt.Fprintf(&batch, "rename %s %s\n", quote(tmp), quote(remote))
+
+	args := []string{"-q", "-b", "-"}
+	if s.Port != 0 {
+		args = append(args, "-P", strconv.Itoa(s.Port))
+	}
+	if s.IdentityFile != "" {
+		args = append(args, "-i", s.IdentityFile)
+	}
+	for _, o := range s.Options {
+		args = append(args, "-o", o)
+	}
+	host := s.Host
+	if s.User != "" {
+		host = s.User + "@" + host
+	}
+	args = append(args, host)
+
+	command := s.Command
+	if command == "" {
+		command = "sftp"
+	}
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = &batch
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("remotearchive: sftp %s: %v: %s", host, err, strings.TrimSpace(string(out)))
+	}
+
+	if s.Remove {
+		return os.Remove(file)
+	}
+	return nil
+}
+
+// Quote an argument for an sftp batch file.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Dir copies each file beneath a local directory, typically a remote
+// filesystem mounted with sshfs or NFS. As with SFTP, files appear under
+// their final name only once complete.
+type Dir struct {
+	Dir     string
+	Key     func(path string) string
+	DirMode os.FileMode // 0755 if zero
+
+	// Remove deletes the local file once it has been copied.
+	Remove bool
+}
+
+// Archive copies file.
+func (d *Dir) Archive(ctx context.Context, file string) error {
+	dst := filepath.FromSlash(remotePath(filepath.ToSlash(d.Dir), file, d.Key))
+	dirMode := d.DirMode
+	if dirMode == 0 {
+		dirMode = 0755
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), dirMode); err != nil {
+		return err
+	}
+
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".part"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, readerWithContext{ctx, src})
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if d.Remove {
+		src.Close()
+		return os.Remove(file)
+	}
+	return nil
+}
+
+// A reader that stops once its context is cancelled, so a copy to a hung
+// mount can be abandoned when the writer is closed.
+type readerWithContext struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r readerWithContext) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
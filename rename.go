@@ -0,0 +1,80 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// Move the active file of RolloverRenamed, which was opened as the file
+// described by opened, to the first free name of the period of stamp, and
+// return that name. Nothing is done when the file has been replaced or
+// removed behind the writer's back; a nil opened skips the check.
+func (rf *Writer) renameActive(active string, stamp time.Time, opened os.FileInfo) (string, error) {
+	fi, err := rf.fs.Stat(active)
+	if err != nil || opened != nil && rf.fs == OSFS && !os.SameFile(fi, opened) {
+		return "", nil
+	}
+	var p string
+	for seq := 0; ; seq++ {
+		if p, err = rf.layout.name(stamp, seq); err != nil {
+			return "", err
+		}
+		if _, err := rf.fs.Stat(p); err != nil {
+			break
+		}
+	}
+	if err := rf.mkdirAll(path.Dir(p)); err != nil {
+		return "", err
+	}
+	if rf.fs == OSFS {
+		err = renameLog(active, p)
+	} else {
+		err = rf.fs.Rename(active, p)
+	}
+	if err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// Rename an active file of RolloverRenamed left over from a period before
+// the one of stamp, so writing resumes in a file of its own.
+func (rf *Writer) renameStale(active string, stamp time.Time) {
+	fi, err := rf.fs.Stat(active)
+	if err != nil || fi.Size() == 0 {
+		return
+	}
+	then := rf.layout.stamp(fi.ModTime())
+	old, err := rf.layout.name(then, 0)
+	if cur, _ := rf.layout.name(stamp, 0); err != nil || old == cur {
+		return
+	}
+	if _, err := rf.renameActive(active, then, nil); err != nil {
+		rf.logf("renaming %s from an earlier period: %w", active, err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Replay writes the records between from and to, as Export finds them, to
+// w at the pace they were originally written, for load testing consumers
+// with realistic traffic. speed scales the pace: 2 replays twice as fast,
+// and 0 as fast as w takes them. Record times come from config.Timestamp,
+// or with PrefixTimestamps from the prefix; lines without a time follow
+// the record before them at once. Each line is one Write. Waiting uses
+// config.Clock if set.
+func Replay(ctx context.Context, config Config, from, to time.Time, w io.Writer, speed float64) error {
+	if config.Timestamp == nil && config.PrefixTimestamps {
+		config.Timestamp = prefixStamp(config.PrefixFormat)
+	}
+	if config.Timestamp == nil {
+		return errors.New("rollinglog: Replay needs Timestamp or PrefixTimestamps")
+	}
+	clock := config.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(Export(ctx, config, from, to, pw, ExportStream))
+	}()
+
+	br := bufio.NewReader(pr)
+	var first, start time.Time
+	started := false
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			t, ok := config.Timestamp(line)
+			switch {
+			case !ok || speed <= 0:
+			case !started:
+				first, start, started = t, clock.Now(), true
+			default:
+				at := start.Add(time.Duration(float64(t.Sub(first)) / speed))
+				if err := sleepCtx(ctx, clock, at); err != nil {
+					return err
+				}
+			}
+			if _, werr := w.Write(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Wait on clock until deadline, or until ctx is done.
+func sleepCtx(ctx context.Context, clock Clock, deadline time.Time) error {
+	d := deadline.Sub(clock.Now())
+	if d <= 0 {
+		return nil
+	}
+	t := clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A Config.Timestamp reading the time PrefixTimestamps put at the start of
+// each line in format, or the default format if it is empty.
+func prefixStamp(format string) func(p []byte) (time.Time, bool) {
+	if format == "" {
+		format = defaultPrefixFormat
+	}
+	spaces := strings.Count(format, " ")
+	return func(p []byte) (time.Time, bool) {
+		end := 0
+		for i := 0; i <= spaces; i++ {
+			j := bytes.IndexByte(p[end:], ' ')
+			if j < 0 {
+				return time.Time{}, false
+			}
+			end += j + 1
+		}
+		t, err := time.Parse(format, string(p[:end-1]))
+		return t, err == nil
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Replicated writes each record to several rolling logs, typically on
+// different mount points, and reports it written once Quorum of them have
+// taken it, so that a machine with a flaky local disk keeps at least one
+// good copy. A replica counts as having taken a record when its Write
+// succeeds, so one that holds or diverts records, with Async,
+// OutageBuffer or Fallback, counts those too. Replicas are written in
+// turn, so one on a disk that stalls holds up the others unless it is
+// Async. Safe for concurrent use.
+type Replicated struct {
+	writers []*Writer
+	quorum  int
+}
+
+// NewReplicated creates a Writer from each config and a Replicated that
+// needs quorum of them to take each record. Configs that would write the
+// same files are refused.
+func NewReplicated(quorum int, configs ...Config) (*Replicated, error) {
+	if quorum < 1 || quorum > len(configs) {
+		return nil, fmt.Errorf("rollinglog: quorum %d out of range for %d replicas", quorum, len(configs))
+	}
+	if err := VerifyUnique(configs...); err != nil {
+		return nil, err
+	}
+	r := &Replicated{quorum: quorum}
+	for i, config := range configs {
+		w, err := New(config)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("rollinglog: replica %d: %w", i, err)
+		}
+		r.writers = append(r.writers, w)
+	}
+	return r, nil
+}
+
+// Writers returns the replicas, in the order of their configs.
+func (r *Replicated) Writers() []*Writer {
+	return r.writers
+}
+
+// Write writes p to every replica. It fails, reporting nothing written,
+// if fewer than the quorum took it; replicas that did keep it.
+func (r *Replicated) Write(p []byte) (int, error) {
+	var errs []error
+	for i, w := range r.writers {
+		if _, err := w.Write(p); err != nil {
+			errs = append(errs, fmt.Errorf("replica %d: %w", i, err))
+		}
+	}
+	if len(r.writers)-len(errs) < r.quorum {
+		return 0, fmt.Errorf("rollinglog: %d of %d replicas took the record, quorum is %d: %w",
+			len(r.writers)-len(errs), len(r.writers), r.quorum, errors.Join(errs...))
+	}
+	return len(p), nil
+}
+
+// Close closes every replica, returning their errors joined.
+func (r *Replicated) Close() error {
+	var errs []error
+	for _, w := range r.writers {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
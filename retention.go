@@ -0,0 +1,196 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Suffixes of the files kept alongside a log file, which go with it.
+func sidecarSuffixes() []string {
+	return append([]string{chainSuffix, indexSuffix, manifestSuffix}, checksumSuffixes()...)
+}
+
+// Reports whether p is a sidecar file rather than a log file.
+func isSidecar(p string) bool {
+	for _, suffix := range sidecarSuffixes() {
+		if strings.HasSuffix(p, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply the retention of l next to active, reporting any failure. Writers
+// of a pooled Scheduler wait for one of its slots first.
+func (rf *Writer) prune(l *layout, active string) {
+	if err := withWorker(&rf.config, func() error { return prune(l, active) }); err != nil {
+		rf.logf("pruning: %w", err)
+	}
+}
+
+// Remove the log file p and its sidecars.
+func removeLog(p string) error {
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	for _, suffix := range sidecarSuffixes() {
+		if err := os.Remove(p + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename the log file src and its sidecars to dst.
+func renameLog(src, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	return renameSidecars(src, dst)
+}
+
+// Move the sidecars of the log file src to those of dst.
+func renameSidecars(src, dst string) error {
+	for _, suffix := range sidecarSuffixes() {
+		if err := os.Rename(src+suffix, dst+suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return relabelChecksum(dst)
+}
+
+// Delete the oldest files matching the pattern until no more than
+// config.MaxFiles remain and together they take no more than
+// config.MaxTotalBytes, and with config.SkipEmpty every empty one. The
+// active file is counted but never deleted. With QuotaCompressInPlace the
+// oldest are compressed to meet config.MaxTotalBytes instead.
+func prune(l *layout, active string) error {
+	doomed, err := pruneCandidates(l, active)
+	if err != nil {
+		return err
+	}
+	for _, p := range doomed {
+		if err := removeLog(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	_, err = compressToQuota(l, active, false)
+	return err
+}
+
+// The files prune would delete, oldest first.
+func pruneCandidates(l *layout, active string) ([]string, error) {
+	config := l.config
+	if config.MaxFiles == 0 && (config.MaxTotalBytes == 0 || config.QuotaPolicy != QuotaPurgeOldest) && !config.SkipEmpty {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(l.fp.glob(l.ph))
+	if err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		path string
+		fi   os.FileInfo
+	}
+	var files []entry
+	var doomed []string
+	var total int64
+	for _, p := range matches {
+		fi, err := os.Stat(p)
+		if err != nil || !fi.Mode().IsRegular() || isSidecar(p) {
+			continue
+		}
+		if config.SkipEmpty && fi.Size() == 0 && p != active {
+			doomed = append(doomed, p)
+			continue
+		}
+		files = append(files, entry{p, fi})
+		total += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if mi, mj := files[i].fi.ModTime(), files[j].fi.ModTime(); !mi.Equal(mj) {
+			return mi.Before(mj)
+		}
+		return files[i].path < files[j].path
+	})
+
+	count := len(files)
+	for _, e := range files {
+		if (config.MaxFiles == 0 || count <= config.MaxFiles) &&
+			(config.MaxTotalBytes == 0 || config.QuotaPolicy != QuotaPurgeOldest || total <= config.MaxTotalBytes) {
+			break
+		}
+		if e.path == active {
+			continue
+		}
+		doomed = append(doomed, e.path)
+		count--
+		total -= e.fi.Size()
+	}
+	return doomed, nil
+}
+
+// Delete the oldest log file other than active to make room, reporting
+// whether one was found. Numbered backups of active are candidates too.
+func purgeOldest(l *layout, active string) (bool, error) {
+	matches, err := filepath.Glob(l.fp.glob(l.ph))
+	if err != nil {
+		return false, err
+	}
+	if l.config.Rollover == RolloverNumbered {
+		backups, err := filepath.Glob(globEscape(active) + ".*")
+		if err != nil {
+			return false, err
+		}
+		for _, p := range backups {
+			if _, err := strconv.Atoi(strings.TrimSuffix(p[len(active)+1:], ".gz")); err == nil {
+				matches = append(matches, p)
+			}
+		}
+	}
+
+	var oldest string
+	var oldestTime time.Time
+	for _, p := range matches {
+		fi, err := os.Stat(p)
+		if p == active || err != nil || !fi.Mode().IsRegular() || isSidecar(p) {
+			continue
+		}
+		if oldest == "" || fi.ModTime().Before(oldestTime) {
+			oldest, oldestTime = p, fi.ModTime()
+		}
+	}
+	if oldest == "" {
+		return false, nil
+	}
+	return true, removeLog(oldest)
+}
@@ -0,0 +1,269 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package rollinglogtest helps test code that depends on when a
+// rollinglog.Writer rotates. A Clock stands in for the system clock, so a
+// test moves time along itself instead of waiting for it, a Recorder
+// notes each rotation as it happens, and a MemFS keeps the files in
+// memory:
+//
+//	clock := rollinglogtest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+//	fsys := rollinglogtest.NewMemFS()
+//	config := rollinglog.Config{FilepathPattern: "logs/{2006-01-02}.log", FS: fsys}
+//	rec := rollinglogtest.Record(&config, clock)
+//	w := rollinglog.NewMust(config)
+//	defer w.Close()
+//	fmt.Fprintln(w, "hello")
+//	rollinglogtest.ExpectRotationAt(t, rec, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+//	rollinglogtest.ExpectFile(t, fsys, "logs/2024-01-01.log", "hello\n")
+package rollinglogtest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+)
+
+// How long, in real time, to wait for a writer to react to the clock.
+const settleTimeout = 5 * time.Second
+
+// A Clock is a rollinglog.Clock that only moves when told to.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*timer      // running
+	started int           // timers so far
+	changed chan struct{} // closed by the next timer started
+}
+
+// A timer started by a Clock.
+type timer struct {
+	clock *Clock
+	at    time.Time
+	ch    chan time.Time
+}
+
+// NewClock returns a Clock reading start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start, changed: make(chan struct{})}
+}
+
+// Now returns the time the clock was last set to.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer that fires once the clock has been moved on
+// by d.
+func (c *Clock) NewTimer(d time.Duration) rollinglog.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &timer{clock: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+	} else {
+		c.timers = append(c.timers, t)
+	}
+	c.started++
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return t
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *timer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, running := range c.timers {
+		if running == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock on by d; see Set.
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the clock to t, which may be in its past to mimic a step of
+// the system clock. The timers that t ends fire, and Set returns once as
+// many new timers have been started, which a writer does when it has
+// finished the rotation work now due, or after a few seconds of real time
+// should that not happen.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	fired := 0
+	running := c.timers[:0]
+	for _, tm := range c.timers {
+		if tm.at.After(t) {
+			running = append(running, tm)
+			continue
+		}
+		tm.ch <- t
+		fired++
+	}
+	clear(c.timers[len(running):])
+	c.timers = running
+	target := c.started + fired
+	c.mu.Unlock()
+
+	c.waitFor(func() bool { return c.started >= target })
+}
+
+// Wait for cond, checked with c.mu held, to hold, giving up after
+// settleTimeout. Reports whether it held.
+func (c *Clock) waitFor(cond func() bool) bool {
+	timeout := time.After(settleTimeout)
+	for {
+		c.mu.Lock()
+		if cond() {
+			c.mu.Unlock()
+			return true
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// A Rotation is one rotation seen by a Recorder.
+type Rotation struct {
+	Path   string // the file rotated away from
+	Reason rollinglog.RotateReason
+	At     time.Time // by the Recorder's Clock
+}
+
+// A Recorder notes the rotations of a writer.
+type Recorder struct {
+	clock *Clock
+
+	mu        sync.Mutex
+	rotations []Rotation
+	changed   chan struct{} // closed by the next rotation
+}
+
+// Record sets config to run on clock and to note its rotations in the
+// returned Recorder, keeping any OnRotate hook already set.
+func Record(config *rollinglog.Config, clock *Clock) *Recorder {
+	r := &Recorder{clock: clock, changed: make(chan struct{})}
+	next := config.OnRotate
+	config.Clock = clock
+	config.OnRotate = func(path string, reason rollinglog.RotateReason) {
+		r.mu.Lock()
+		r.rotations = append(r.rotations, Rotation{path, reason, clock.Now()})
+		close(r.changed)
+		r.changed = make(chan struct{})
+		r.mu.Unlock()
+		if next != nil {
+			next(path, reason)
+		}
+	}
+	return r
+}
+
+// Rotations returns the rotations noted so far, oldest first.
+func (r *Recorder) Rotations() []Rotation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Rotation(nil), r.rotations...)
+}
+
+func (r *Recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.rotations)
+}
+
+// Wait for at least n rotations, giving up after settleTimeout. Reports
+// whether there were enough.
+func (r *Recorder) wait(n int) bool {
+	timeout := time.After(settleTimeout)
+	for {
+		r.mu.Lock()
+		if len(r.rotations) >= n {
+			r.mu.Unlock()
+			return true
+		}
+		changed := r.changed
+		r.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// Wait for the writer to be asleep on the clock, so that moving the clock
+// wakes it.
+func (r *Recorder) settle() {
+	c := r.clock
+	c.waitFor(func() bool { return len(c.timers) > 0 })
+}
+
+// ExpectNoRotationUntil moves the Recorder's Clock on to until, failing t
+// if the writer rotates on the way.
+func ExpectNoRotationUntil(t testing.TB, r *Recorder, until time.Time) {
+	t.Helper()
+	r.settle()
+	n := r.count()
+	r.clock.Set(until)
+	if got := r.Rotations(); len(got) > n {
+		t.Errorf("rollinglogtest: rotated %s at %v (%v), expected no rotation until %v",
+			got[n].Path, got[n].At, got[n].Reason, until)
+	}
+}
+
+// ExpectRotationAt moves the Recorder's Clock on to at, failing t unless
+// the writer rotates then and not a moment before. It returns the
+// rotation.
+func ExpectRotationAt(t testing.TB, r *Recorder, at time.Time) Rotation {
+	t.Helper()
+	if now := r.clock.Now(); !at.After(now) {
+		t.Fatalf("rollinglogtest: expected a rotation at %v, which is not after %v", at, now)
+	}
+	ExpectNoRotationUntil(t, r, at.Add(-time.Nanosecond))
+	n := r.count()
+	r.clock.Set(at)
+	if !r.wait(n + 1) {
+		t.Fatalf("rollinglogtest: no rotation at %v", at)
+	}
+	return r.Rotations()[n]
+}
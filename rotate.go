@@ -0,0 +1,1049 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// Open the file for the period named by stamp. Unless fresh asks for a
+// new file, the Dedupe sequence file currently in use for the period is
+// opened again.
+func (rf *Writer) openFile(stamp time.Time, fresh bool) (*logFile, error) {
+	config := &rf.config
+	if rf.handoff != nil && !rf.handoff.owned.Load() {
+		return nil, errHandoffPending
+	}
+	base, err := rf.layout.name(stamp, 0)
+	if err != nil {
+		return nil, err
+	}
+	if config.Rollover == RolloverRenamed {
+		base = rf.layout.active
+	}
+	p := base
+	if err := rf.mkdirAll(path.Dir(p)); err != nil {
+		return nil, err
+	}
+	if config.Rollover == RolloverRenamed && !rf.started {
+		rf.renameStale(p, stamp)
+	}
+
+	flags := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	exclusive := config.Dedupe || config.layered() || fresh && config.Rollover == RolloverDated
+	if !exclusive {
+		rf.mu.Lock()
+		if rf.f != nil && rf.f.base == base {
+			p = rf.f.Name()
+		}
+		rf.mu.Unlock()
+	}
+	if !rf.started {
+		switch config.OpenMode {
+		case OpenTruncate:
+			if !exclusive {
+				flags |= os.O_TRUNC
+			}
+		case OpenNewSequence:
+			exclusive = true
+		}
+	}
+	if exclusive {
+		flags |= os.O_EXCL
+	}
+	f, err := rf.openLog(p, flags)
+	for seq := 1; exclusive && os.IsExist(err); seq++ {
+		if p, err = rf.layout.name(stamp, seq); err != nil {
+			return nil, err
+		}
+		f, err = rf.openLog(p, flags)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock *os.File
+	if config.Disambiguate {
+		if f, p, lock, err = rf.claim(f, p, flags); err != nil {
+			return nil, err
+		}
+	}
+	if flags&os.O_TRUNC != 0 {
+		// the old index describes what was just thrown away
+		if err := rf.fs.Remove(p + indexSuffix); err != nil && !os.IsNotExist(err) {
+			rf.logf("%w", err)
+		}
+	}
+	lf, err := rf.setupFile(f, p, base)
+	if err != nil {
+		if lock != nil {
+			lock.Close()
+		}
+		return nil, err
+	}
+	lf.stamp = stamp
+	lf.claim = lock
+	rf.started = true
+	return lf, nil
+}
+
+// Finish opening f at p: redirect captured output to it, stamp it, run
+// Config.OnFileOpen and wrap it for writing. f is closed on error.
+func (rf *Writer) setupFile(f File, p, base string) (*logFile, error) {
+	config := &rf.config
+	// nil for a Config.FS file, which none of the below applies to
+	osf, _ := f.(*os.File)
+	if osf != nil {
+		// dup2 replaces the target atomically; closing it first would let
+		// another thread be handed the descriptor in between
+		if config.Flags&FlagCaptureStdout != 0 {
+			if err := redirectFD(int(osf.Fd()), int(os.Stdout.Fd())); err != nil {
+				rf.logf("capturing stdout: %w", err)
+			}
+		}
+		if config.Flags&FlagCaptureStderr != 0 {
+			if err := redirectFD(int(osf.Fd()), int(os.Stderr.Fd())); err != nil {
+				rf.logf("capturing stderr: %w", err)
+			}
+		}
+		rf.setCrashOutput(osf)
+		if config.StampVersion {
+			stampFile(osf)
+		}
+		if config.AppendOnly {
+			rf.setAppendOnly(osf)
+		}
+		if config.OnFileOpen != nil {
+			config.OnFileOpen(osf, p)
+		}
+	}
+	lf, err := rf.newLogFile(f, p, base)
+	if err != nil {
+		return nil, err
+	}
+	if config.PreallocateBytes > 0 && osf != nil {
+		ok, err := preallocate(osf, config.PreallocateBytes)
+		if err != nil {
+			rf.logf("preallocating %s: %w", p, err)
+		}
+		lf.prealloc = ok && !config.Lock
+	}
+	if config.Continuity {
+		rf.startSum(lf)
+	}
+	rf.writeHeader(lf)
+	lf.start = lf.size
+	rf.journal.event(journalInfo, p, "opened %s", p)
+	return lf, nil
+}
+
+// Wrap f, newly opened at p, for writing, layering Config.Encrypter and
+// StreamCompress over it and starting its HMAC chain. f is closed on
+// error.
+func (rf *Writer) newLogFile(f File, p, base string) (*logFile, error) {
+	config := &rf.config
+	lf := &logFile{File: f, base: base, opened: rf.clock.Now(), onClose: config.OnFileClose, clock: rf.clock}
+	if fi, err := f.Stat(); err == nil {
+		lf.size = fi.Size()
+	}
+	if config.Manifest {
+		lf.onClose = func(path string, stats FileStats) {
+			rf.finished.note(path, stats)
+			if config.OnFileClose != nil {
+				config.OnFileClose(path, stats)
+			}
+		}
+	}
+	if config.HMACKey != nil {
+		chain, err := openChain(config.HMACKey, lf.osFile(), p, config.Mode)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		lf.chain = chain
+	}
+	if config.IndexEvery > 0 {
+		index, err := newFileIndex(lf.osFile(), config)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		lf.index = index
+	}
+	if config.DirectIO {
+		d, err := openDirect(p, lf.size)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		lf.w, lf.layers = d, []io.WriteCloser{d}
+	}
+	if config.Encrypter != nil {
+		enc, err := config.Encrypter.Encrypt(f, p)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		lf.w, lf.layers = enc, []io.WriteCloser{enc}
+	}
+	if config.StreamCompress {
+		var under io.Writer = f
+		if lf.w != nil {
+			under = lf.w
+		}
+		gz := gzip.NewWriter(under)
+		lf.w, lf.layers = gz, append([]io.WriteCloser{gz}, lf.layers...)
+	}
+	if config.WriteTimeout > 0 {
+		lf.timed = newTimedFile(f, config.WriteTimeout)
+	}
+	return lf, nil
+}
+
+// Start f with Config.Header if it is a new, empty file.
+func (rf *Writer) writeHeader(f *logFile) {
+	if rf.config.Header == nil {
+		return
+	}
+	if fi, err := f.Stat(); err != nil || fi.Size() != 0 {
+		return
+	}
+	rf.writeFrame(f, "header", rf.config.Header)
+}
+
+// End f with Config.Footer before it is closed in favour of next.
+func (rf *Writer) writeFooter(f *logFile, next string) {
+	if rf.config.Footer == nil || rf.config.CopyTruncate {
+		return
+	}
+	rf.writeFrame(f, "footer", func(w io.Writer) error {
+		return rf.config.Footer(w, next)
+	})
+}
+
+// Append the output of fn to f in a single write.
+func (rf *Writer) writeFrame(f *logFile, what string, fn func(w io.Writer) error) {
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		rf.logf("%s for %s: %w", what, f.Name(), err)
+		return
+	}
+	n, err := f.Write(buf.Bytes())
+	f.bytes += int64(n)
+	f.size += int64(n)
+	if err != nil {
+		rf.logf("%s for %s: %w", what, f.Name(), err)
+	}
+}
+
+// The state of a writer's rotation, owned by whichever goroutine drives it:
+// the writer's own, or its Manager's.
+type rotation struct {
+	started   bool
+	current   *logFile    // last file installed, nil until one opens
+	opened    os.FileInfo // current as it was opened
+	openedAt  time.Time   // when current was opened, or New was called
+	next      time.Time   // scheduled rotation of current
+	precreate time.Time   // when to prepare the next file; zero once done
+	policy    time.Time   // Config.RotationPolicy deadline; zero if none
+	watch     time.Time   // next Config.WatchInterval check
+	pressure  time.Time   // next Config.OnPressure sample
+	flush     time.Time   // next Config.FlushInterval flush
+	summary   time.Time   // earliest next Config.DropSummary line
+	requested RotateReason
+	probed    bool // the writer asked for a fresh file
+
+	// A replacement for current is due at reopen, for reason; zero if
+	// none. A rotation under way keeps its state across failed attempts.
+	reopen    time.Time
+	reason    RotateReason
+	at        time.Time // with Manager.Group, the time to name the replacement for
+	rolled    string
+	rotateErr error
+	finish    func(error) // ends the rotation's trace
+	attempts  int         // failed attempts to open a file since the last success
+
+	archives []archiveRetry // failed archives awaiting another attempt
+}
+
+// The longest the rotation goroutine sleeps before looking at the clock
+// again. Timers run on the monotonic clock, so without this a deadline
+// would be missed by however far the wall clock was stepped meanwhile.
+const maxSleep = time.Minute
+
+// How long to sleep at now before the deadline returned by step.
+func sleepUntil(now, deadline time.Time) time.Duration {
+	return min(deadline.Sub(now), maxSleep)
+}
+
+// The background goroutine of a writer without a Manager. It sleeps until
+// something is due, then lets step deal with it.
+func (rf *Writer) run() {
+	rs := &rf.rot
+	for {
+		deadline, ok := rf.advance(rf.clock.Now())
+		if !ok {
+			return
+		}
+		timer := rf.clock.NewTimer(sleepUntil(rf.clock.Now(), deadline))
+		select {
+		case <-rf.chClosed: // step finds out
+		case <-timer.C():
+		case reason := <-rf.chRotate:
+			rs.requested = reason
+		case <-rf.chProbe:
+			rs.probed = true
+		case <-rf.wake:
+		}
+		timer.Stop()
+	}
+}
+
+// Run step, keeping Update out meanwhile.
+func (rf *Writer) advance(now time.Time) (time.Time, bool) {
+	rf.stepMu.Lock()
+	defer rf.stepMu.Unlock()
+	return rf.step(now)
+}
+
+// With Config.InlineRotation, run step if it is due. Called by writes
+// without rf.mu held.
+func (rf *Writer) tick() {
+	if rf.clock.Now().UnixNano() < rf.due.Load() {
+		return
+	}
+	rf.stepMu.Lock()
+	defer rf.stepMu.Unlock()
+	now := rf.clock.Now()
+	if now.UnixNano() < rf.due.Load() {
+		return // another write got there first
+	}
+	deadline, ok := rf.step(now)
+	if !ok {
+		rf.due.Store(math.MaxInt64)
+		return
+	}
+	rf.due.Store(deadline.UnixNano())
+}
+
+// Do whatever rotation work is due at now: a scheduled, requested or policy
+// rotation, preparing the next file, checking the active path, or opening
+// a replacement. Deadlines are wall clock times, compared afresh at every
+// call, so DST changes and clock steps move them with the clock. Returns
+// when to call again, and false once the writer has been closed or can no
+// longer open files.
+func (rf *Writer) step(now time.Time) (time.Time, bool) {
+	rs := &rf.rot
+	now = now.Round(0) // compare by the wall clock
+	select {
+	case <-rf.chClosed:
+		rf.dropArchives()
+		return time.Time{}, false
+	default:
+	}
+	if !rs.started {
+		rs.started = true
+		rf.startRotation()
+	}
+	select {
+	case reason := <-rf.chRotate:
+		rs.requested = reason
+	default:
+	}
+	rf.joinRotation()
+	select {
+	case <-rf.chProbe:
+		rs.probed = true
+	default:
+	}
+	rf.adopt()
+	if rf.handoff != nil && rf.handoff.arrived.Swap(false) {
+		rs.reopen = now
+	}
+
+	config := &rf.config
+	if config.RotationPolicy != nil && rs.current != nil {
+		// the deadline of the latest write, see checkRotation
+		var at time.Time
+		if ns := rf.policyAt.Load(); ns != 0 {
+			at = time.Unix(0, ns)
+		}
+		if !at.Equal(rs.policy) {
+			rs.policy = at
+			rf.publishNext()
+		}
+	}
+	if config.OnPressure != nil && !now.Before(rs.pressure) {
+		rs.pressure = rf.samplePressure(now)
+	}
+	if config.FlushInterval > 0 && !now.Before(rs.flush) {
+		rs.flush = now.Add(config.FlushInterval)
+		rf.flushActive()
+	}
+	var summary time.Time
+	if q := rf.async; q != nil && config.DropSummary > 0 {
+		if due := q.summaryDue(); !due.IsZero() {
+			if !now.Before(due) && !now.Before(rs.summary) {
+				rf.mu.Lock()
+				q.summarize(false)
+				rf.mu.Unlock()
+				// not again before then, should the line fail to write
+				rs.summary = now.Add(config.DropSummary)
+			}
+			if summary = q.summaryDue(); !summary.IsZero() && summary.Before(rs.summary) {
+				summary = rs.summary
+			}
+		}
+	}
+	if rs.probed {
+		rs.probed = false
+		if rs.reopen.IsZero() {
+			// retry after repeated failures, giving the cause time to clear
+			rs.reopen = now.Add(rf.retryDelay(max(rs.attempts, 1)))
+		}
+	}
+	if rs.current != nil && rs.reopen.IsZero() {
+		reason := rs.requested
+		rs.requested = 0
+		switch {
+		case reason != 0:
+		case !now.Before(rs.next):
+			reason = RotateScheduled
+		case !rs.policy.IsZero() && !now.Before(rs.policy):
+			reason = RotatePolicy
+		}
+		if reason != 0 {
+			if reason != RotateGroup {
+				rf.leadRotation(reason, now)
+			}
+			rf.beginRotation(reason)
+			rs.reopen = now
+		} else {
+			if !rs.precreate.IsZero() && !now.Before(rs.precreate) {
+				rs.precreate = time.Time{}
+				rf.precreate(rs.next)
+			}
+			if !rs.watch.IsZero() && !now.Before(rs.watch) {
+				rs.watch = now.Add(config.WatchInterval)
+				if fi, err := os.Stat(rs.current.Name()); err != nil || rs.opened == nil || !os.SameFile(fi, rs.opened) {
+					// removed or replaced behind our back
+					rs.reopen = now
+				}
+			}
+		}
+	}
+	if !rs.reopen.IsZero() && !now.Before(rs.reopen) && !rf.replace() {
+		return time.Time{}, false
+	}
+	if len(rs.archives) > 0 {
+		rf.retryArchives(now)
+	}
+
+	deadline := rs.reopen
+	if deadline.IsZero() {
+		deadline = rs.next
+		if rs.requested != 0 {
+			deadline = now
+		}
+		for _, t := range []time.Time{rs.precreate, rs.policy, rs.watch} {
+			if !t.IsZero() && t.Before(deadline) {
+				deadline = t
+			}
+		}
+	}
+	for _, t := range []time.Time{rs.pressure, rs.flush, summary} {
+		if !t.IsZero() && t.Before(deadline) {
+			deadline = t
+		}
+	}
+	for _, a := range rs.archives {
+		if a.at.Before(deadline) {
+			deadline = a.at
+		}
+	}
+	return deadline, true
+}
+
+// Take over the file installed by New, or schedule another attempt if it
+// could not be opened.
+func (rf *Writer) startRotation() {
+	rs := &rf.rot
+	config := &rf.config
+	if current := rs.current; current != nil {
+		rs.opened, _ = rf.fs.Stat(current.Name())
+		if config.Rollover == RolloverNumbered {
+			err := withRotationLock(current.Name(), config, func() error {
+				return recoverNumbered(current.Name(), config)
+			})
+			if err != nil {
+				rf.logf("recovering backups of %s: %w", current.Name(), err)
+			}
+		}
+		rf.prune(rf.layout, current.Name())
+		if config.RecoverOnStart {
+			rf.recoverFiles(current.Name())
+		}
+		rf.schedule(rs.openedAt)
+	} else {
+		rs.attempts = 1
+		rs.reopen = rs.openedAt.Add(rf.retryDelay(1))
+	}
+	if pl, ok := config.Archiver.(*Pipeline); ok {
+		if err := pl.Resume(rf.ctx); err != nil {
+			rf.logf("resuming archive pipeline: %w", err)
+		}
+	}
+}
+
+// Set the deadlines of the file just opened at now.
+func (rf *Writer) schedule(now time.Time) {
+	rs := &rf.rot
+	config := &rf.config
+	if rs.current != nil {
+		rs.next = rf.layout.nextRotation(now, rs.current.base)
+	} else {
+		rs.next = rf.layout.sched.next(now)
+	}
+	if config.RotateJitter > 0 {
+		rs.next = rs.next.Add(rand.N(config.RotateJitter))
+	}
+	rs.precreate, rs.policy, rs.watch = time.Time{}, time.Time{}, time.Time{}
+	if config.PrecreateLead > 0 {
+		rs.precreate = rs.next.Add(-config.PrecreateLead)
+	}
+	if config.RotationPolicy != nil {
+		rf.mu.Lock()
+		at, rotateNow := rf.askPolicy(now)
+		rf.mu.Unlock()
+		if rotateNow {
+			rs.requested = RotatePolicy
+		}
+		rs.policy = at
+		rf.policyAt.Store(unixNano(at))
+	}
+	if config.WatchInterval > 0 {
+		rs.watch = now.Add(config.WatchInterval)
+	}
+	rf.publishNext()
+}
+
+// Update NextRotation from the deadlines of the rotation state.
+func (rf *Writer) publishNext() {
+	rs := &rf.rot
+	at := rs.next
+	if !rs.policy.IsZero() && rs.policy.Before(at) {
+		at = rs.policy
+	}
+	rf.nextAt.Store(at.UnixNano())
+}
+
+// Finish the current file for reason, before its replacement is opened.
+func (rf *Writer) beginRotation(reason RotateReason) {
+	rs := &rf.rot
+	config := &rf.config
+	rs.reason = reason
+	rs.rolled = rs.current.Name()
+	_, rs.finish = rf.trace(rf.ctx, "rotate", rs.rolled)
+	if config.Rollover == RolloverNumbered {
+		shifted, err := rotateNumbered(rs.rolled, rs.opened, config, rf.compress)
+		if err != nil {
+			rf.logf("rotating %s: %w", rs.rolled, err)
+			rs.rotateErr = err
+		}
+		if rs.rolled = ""; shifted {
+			rs.rolled, _ = numberedPath(rs.current.Name(), 1)
+		}
+	}
+	if config.Rollover == RolloverRenamed {
+		var err error
+		if rs.rolled, err = rf.renameActive(rs.rolled, rs.current.stamp, rs.opened); err != nil {
+			rf.logf("rotating %s: %w", rs.current.Name(), err)
+			rs.rotateErr = err
+		}
+	}
+	if config.MinFreeBytes > 0 {
+		rf.ensureFree(rs.current.Name())
+	}
+}
+
+// Open and install the replacement for the current file, and finish the
+// rotation under way, if any. When the file cannot be opened another
+// attempt is scheduled while degraded; false means there will be none, or
+// the writer has been closed.
+func (rf *Writer) replace() bool {
+	rs := &rf.rot
+	config := &rf.config
+	now := rf.clock.Now()
+	stamp, fresh := rf.nextStamp(now)
+	f, err := rf.openFile(stamp, fresh)
+	if err == errHandoffPending {
+		rs.reopen = now.Add(rf.retryDelay(1)) // or when the lock arrives
+		return true
+	}
+	if err != nil {
+		rf.fail(err)
+		if rs.finish != nil {
+			rs.finish(err)
+			rs.finish = nil
+		}
+		rs.attempts++
+		if _, ok := config.Retry.Delay(rs.attempts); !ok && config.Retry.enabled() {
+			rf.logf("giving up opening a file after %d attempts: %w", rs.attempts, err)
+			return false
+		}
+		rs.reopen = now.Add(rf.retryDelay(rs.attempts))
+		return config.DegradeAfter != 0 || config.OutageBuffer > 0
+	}
+	rs.attempts = 0
+	return rf.swap(f, now)
+}
+
+// The stamp of the file replace opens at now, and whether it needs a name
+// of its own.
+func (rf *Writer) nextStamp(now time.Time) (time.Time, bool) {
+	rs := &rf.rot
+	// a rotation within the period needs a name of its own
+	fresh := rs.reason == RotateSize || rs.reason == RotateManual || rs.reason == RotatePolicy
+	stamp := rf.layout.stamp(now)
+	if rs.reason == 0 && rs.current != nil && now.Before(rs.next) {
+		stamp = rs.current.stamp // a reopen still within a RotateJitter delay
+	}
+	if !rs.at.IsZero() {
+		stamp = rf.layout.stamp(rs.at)
+		if rs.reason == RotateGroup && rs.current != nil {
+			// still the same period for this log
+			base, err := rf.layout.name(stamp, 0)
+			fresh = err == nil && base == rs.current.base
+		}
+	}
+	return stamp, fresh
+}
+
+// Install f, opened by replace or Reopen, finishing the rotation under way,
+// if any, and closing the file it replaces. Returns false if the writer has
+// been closed.
+func (rf *Writer) swap(f *logFile, now time.Time) bool {
+	rs := &rf.rot
+	config := &rf.config
+	rotated := rs.reason != 0
+	rolled, reason, finish := rs.rolled, rs.reason, rs.finish
+	rotateErr := rs.rotateErr
+	rs.reopen, rs.reason, rs.rolled, rs.rotateErr, rs.finish = time.Time{}, 0, "", nil, nil
+	rs.at = time.Time{}
+	old := rs.current
+	prev, ok := rf.install(f, rotated)
+	if !ok {
+		f.close()
+		if finish != nil {
+			finish(rotateErr)
+		}
+		return false
+	}
+	if prev != nil && old != nil && rolled == old.Name() {
+		rolled = prev.Name() // old may have been cut by Config.HardMaxBytes
+	}
+	rs.current = f
+	rs.opened, _ = rf.fs.Stat(f.Name())
+	rs.requested = 0 // made of the file just replaced, as install discards
+	if prev != nil {
+		rf.writeFooter(prev, f.Name())
+		if rotated {
+			rf.linkTo(prev, f.Name())
+		}
+		prev.close()
+		if rolled != "" && rolled != prev.Name() && config.Manifest {
+			rf.finished.move(prev.Name(), rolled)
+		}
+		if rotated && prev.Name() != f.Name() && rf.discardEmpty(prev) {
+			rolled = ""
+		}
+	}
+	if finish != nil {
+		finish(rotateErr)
+	}
+	if rotated {
+		rf.noteRotation(rotateErr)
+	}
+	rf.maintain(func() {
+		if rolled != "" {
+			rf.postRotate(rf.ctx, rolled, reason)
+		}
+		rf.prune(rf.layout, f.Name())
+	})
+	rf.schedule(now)
+	return true
+}
+
+// Write out what the active file holds back for Config.FlushInterval, if
+// anything has been written to it since the last time.
+func (rf *Writer) flushActive() {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	lf := rf.f
+	if lf == nil || rf.closed || lf.writes == lf.flushed {
+		return
+	}
+	lf.flushed = lf.writes
+	if err := lf.flush(); err != nil {
+		rf.logf("flushing %s: %w", lf.Name(), err)
+	}
+}
+
+// Create the directory, and with PrecreateFile the file, that will be
+// opened at t, reporting rather than returning any failure.
+func (rf *Writer) precreate(t time.Time) {
+	p, err := rf.layout.pathFor(t)
+	if err == nil {
+		err = rf.mkdirAll(path.Dir(p))
+	}
+	config := &rf.config
+	if err == nil && config.PrecreateFile && !config.Dedupe && !config.layered() {
+		var f File
+		if f, err = rf.openLog(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY); err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		rf.logf("preparing next file: %w", err)
+		rf.journal.event(journalWarning, p, "preparing next file: %v", err)
+	}
+}
+
+// Delete the oldest log files while the filesystem holding active has less
+// than MinFreeBytes available.
+func (rf *Writer) ensureFree(active string) {
+	for {
+		free, err := freeBytes(path.Dir(active))
+		if err != nil {
+			rf.logf("checking free space: %w", err)
+			return
+		}
+		if free >= rf.config.MinFreeBytes {
+			return
+		}
+		purged, err := purgeOldest(rf.layout, active)
+		if err != nil {
+			rf.logf("freeing space: %w", err)
+			return
+		}
+		if !purged {
+			rf.logf("%d bytes free in %s, below MinFreeBytes, and nothing left to delete", free, path.Dir(active))
+			return
+		}
+	}
+}
+
+// Sleep for d, returning false if the writer is closed in the meantime.
+func (rf *Writer) sleep(d time.Duration) bool {
+	t := rf.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-rf.chClosed:
+		return false
+	}
+}
+
+// Make f the active file, returning the file it replaces. Returns false if
+// the writer has been closed.
+func (rf *Writer) install(f *logFile, rotated bool) (*logFile, bool) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rotated && rf.config.RotateOnNewline {
+		for wait := time.Duration(0); rf.midLine && !rf.closed && wait < lineWait; wait += lineWaitStep {
+			rf.pause(lineWaitStep)
+		}
+	}
+	if rf.closed {
+		return nil, false
+	}
+	if f.claim != nil {
+		// the lock of the file being replaced goes only now that f is in place
+		if rf.claimed != nil {
+			rf.claimed.Close()
+		}
+		rf.claimed, f.claim = f.claim, nil
+	}
+	prev := rf.f
+	rf.flushRepeats(prev)
+	if rotated && prev != nil {
+		rf.linkFrom(f, prev)
+	}
+	rf.f = f
+	select {
+	case <-rf.chRotate:
+		// asked of the file just replaced
+	default:
+	}
+	rf.lastErr = nil
+	rf.clearWriteError()
+	rf.untried = true
+	if rotated {
+		rf.stats.Rotations++
+	}
+	if err := rf.replayOutage(); err != nil {
+		rf.noteWriteError(err)
+	}
+	return prev, true
+}
+
+// How long, and in what steps, install waits for an unfinished line with
+// Config.RotateOnNewline.
+const (
+	lineWait     = time.Second
+	lineWaitStep = 10 * time.Millisecond
+)
+
+// Record a failure to open the next file.
+func (rf *Writer) fail(err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if !rf.closed {
+		rf.lastErr = err
+		rf.noteWriteError(err)
+		rf.sendError(fmt.Errorf("rollinglog: opening next file: %w", err))
+		rf.stats.Errors++
+		rf.journal.event(journalErr, "", "opening next file: %v", err)
+		rf.reportFailure(err)
+	}
+}
+
+// Remove the closed file lf if Config.SkipEmpty applies to it and no record
+// reached it, reporting whether it was removed.
+func (rf *Writer) discardEmpty(lf *logFile) bool {
+	config := &rf.config
+	if !config.SkipEmpty || config.Rollover == RolloverNumbered || lf.writes != 0 {
+		return false
+	}
+	// layered files are always new; others may have held data already
+	fi, err := rf.fs.Stat(lf.Name())
+	if err != nil || (!config.layered() && fi.Size() != lf.bytes) {
+		return false
+	}
+	remove := removeLog
+	if rf.fs != OSFS {
+		remove = rf.fs.Remove // no sidecars to remove along with it
+	}
+	if err := remove(lf.Name()); err != nil {
+		rf.logf("removing empty %s: %w", lf.Name(), err)
+		return false
+	}
+	return true
+}
+
+// Run the post-rotation hooks and archiver for a file rolled for reason.
+func (rf *Writer) postRotate(ctx context.Context, rolled string, reason RotateReason) {
+	rf.journal.event(journalInfo, rolled, "rotated %s (%v)", rolled, reason)
+	if rf.config.Manifest {
+		if err := rf.writeManifest(rolled, reason); err != nil {
+			rf.logf("manifest of %s: %w", rolled, err)
+		}
+	} else if rf.config.Checksum {
+		if err := writeChecksum(rolled, rf.config.ChecksumAlgorithm, rf.config.Mode); err != nil {
+			rf.logf("checksum of %s: %w", rolled, err)
+		}
+	}
+	if rf.config.OnRotate != nil {
+		rf.config.OnRotate(rolled, reason)
+	}
+	if rf.config.PostRotate != nil {
+		if err := rf.config.PostRotate(rolled); err != nil {
+			rf.logf("post-rotate %s: %w", rolled, err)
+		}
+	}
+	if cmd := rf.config.PostRotateCmd; len(cmd) > 0 {
+		args := append(cmd[1:len(cmd):len(cmd)], rolled)
+		if out, err := exec.Command(cmd[0], args...).CombinedOutput(); err != nil {
+			rf.logf("post-rotate %s: %w: %s", strings.Join(cmd, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	if rf.config.Archiver != nil {
+		err := rf.archive(ctx, rolled)
+		if _, pipeline := rf.config.Archiver.(*Pipeline); err != nil && rf.config.Retry.enabled() && !pipeline {
+			rf.retryArchive(ctx, rolled, err)
+		} else if err != nil {
+			rf.logf("archiving %s: %w", rolled, err)
+		}
+	}
+}
+
+// An archive of a rotated file that failed and is due another attempt.
+type archiveRetry struct {
+	path     string
+	attempts int
+	err      error // of the last attempt
+	at       time.Time
+}
+
+// Retry archiving rolled, whose first attempt failed with err, as
+// Config.Retry allows. The attempts are left to step, between the other
+// rotation work; once the writer is closed there is no step to make them,
+// so they are made here in turn.
+func (rf *Writer) retryArchive(ctx context.Context, rolled string, err error) {
+	select {
+	case <-rf.chClosed:
+		err = rf.config.Retry.retry(ctx, nil, err, func() error {
+			return rf.archive(ctx, rolled)
+		})
+		if err != nil {
+			rf.logf("archiving %s: %w", rolled, err)
+		}
+		return
+	default:
+	}
+	rf.queueArchive(archiveRetry{path: rolled, attempts: 1, err: err})
+}
+
+// Schedule another attempt at a failed archive, or give up on it once
+// Config.Retry says so.
+func (rf *Writer) queueArchive(a archiveRetry) {
+	rs := &rf.rot
+	d, ok := rf.config.Retry.Delay(a.attempts)
+	if !ok {
+		rf.logf("archiving %s: %w", a.path, a.err)
+	} else {
+		a.at = rf.clock.Now().Add(d)
+		rs.archives = append(rs.archives, a)
+	}
+	rf.archiveQueue.Store(int64(len(rs.archives)))
+}
+
+// Make the archive attempts due at now.
+func (rf *Writer) retryArchives(now time.Time) {
+	rs := &rf.rot
+	var due []archiveRetry
+	kept := rs.archives[:0]
+	for _, a := range rs.archives {
+		if now.Before(a.at) {
+			kept = append(kept, a)
+		} else {
+			due = append(due, a)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+	rs.archives = kept
+	rf.maintain(func() {
+		for _, a := range due {
+			if a.err = rf.archive(rf.ctx, a.path); a.err != nil {
+				a.attempts++
+				rf.queueArchive(a)
+			}
+		}
+	})
+	rf.archiveQueue.Store(int64(len(rs.archives)))
+}
+
+// Give up on the archives still to be retried when the writer closes.
+func (rf *Writer) dropArchives() {
+	rs := &rf.rot
+	for _, a := range rs.archives {
+		rf.logf("archiving %s: %w", a.path, errors.Join(a.err, ErrClosed))
+	}
+	rs.archives = nil
+	rf.archiveQueue.Store(0)
+}
+
+// Run fn, the work that follows a rotation or an archive retry, timing it
+// for Status.
+func (rf *Writer) maintain(fn func()) {
+	start := rf.clock.Now()
+	fn()
+	took := rf.clock.Now().Sub(start)
+	rf.mu.Lock()
+	rf.maintainedAt, rf.maintainTook = start, took
+	rf.mu.Unlock()
+}
+
+// Hand rolled to Config.Archiver once.
+func (rf *Writer) archive(ctx context.Context, rolled string) error {
+	return withWorker(&rf.config, func() error {
+		ctx, finish := rf.trace(ctx, "archive", rolled)
+		err := rf.config.Archiver.Archive(ctx, rolled)
+		finish(err)
+		return err
+	})
+}
+
+// How long to wait after failed attempt n to open a file before the next.
+func (rf *Writer) retryDelay(n int) time.Duration {
+	if d, ok := rf.config.Retry.Delay(n); ok {
+		return d
+	}
+	return rf.config.ProbeInterval
+}
+
+// Reopen closes the active file and opens the same path again, for use
+// after external tools have moved or replaced it, or after a failover of
+// the filesystem holding it. Unlike a rotation, the name does not change
+// and no post-rotation work is done; a rotation still being retried is
+// finished instead. On error the old file stays in use.
+func (rf *Writer) Reopen() error {
+	rf.stepMu.Lock()
+	defer rf.stepMu.Unlock()
+
+	rf.mu.Lock()
+	closed := rf.closed
+	rf.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	rs := &rf.rot
+	now := rf.clock.Now()
+	stamp, fresh := rf.nextStamp(now)
+	if rs.reason == 0 && rs.current != nil {
+		stamp = rs.current.stamp
+	}
+	f, err := rf.openFile(stamp, fresh)
+	if err != nil {
+		return err
+	}
+	rs.attempts = 0
+	if !rf.swap(f, now) {
+		return ErrClosed
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.failures = 0
+	rf.journal.event(journalInfo, f.Name(), "reopened %s", f.Name())
+	return nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+	"github.com/mendsley/rollinglog/rollinglogtest"
+)
+
+// The contents of every file of fsys, sorted.
+func contents(t *testing.T, fsys *rollinglogtest.MemFS) []string {
+	t.Helper()
+	var got []string
+	for _, name := range fsys.Files() {
+		data, err := fsys.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	slices.Sort(got)
+	return got
+}
+
+func TestScheduledRotationMidLine(t *testing.T) {
+	start := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	midnight := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	t.Run("plain", func(t *testing.T) {
+		clock := rollinglogtest.NewClock(start)
+		fsys := rollinglogtest.NewMemFS()
+		config := rollinglog.Config{FilepathPattern: "logs/{2006-01-02}.log", FS: fsys}
+		rec := rollinglogtest.Record(&config, clock)
+		w := rollinglog.NewMust(config)
+		defer w.Close()
+
+		io.WriteString(w, "first ")
+		rollinglogtest.ExpectRotationAt(t, rec, midnight)
+		io.WriteString(w, "half\n")
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-01-01.log", "first ")
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-01-02.log", "half\n")
+	})
+
+	t.Run("RotateOnNewline", func(t *testing.T) {
+		clock := rollinglogtest.NewClock(start)
+		fsys := rollinglogtest.NewMemFS()
+		config := rollinglog.Config{FilepathPattern: "logs/{2006-01-02}.log", FS: fsys, RotateOnNewline: true}
+		rec := rollinglogtest.Record(&config, clock)
+		w := rollinglog.NewMust(config)
+		defer w.Close()
+
+		io.WriteString(w, "first ")
+		rollinglogtest.ExpectNoRotationUntil(t, rec, midnight.Add(-time.Nanosecond))
+		clock.Set(midnight) // returns once the rotation waits for the line
+		if n := len(rec.Rotations()); n != 0 {
+			t.Fatalf("rotated %d times partway through a line", n)
+		}
+		io.WriteString(w, "half\n")
+		clock.Advance(time.Second)
+		io.WriteString(w, "next\n")
+		if n := len(rec.Rotations()); n != 1 {
+			t.Fatalf("got %d rotations, want 1", n)
+		}
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-01-01.log", "first half\n")
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-01-02.log", "next\n")
+	})
+}
+
+func TestSizeRotationMidLine(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		rotateOnNewline bool
+		want            []string
+	}{
+		{"plain", false, []string{"0123456789", "ab\n"}},
+		{"RotateOnNewline", true, []string{"0123456789ab\n", "cd\n"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := rollinglogtest.NewMemFS()
+			rotated := make(chan struct{}, 1)
+			w := rollinglog.NewMust(rollinglog.Config{
+				FilepathPattern: "logs/{2006-01-02}.log",
+				FS:              fsys,
+				MaxSize:         8,
+				RotateOnNewline: tc.rotateOnNewline,
+				OnRotate: func(string, rollinglog.RotateReason) {
+					rotated <- struct{}{}
+				},
+			})
+			defer w.Close()
+
+			io.WriteString(w, "0123456789")
+			if tc.rotateOnNewline {
+				time.Sleep(50 * time.Millisecond) // the rotation waits for the line
+				io.WriteString(w, "ab\n")
+			}
+			select {
+			case <-rotated:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no rotation")
+			}
+			if tc.rotateOnNewline {
+				io.WriteString(w, "cd\n")
+			} else {
+				io.WriteString(w, "ab\n")
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := contents(t, fsys); !slices.Equal(got, tc.want) {
+				t.Errorf("got files %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHardMaxBytesMidLine(t *testing.T) {
+	for _, rotateOnNewline := range []bool{false, true} {
+		fsys := rollinglogtest.NewMemFS()
+		w := rollinglog.NewMust(rollinglog.Config{
+			FilepathPattern: "logs/{2006-01-02}.log",
+			FS:              fsys,
+			HardMaxBytes:    16,
+			RotateOnNewline: rotateOnNewline,
+		})
+		io.WriteString(w, "0123456789")
+		io.WriteString(w, "abcdefghij\n")        // fits a file of its own, so is cut over whole
+		io.WriteString(w, "klmnopqrstuvwxyz!\n") // fits no file, so fills on from here
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"0123456789", "abcdefghij\nklmno", "pqrstuvwxyz!\n"}
+		if got := contents(t, fsys); !slices.Equal(got, want) {
+			t.Errorf("RotateOnNewline %v: got files %q, want %q", rotateOnNewline, got, want)
+		}
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	clock := rollinglogtest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	fsys := rollinglogtest.NewMemFS()
+	config := rollinglog.Config{
+		FilepathPattern: "logs/{2006-01-02}.log.gz",
+		FS:              fsys,
+		StreamCompress:  true,
+		FlushInterval:   time.Second,
+	}
+	rec := rollinglogtest.Record(&config, clock)
+	w := rollinglog.NewMust(config)
+	defer w.Close()
+
+	io.WriteString(w, "quiet\n")
+	rollinglogtest.ExpectNoRotationUntil(t, rec, clock.Now().Add(time.Second))
+	data, err := fsys.ReadFile("logs/2024-01-01.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != io.ErrUnexpectedEOF { // the stream is still open
+		t.Fatalf("reading the active file: %v", err)
+	}
+	if string(got) != "quiet\n" {
+		t.Errorf("got %q written out, want %q", got, "quiet\n")
+	}
+}
+
+// Rotates the file five minutes after its first record.
+type firstRecordPolicy struct{ first time.Time }
+
+func (p *firstRecordPolicy) Next(now time.Time, size int64) (time.Time, bool) {
+	if size == 0 {
+		p.first = time.Time{}
+		return time.Time{}, false
+	}
+	if p.first.IsZero() {
+		p.first = now
+	}
+	return p.first.Add(5 * time.Minute), false
+}
+
+func TestRotationPolicyAfterWrite(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := rollinglogtest.NewClock(start)
+	fsys := rollinglogtest.NewMemFS()
+	config := rollinglog.Config{
+		FilepathPattern: "logs/{2006-01-02}.log",
+		FS:              fsys,
+		Dedupe:          true,
+		RotationPolicy:  &firstRecordPolicy{},
+	}
+	rec := rollinglogtest.Record(&config, clock)
+	w := rollinglog.NewMust(config)
+	defer w.Close()
+
+	// idle files have no deadline
+	rollinglogtest.ExpectNoRotationUntil(t, rec, start.Add(time.Hour))
+	io.WriteString(w, "first\n")
+	io.WriteString(w, "second\n")
+	r := rollinglogtest.ExpectRotationAt(t, rec, start.Add(time.Hour+5*time.Minute))
+	if r.Reason != rollinglog.RotatePolicy {
+		t.Errorf("rotated for %v, want %v", r.Reason, rollinglog.RotatePolicy)
+	}
+	if got := w.NextRotation(); !got.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("NextRotation() = %v after the rotation, want midnight", got)
+	}
+}
+
+func TestArchiveRetry(t *testing.T) {
+	start := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	clock := rollinglogtest.NewClock(start)
+	fsys := rollinglogtest.NewMemFS()
+	var attempts atomic.Int32
+	config := rollinglog.Config{
+		FilepathPattern: "logs/{2006-01-02}.log",
+		FS:              fsys,
+		Retry:           rollinglog.Backoff{Base: time.Minute},
+		Archiver: rollinglog.ArchiverFunc(func(ctx context.Context, path string) error {
+			if attempts.Add(1) == 1 {
+				return errors.New("upload failed")
+			}
+			return nil
+		}),
+	}
+	rec := rollinglogtest.Record(&config, clock)
+	w := rollinglog.NewMust(config)
+	defer w.Close()
+
+	io.WriteString(w, "record\n")
+	midnight := start.Add(time.Hour)
+	rollinglogtest.ExpectRotationAt(t, rec, midnight)
+	if n, s := attempts.Load(), w.Status(); n != 1 || s.MaintenanceQueued != 1 {
+		t.Fatalf("after the rotation: %d attempts, %d queued; want 1 and 1", n, s.MaintenanceQueued)
+	}
+	rollinglogtest.ExpectNoRotationUntil(t, rec, midnight.Add(time.Minute))
+	s := w.Status()
+	if n := attempts.Load(); n != 2 || s.MaintenanceQueued != 0 {
+		t.Errorf("after the retry: %d attempts, %d queued; want 2 and 0", n, s.MaintenanceQueued)
+	}
+	if !s.LastMaintenance.Equal(midnight.Add(time.Minute)) {
+		t.Errorf("LastMaintenance = %v, want the retry at %v", s.LastMaintenance, midnight.Add(time.Minute))
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+// Package s3archive uploads rolled log files to an S3-compatible object
+// store. It signs requests with AWS Signature Version 4 and needs nothing
+// beyond the standard library, so it works equally with Amazon S3, MinIO
+// and Google Cloud Storage's XML API (endpoint https://storage.googleapis.com,
+// region "auto", with HMAC keys).
+//
+//	w, err := rollinglog.New(rollinglog.Config{
+//		FilepathPattern: "logs/{2006-01-02}/app.log",
+//		Archiver: &s3archive.Archiver{
+//			Endpoint:        "https://s3.eu-west-1.amazonaws.com",
+//			Region:          "eu-west-1",
+//			Bucket:          "my-logs",
+//			Prefix:          "app/",
+//			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+//			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+//			Remove:          true,
+//		},
+//	})
+package s3archive
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archiver uploads each file it is given with a single PUT request. Objects
+// are addressed path-style, as Endpoint/Bucket/Key.
+type Archiver struct {
+	Endpoint string // base URL of the service
+	Region   string
+	Bucket   string
+
+	// Prefix is prepended to the object key. By default the key is the
+	// slash-separated local path with any leading / removed.
+	Prefix string
+	// Key, if set, replaces the default mapping from local path to key.
+	// Prefix still applies.
+	Key func(path string) string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // for temporary credentials
+
+	// Remove deletes the local file once it has been uploaded.
+	Remove bool
+
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// Archive uploads the file at path.
+func (a *Archiver) Archive(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the payload hash is part of the signature
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := strings.TrimPrefix(filepath.ToSlash(path), "/")
+	if a.Key != nil {
+		key = a.Key(path)
+	}
+	u := strings.TrimSuffix(a.Endpoint, "/") + "/" + escapePath(a.Bucket+"/"+a.Prefix+key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	a.sign(req, hex.EncodeToString(h.Sum(nil)), time.Now())
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3archive: PUT %s: %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if a.Remove {
+		f.Close()
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// Add Signature Version 4 authentication to req, whose body hashes to
+// payloadHash. Every header already present on req is signed.
+func (a *Archiver) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(req.URL.EscapedPath() + "\n")
+	canonical.WriteString(canonicalQuery(req.URL.Query()) + "\n")
+	for _, k := range names {
+		canonical.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + payloadHash)
+
+	scope := day + "/" + a.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	k := hmacSHA256([]byte("AWS4"+a.SecretAccessKey), day)
+	k = hmacSHA256(k, a.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.AccessKeyID, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Escape p for use as a URL path, leaving the / separators alone.
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = escape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// Percent-encode everything but the RFC 3986 unreserved characters, as
+// Signature Version 4 requires.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,217 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RotatePeriod selects a rotation period coarser than a day, for
+// Config.RotatePeriod.
+type RotatePeriod int
+
+const (
+	// PeriodDefault takes the period from the pattern, daily at most.
+	PeriodDefault RotatePeriod = iota
+	// PeriodWeek rotates at midnight at the start of each week.
+	PeriodWeek
+	// PeriodMonth rotates at midnight on the first of each month.
+	PeriodMonth
+)
+
+func (p RotatePeriod) MarshalText() ([]byte, error) {
+	switch p {
+	case PeriodDefault:
+		return []byte("default"), nil
+	case PeriodWeek:
+		return []byte("week"), nil
+	case PeriodMonth:
+		return []byte("month"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown rotate period %d", int(p))
+}
+
+func (p *RotatePeriod) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "default", "":
+		*p = PeriodDefault
+	case "week":
+		*p = PeriodWeek
+	case "month":
+		*p = PeriodMonth
+	default:
+		return fmt.Errorf("rollinglog: unknown rotate period %q", text)
+	}
+	return nil
+}
+
+// Parse a Config.WeekStart value, the name of a day; "" is Monday.
+func parseWeekStart(s string) (time.Weekday, error) {
+	if s == "" {
+		return time.Monday, nil
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := d.String()
+		if strings.EqualFold(s, name) || strings.EqualFold(s, name[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("rollinglog: invalid WeekStart %q", s)
+}
+
+// A schedule decides where rotation boundaries fall.
+type schedule interface {
+	// next returns the first boundary strictly after t.
+	next(t time.Time) time.Time
+	// prev returns the last boundary at or before t.
+	prev(t time.Time) time.Time
+}
+
+// Rotate once a day at a fixed wall-clock time.
+type dailySchedule struct {
+	hour, min, sec int
+}
+
+// Parse a RotateAt value of the form "15:04" or "15:04:05".
+func parseRotateAt(s string) (dailySchedule, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return dailySchedule{t.Hour(), t.Minute(), t.Second()}, nil
+		}
+	}
+	return dailySchedule{}, fmt.Errorf("rollinglog: invalid RotateAt %q, expected HH:MM or HH:MM:SS", s)
+}
+
+func (s dailySchedule) at(t time.Time, day int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+day, s.hour, s.min, s.sec, 0, t.Location())
+}
+
+func (s dailySchedule) next(t time.Time) time.Time {
+	if b := s.at(t, 0); b.After(t) {
+		return b
+	}
+	return s.at(t, 1)
+}
+
+func (s dailySchedule) prev(t time.Time) time.Time {
+	if b := s.at(t, 0); !b.After(t) {
+		return b
+	}
+	return s.at(t, -1)
+}
+
+// Rotate at midnight on the first day of each week.
+type weekSchedule struct {
+	start time.Weekday
+}
+
+func (s weekSchedule) prev(t time.Time) time.Time {
+	back := (int(t.Weekday()) - int(s.start) + 7) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, t.Location())
+}
+
+func (s weekSchedule) next(t time.Time) time.Time {
+	b := s.prev(t)
+	return time.Date(b.Year(), b.Month(), b.Day()+7, 0, 0, 0, 0, b.Location())
+}
+
+// Rotate at midnight on the first of each month.
+type monthSchedule struct{}
+
+func (monthSchedule) prev(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func (monthSchedule) next(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+}
+
+// Rotate at the boundaries of every one of several schedules.
+type unionSchedule []schedule
+
+func (u unionSchedule) next(t time.Time) time.Time {
+	var b time.Time
+	for i, s := range u {
+		if n := s.next(t); i == 0 || n.Before(b) {
+			b = n
+		}
+	}
+	return b
+}
+
+func (u unionSchedule) prev(t time.Time) time.Time {
+	var b time.Time
+	for i, s := range u {
+		if p := s.prev(t); i == 0 || p.After(b) {
+			b = p
+		}
+	}
+	return b
+}
+
+// Rotate every d at multiples of d since the Unix epoch, for
+// Config.RotateEvery, so that every instance rotates at the same moments
+// whatever its time zone or start time.
+type everySchedule struct {
+	d time.Duration
+}
+
+func (s everySchedule) prev(t time.Time) time.Time {
+	ns := t.UnixNano()
+	off := ns % int64(s.d)
+	if off < 0 {
+		off += int64(s.d)
+	}
+	return time.Unix(0, ns-off).In(t.Location())
+}
+
+func (s everySchedule) next(t time.Time) time.Time {
+	return s.prev(t).Add(s.d)
+}
+
+// Rotate every d, where d is one of the units in patternUnits. Hours follow
+// the local clock so zones with half-hour offsets still roll on the hour.
+type periodSchedule struct {
+	d time.Duration
+}
+
+func (s periodSchedule) prev(t time.Time) time.Time {
+	if s.d == time.Hour {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return t.Truncate(s.d)
+}
+
+func (s periodSchedule) next(t time.Time) time.Time {
+	// in the hour repeated when clocks go back, prev can be the first
+	// pass of the hour, so the boundary after it is not yet past t
+	b := s.prev(t).Add(s.d)
+	for !b.After(t) {
+		b = b.Add(s.d)
+	}
+	return b
+}
@@ -0,0 +1,134 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+	"github.com/mendsley/rollinglog/rollinglogtest"
+)
+
+// Load America/New_York, skipping the test where the system has no zone
+// database.
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	return loc
+}
+
+func TestDailyRotationAcrossDST(t *testing.T) {
+	loc := newYork(t)
+	for _, tc := range []struct {
+		name   string
+		day    time.Time // of the change
+		length time.Duration
+	}{
+		{"spring forward", time.Date(2024, 3, 10, 0, 0, 0, 0, loc), 23 * time.Hour},
+		{"fall back", time.Date(2024, 11, 3, 0, 0, 0, 0, loc), 25 * time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := rollinglogtest.NewClock(tc.day.Add(-12 * time.Hour))
+			fsys := rollinglogtest.NewMemFS()
+			config := rollinglog.Config{FilepathPattern: "logs/{2006-01-02}/app.log", FS: fsys}
+			rec := rollinglogtest.Record(&config, clock)
+			w := rollinglog.NewMust(config)
+			defer w.Close()
+
+			io.WriteString(w, "before\n")
+			rollinglogtest.ExpectRotationAt(t, rec, tc.day)
+			io.WriteString(w, "during\n")
+			next := tc.day.AddDate(0, 0, 1)
+			if d := next.Sub(tc.day); d != tc.length {
+				t.Fatalf("day is %v long, want %v", d, tc.length)
+			}
+			rollinglogtest.ExpectRotationAt(t, rec, next)
+			io.WriteString(w, "after\n")
+
+			prev := tc.day.AddDate(0, 0, -1)
+			rollinglogtest.ExpectFile(t, fsys, "logs/"+prev.Format("2006-01-02")+"/app.log", "before\n")
+			rollinglogtest.ExpectFile(t, fsys, "logs/"+tc.day.Format("2006-01-02")+"/app.log", "during\n")
+			rollinglogtest.ExpectFile(t, fsys, "logs/"+next.Format("2006-01-02")+"/app.log", "after\n")
+		})
+	}
+}
+
+func TestHourlyRotationAcrossDST(t *testing.T) {
+	loc := newYork(t)
+	t.Run("spring forward", func(t *testing.T) {
+		// 02:00 EST is 03:00 EDT, so the 02 file is never opened
+		clock := rollinglogtest.NewClock(time.Date(2024, 3, 10, 0, 30, 0, 0, loc))
+		fsys := rollinglogtest.NewMemFS()
+		config := rollinglog.Config{FilepathPattern: "logs/{2006-01-02}/{15}.log", FS: fsys}
+		rec := rollinglogtest.Record(&config, clock)
+		w := rollinglog.NewMust(config)
+		defer w.Close()
+
+		io.WriteString(w, "00\n")
+		rollinglogtest.ExpectRotationAt(t, rec, time.Date(2024, 3, 10, 1, 0, 0, 0, loc))
+		io.WriteString(w, "01\n")
+		rollinglogtest.ExpectRotationAt(t, rec, time.Date(2024, 3, 10, 3, 0, 0, 0, loc))
+		io.WriteString(w, "03\n")
+
+		if files := fsys.Files(); len(files) != 3 {
+			t.Errorf("got files %q, want 3", files)
+		}
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-03-10/00.log", "00\n")
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-03-10/01.log", "01\n")
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-03-10/03.log", "03\n")
+	})
+
+	t.Run("fall back", func(t *testing.T) {
+		edt := time.Date(2024, 11, 3, 1, 0, 0, 0, loc)
+		est := edt.Add(time.Hour) // 01:00 again
+		clock := rollinglogtest.NewClock(edt.Add(-30 * time.Minute))
+		fsys := rollinglogtest.NewMemFS()
+		config := rollinglog.Config{FilepathPattern: "logs/{2006-01-02}/{15}.log", FS: fsys}
+		rec := rollinglogtest.Record(&config, clock)
+		w := rollinglog.NewMust(config)
+		defer w.Close()
+
+		io.WriteString(w, "00\n")
+		rollinglogtest.ExpectRotationAt(t, rec, edt)
+		io.WriteString(w, "01 EDT\n")
+		// the repeated hour has the same name, so it carries on the file
+		rollinglogtest.ExpectNoRotationUntil(t, rec, est.Add(time.Minute))
+		io.WriteString(w, "01 EST\n")
+		rollinglogtest.ExpectRotationAt(t, rec, est.Add(time.Hour))
+		io.WriteString(w, "02\n")
+
+		if files := fsys.Files(); len(files) != 3 {
+			t.Errorf("got files %q, want 3", files)
+		}
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-11-03/00.log", "00\n")
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-11-03/01.log", "01 EDT\n01 EST\n")
+		rollinglogtest.ExpectFile(t, fsys, "logs/2024-11-03/02.log", "02\n")
+	})
+}
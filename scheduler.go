@@ -0,0 +1,217 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// A Scheduler drives the rotation of many writers from a single goroutine
+// and timer, instead of one goroutine per Writer. Writers join one through
+// Config.Scheduler, and a Manager uses its config's Scheduler or, without
+// one, a Scheduler of its own. The goroutine only runs while writers are
+// registered, so a Scheduler needs no closing.
+//
+// Rotation work of one writer, such as PostRotate and Archiver, delays the
+// others, so slow hooks are better handed to another goroutine.
+type Scheduler struct {
+	clock Clock
+	pool  chan struct{} // maintenance slots, nil for no limit
+
+	mu      sync.Mutex
+	queue   schedQueue
+	running bool
+	wake    chan struct{}
+}
+
+// SharedScheduler is a Scheduler for writers with no reason to keep apart
+// from the rest of the program's logs.
+var SharedScheduler = NewScheduler()
+
+// NewScheduler creates an empty Scheduler.
+func NewScheduler() *Scheduler {
+	return &Scheduler{clock: systemClock{}, wake: make(chan struct{}, 1)}
+}
+
+// NewPooledScheduler creates an empty Scheduler whose writers share workers
+// slots for maintenance: compressing backups, handing them to Archiver and
+// pruning old files. Each task waits for a slot, so that hundreds of
+// writers, one per tenant say, cannot all compress or upload at once; the
+// pool takes the place of each writer's CompressWorkers. Maintain with
+// such a Scheduler in its config uses the pool as well.
+func NewPooledScheduler(workers int) *Scheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+	s := NewScheduler()
+	s.pool = make(chan struct{}, workers)
+	return s
+}
+
+// The maintenance slots config's Scheduler shares among its writers, or
+// nil if it does not.
+func (c *Config) workerPool() chan struct{} {
+	if c.Scheduler == nil {
+		return nil
+	}
+	return c.Scheduler.pool
+}
+
+// Take a slot of the shared maintenance pool, if there is one, for the
+// duration of fn.
+func withWorker(config *Config, fn func() error) error {
+	if pool := config.workerPool(); pool != nil {
+		pool <- struct{}{}
+		defer func() { <-pool }()
+	}
+	return fn()
+}
+
+// A writer registered with a Scheduler.
+type schedEntry struct {
+	sched   *Scheduler
+	rf      *Writer
+	dropped func()    // called once the writer leaves the scheduler
+	at      time.Time // when the writer is next due; zero is now
+	index   int       // in the queue, or -1 while being stepped
+	again   bool      // made due while being stepped
+}
+
+// The writers of a Scheduler, earliest deadline first.
+type schedQueue []*schedEntry
+
+func (q schedQueue) Len() int           { return len(q) }
+func (q schedQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q schedQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *schedQueue) Push(x any) {
+	e := x.(*schedEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *schedQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	e.index = -1
+	return e
+}
+
+// Register e, due at once, starting the goroutine if it is not running.
+func (s *Scheduler) add(e *schedEntry) {
+	s.mu.Lock()
+	heap.Push(&s.queue, e)
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+	s.mu.Unlock()
+	s.poke()
+}
+
+// Make e due now.
+func (s *Scheduler) due(e *schedEntry) {
+	s.mu.Lock()
+	if e.index < 0 {
+		e.again = true
+	} else if !e.at.IsZero() {
+		e.at = time.Time{}
+		heap.Fix(&s.queue, e.index)
+	}
+	s.mu.Unlock()
+	s.poke()
+}
+
+func (s *Scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// The background goroutine, stepping each writer whose deadline has
+// passed and sleeping until the earliest of the others. It exits once the
+// last writer has left.
+func (s *Scheduler) run() {
+	var due []*schedEntry
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		now := s.clock.Now()
+		due = due[:0]
+		for len(s.queue) > 0 && !now.Before(s.queue[0].at) {
+			due = append(due, heap.Pop(&s.queue).(*schedEntry))
+		}
+		wait := maxSleep
+		if len(due) == 0 {
+			wait = sleepUntil(now, s.queue[0].at)
+		}
+		s.mu.Unlock()
+
+		if len(due) > 0 {
+			for _, e := range due {
+				s.step(e)
+			}
+			continue
+		}
+		timer := s.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// Run the rotation of e's writer, and requeue it by its next deadline or
+// let it go once it is closed or can no longer rotate.
+func (s *Scheduler) step(e *schedEntry) {
+	deadline, ok := e.rf.advance(s.clock.Now())
+	s.mu.Lock()
+	if ok {
+		e.at = deadline
+		if e.again {
+			e.at, e.again = time.Time{}, false
+		}
+		heap.Push(&s.queue, e)
+	}
+	s.mu.Unlock()
+	if !ok && e.dropped != nil {
+		e.dropped()
+	}
+}
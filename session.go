@@ -0,0 +1,242 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Sessions opens one rolling log per logical session, such as a batch job,
+// from a pattern containing {session}:
+//
+//	logs/jobs/{session}/{2006-01-02}.log
+//
+// Ending a session closes its writer and hands the last file to
+// PostRotate, PostRotateCmd and Archiver as if it had been rotated.
+type Sessions struct {
+	config Config
+	idle   time.Duration
+	clock  Clock // that of the writers, for the idle timeout
+
+	mu       sync.Mutex
+	open     map[string]*Session
+	closed   bool
+	chClosed chan struct{}
+}
+
+// A Session is the log of one session started by Sessions.Start.
+type Session struct {
+	*Writer
+	id       string
+	sessions *Sessions
+	last     atomic.Int64 // unix nanoseconds of the last write, by the writer's clock
+	ended    atomic.Bool
+}
+
+// NewSessions creates a session manager writing files described by config,
+// whose FilepathPattern or NameTemplate must use the session id. Sessions
+// that go idle longer than idle, by the writers' clock, are taken to be
+// abandoned and are ended, which each reports on its Errors channel; zero
+// keeps them open until ended explicitly.
+func NewSessions(config Config, idle time.Duration) (*Sessions, error) {
+	if config.NameTemplate == nil && !strings.Contains(config.FilepathPattern, "{session}") {
+		return nil, errors.New("rollinglog: session pattern must contain {session}")
+	}
+	clock := config.Clock
+	if config.Scheduler != nil {
+		clock = config.Scheduler.clock
+	} else if clock == nil {
+		clock = systemClock{}
+	}
+	s := &Sessions{
+		config:   config,
+		idle:     idle,
+		clock:    clock,
+		open:     make(map[string]*Session),
+		chClosed: make(chan struct{}),
+	}
+	if idle > 0 {
+		go s.reap()
+	}
+	return s, nil
+}
+
+// Start opens the log of session id. Ids are used in paths, so they must be
+// non-empty and may not contain path separators.
+func (s *Sessions) Start(id string) (*Session, error) {
+	if err := validSessionID(id); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("rollinglog: sessions are closed")
+	}
+	if _, ok := s.open[id]; ok {
+		return nil, fmt.Errorf("rollinglog: session %q is already open", id)
+	}
+
+	config := s.config
+	config.Session = id
+	w, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{Writer: w, id: id, sessions: s}
+	session.touch()
+	s.open[id] = session
+	return session, nil
+}
+
+// Report an error for session ids that cannot be used in paths.
+func validSessionID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("rollinglog: invalid session id %q", id)
+	}
+	return nil
+}
+
+// Lookup returns the open session id, or nil.
+func (s *Sessions) Lookup(id string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open[id]
+}
+
+// Close ends every open session and stops further sessions from starting.
+func (s *Sessions) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.chClosed)
+	open := make([]*Session, 0, len(s.open))
+	for _, session := range s.open {
+		open = append(open, session)
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, session := range open {
+		errs = append(errs, session.End())
+	}
+	return errors.Join(errs...)
+}
+
+// End sessions that have not been written to within the idle timeout.
+func (s *Sessions) reap() {
+	interval := s.idle / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for {
+		timer := s.clock.NewTimer(interval)
+		select {
+		case <-s.chClosed:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		now := s.clock.Now()
+		s.mu.Lock()
+		var idle []*Session
+		for _, session := range s.open {
+			if now.Sub(time.Unix(0, session.last.Load())) > s.idle {
+				idle = append(idle, session)
+			}
+		}
+		s.mu.Unlock()
+		for _, session := range idle {
+			rf := session.Writer
+			rf.logf("ending abandoned session %q", session.id)
+			if err := session.End(); err != nil {
+				rf.logf("ending session %q: %w", session.id, err)
+			}
+		}
+	}
+}
+
+// ID returns the session id.
+func (session *Session) ID() string {
+	return session.id
+}
+
+// Note a write to the session, for the idle timeout.
+func (session *Session) touch() {
+	session.last.Store(session.Writer.clock.Now().UnixNano())
+}
+
+func (session *Session) Write(p []byte) (int, error) {
+	session.touch()
+	return session.Writer.Write(p)
+}
+
+func (session *Session) WriteString(s string) (int, error) {
+	session.touch()
+	return session.Writer.WriteString(s)
+}
+
+func (session *Session) WriteBatch(bufs [][]byte) (int, error) {
+	session.touch()
+	return session.Writer.WriteBatch(bufs)
+}
+
+func (session *Session) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(session, r)
+}
+
+// End closes the session's log and finishes its last file. Later calls do
+// nothing.
+func (session *Session) End() error {
+	if session.ended.Swap(true) {
+		return nil
+	}
+	s := session.sessions
+	s.mu.Lock()
+	delete(s.open, session.id)
+	s.mu.Unlock()
+
+	rf := session.Writer
+	kept, err := rf.close()
+	if kept != "" {
+		rf.postRotate(context.Background(), kept, RotateClosed)
+	}
+	return err
+}
+
+// Close ends the session, as End does.
+func (session *Session) Close() error {
+	return session.End()
+}
@@ -0,0 +1,239 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// A spill file mirrors the Config.Async queue in a memory mapped ring, so
+// that records accepted by Write but not yet written out survive the
+// process dying and can be appended when the log is next opened. Records
+// leave the ring only once they have reached the log file.
+//
+// The file starts with a header of spillMagic and three little-endian
+// uint64s: the head and tail offsets and the capacity of the ring that
+// follows. Offsets only grow; each record is a uint32 length and its data,
+// wrapping around the end of the ring. The data starts with the time the
+// record was written, as int64 unix nanoseconds, so that it can be put in
+// the file of its period; spillMagicV1 files have no times.
+type spillFile struct {
+	mu   sync.Mutex // callers hold it from push until the record is queued
+	f    *os.File
+	buf  []byte // the whole mapping
+	ring []byte
+	head uint64
+	tail uint64
+}
+
+// A record left in a spill file.
+type spillRecord struct {
+	t time.Time // zero for spillMagicV1 files
+	p []byte
+}
+
+const (
+	spillMagic   = "RLSPILL2"
+	spillMagicV1 = "RLSPILL1"
+	spillHeader  = len(spillMagic) + 24
+	spillStamp   = 8 // bytes of the time at the start of each record
+	// the length of a record too large to be kept, which holds its place
+	spillSkipped = math.MaxUint32
+)
+
+// Read the records left in the spill file at p by a previous process, if
+// any.
+func readSpill(p string) ([]spillRecord, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, spillHeader)
+	if _, err := io.ReadFull(f, header); err == io.EOF {
+		return nil, nil
+	}
+	magic := string(header[:len(spillMagic)])
+	if err != nil || magic != spillMagic && magic != spillMagicV1 {
+		return nil, fmt.Errorf("rollinglog: %s is not a spill file", p)
+	}
+	head := binary.LittleEndian.Uint64(header[8:])
+	tail := binary.LittleEndian.Uint64(header[16:])
+	capacity := binary.LittleEndian.Uint64(header[24:])
+	if tail < head || tail-head > capacity {
+		return nil, fmt.Errorf("rollinglog: spill file %s is corrupt", p)
+	}
+	ring := make([]byte, capacity)
+	if _, err := io.ReadFull(f, ring); err != nil {
+		return nil, fmt.Errorf("rollinglog: spill file %s is truncated", p)
+	}
+
+	s := &spillFile{ring: ring, head: head, tail: tail}
+	var records []spillRecord
+	for s.head != s.tail {
+		n, ok := s.next()
+		if !ok || n != spillSkipped && magic == spillMagic && n < spillStamp {
+			return records, fmt.Errorf("rollinglog: spill file %s is corrupt", p)
+		}
+		if n != spillSkipped {
+			data := make([]byte, n)
+			s.get(s.head+4, data)
+			var r spillRecord
+			if magic == spillMagic {
+				r.t = time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+				data = data[spillStamp:]
+			}
+			r.p = data
+			records = append(records, r)
+		}
+		s.skip(n)
+	}
+	return records, nil
+}
+
+// Create the spill file at p afresh, with room for capacity bytes of
+// records.
+func createSpill(p string, capacity int, mode os.FileMode) (*spillFile, error) {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, mode)
+	if err != nil {
+		return nil, err
+	}
+	size := spillHeader + capacity
+	if err = f.Truncate(0); err == nil {
+		err = f.Truncate(int64(size))
+	}
+	var buf []byte
+	if err == nil {
+		buf, err = mapFile(f, size)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("rollinglog: spill file %s: %v", p, err)
+	}
+	s := &spillFile{f: f, buf: buf, ring: buf[spillHeader:]}
+	copy(buf, spillMagic)
+	binary.LittleEndian.PutUint64(buf[24:], uint64(capacity))
+	return s, nil
+}
+
+// Append p, written at t, to the ring, or a placeholder if it cannot fit,
+// and return the previous tail for unpush. Called with s.mu held.
+func (s *spillFile) push(p []byte, t time.Time) uint64 {
+	mark := s.tail
+	n := spillStamp + uint64(len(p))
+	var length [4]byte
+	if 4+n > uint64(len(s.ring))-(s.tail-s.head) || n >= spillSkipped {
+		binary.LittleEndian.PutUint32(length[:], spillSkipped)
+		s.put(s.tail, length[:])
+		s.setTail(s.tail + 4)
+		return mark
+	}
+	var stamp [spillStamp]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(n))
+	binary.LittleEndian.PutUint64(stamp[:], uint64(t.UnixNano()))
+	s.put(s.tail, length[:])
+	s.put(s.tail+4, stamp[:])
+	s.put(s.tail+4+spillStamp, p)
+	s.setTail(s.tail + 4 + n) // only once the record is complete
+	return mark
+}
+
+// Take back the record pushed at mark. Called with s.mu held.
+func (s *spillFile) unpush(mark uint64) {
+	s.setTail(mark)
+}
+
+// Drop the oldest record, which has been written out or given up on.
+func (s *spillFile) pop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buf == nil || s.head == s.tail {
+		return
+	}
+	n, _ := s.next()
+	s.skip(n)
+	binary.LittleEndian.PutUint64(s.buf[8:], s.head)
+}
+
+func (s *spillFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := unmapFile(s.buf)
+	s.buf, s.ring = nil, nil
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *spillFile) setTail(tail uint64) {
+	s.tail = tail
+	binary.LittleEndian.PutUint64(s.buf[16:], tail)
+}
+
+// The length of the record at the head, and whether it lies within the
+// ring.
+func (s *spillFile) next() (uint32, bool) {
+	var length [4]byte
+	s.get(s.head, length[:])
+	n := binary.LittleEndian.Uint32(length[:])
+	if n == spillSkipped {
+		return n, s.tail-s.head >= 4
+	}
+	return n, s.tail-s.head >= 4+uint64(n)
+}
+
+// Move the head past a record of length n.
+func (s *spillFile) skip(n uint32) {
+	s.head += 4
+	if n != spillSkipped {
+		s.head += uint64(n)
+	}
+}
+
+// Copy b into the ring at offset off, wrapping around its end.
+func (s *spillFile) put(off uint64, b []byte) {
+	for len(b) > 0 {
+		n := copy(s.ring[off%uint64(len(s.ring)):], b)
+		b, off = b[n:], off+uint64(n)
+	}
+}
+
+// Fill b from the ring at offset off, wrapping around its end.
+func (s *spillFile) get(off uint64, b []byte) {
+	for len(b) > 0 {
+		n := copy(b, s.ring[off%uint64(len(s.ring)):])
+		b, off = b[n:], off+uint64(n)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSpillReplayByPeriod(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "dragonfly", "freebsd", "netbsd", "openbsd":
+	default:
+		t.Skip("spill files are not supported on " + runtime.GOOS)
+	}
+	dir := t.TempDir()
+	spill := filepath.Join(dir, "spill")
+	today := time.Now()
+	yesterday := today.AddDate(0, 0, -1)
+
+	// what a process that crashed yesterday left behind
+	s, err := createSpill(spill, 1<<10, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.push([]byte("yesterday 1\n"), yesterday)
+	s.push([]byte("yesterday 2\n"), yesterday)
+	s.mu.Unlock()
+	s.close()
+
+	w, err := New(Config{
+		FilepathPattern: filepath.Join(dir, "{2006-01-02}.log"),
+		Async:           true,
+		AsyncSpill:      spill,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("today\n"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for day, want := range map[time.Time]string{yesterday: "yesterday 1\nyesterday 2\n", today: "today\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, day.Format("2006-01-02")+".log"))
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != want {
+			t.Errorf("%s holds %q, want %q", day.Format("2006-01-02"), got, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion is the version of the on-disk format written by this
+// package. It is raised whenever the framing of log files changes, so that
+// readers can refuse files they do not understand.
+const FormatVersion = 1
+
+// The extended attribute holding a file's Stamp.
+const stampAttr = "user.rollinglog"
+
+// ErrNoStamp is returned by ReadStamp for a file that was not stamped.
+var ErrNoStamp = errors.New("rollinglog: file has no version stamp")
+
+// Stamp records which format, library version and Go release wrote a file.
+// With Config.StampVersion set, each file the writer creates is stamped in
+// an extended attribute. Stamping is only supported on Linux and is
+// silently skipped elsewhere or on filesystems without user attributes.
+type Stamp struct {
+	Format  int
+	Library string // module version of rollinglog, or (devel)
+	Go      string
+}
+
+func (s Stamp) String() string {
+	return fmt.Sprintf("format=%d library=%s go=%s", s.Format, s.Library, s.Go)
+}
+
+// The stamp written by this build.
+func currentStamp() Stamp {
+	s := Stamp{Format: FormatVersion, Library: "(devel)", Go: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		const module = "github.com/mendsley/rollinglog"
+		if info.Main.Path == module {
+			s.Library = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == module {
+				s.Library = dep.Version
+			}
+		}
+	}
+	return s
+}
+
+func parseStamp(text string) (Stamp, error) {
+	var s Stamp
+	for _, field := range strings.Fields(text) {
+		k, v, _ := strings.Cut(field, "=")
+		switch k {
+		case "format":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return Stamp{}, fmt.Errorf("rollinglog: malformed version stamp %q", text)
+			}
+			s.Format = n
+		case "library":
+			s.Library = v
+		case "go":
+			s.Go = v
+		}
+	}
+	if s.Format == 0 {
+		return Stamp{}, fmt.Errorf("rollinglog: malformed version stamp %q", text)
+	}
+	return s, nil
+}
+
+// Stamp f with the current version. Errors are ignored: a missing stamp
+// only means the file predates stamping as far as readers are concerned.
+func stampFile(f *os.File) {
+	setXattr(f, stampAttr, currentStamp().String())
+}
+
+// ReadStamp returns the version stamp of the file at path, or ErrNoStamp
+// if it has none.
+func ReadStamp(path string) (Stamp, error) {
+	text, err := getXattr(path, stampAttr)
+	if err != nil {
+		return Stamp{}, ErrNoStamp
+	}
+	return parseStamp(text)
+}
+
+// CheckStamp reports an error if the file at path was written in a format
+// newer than this package understands. Unstamped files are accepted.
+func CheckStamp(path string) error {
+	s, err := ReadStamp(path)
+	if err == ErrNoStamp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if s.Format > FormatVersion {
+		return fmt.Errorf("rollinglog: %s uses format %d, newer than supported %d (written by %s)", path, s.Format, FormatVersion, s.Library)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+)
+
+// Status describes how well a writer is working, for readiness probes and
+// dashboards.
+type Status struct {
+	Path     string // active file, empty if none could be opened
+	Closed   bool
+	Degraded bool // writes are going to Config.Fallback
+
+	// The last failure to write to or open a file, cleared once a write
+	// succeeds or a new file is opened.
+	WriteError   error
+	WriteErrorAt time.Time
+
+	// When the last rotation finished and what went wrong with it, such
+	// as a numbered backup that could not be shifted.
+	LastRotation  time.Time
+	RotationError error
+
+	// Maintenance: compressions and archive retries waiting or under way,
+	// and when the last round of work after a rotation or archive retry
+	// began and how long it took. With a pooled Scheduler, work also
+	// waits for the writers sharing it.
+	MaintenanceQueued int
+	LastMaintenance   time.Time
+	MaintenanceTook   time.Duration
+
+	// With Config.Async: bytes waiting in the queue and the most it holds.
+	QueuedBytes int64
+	QueueLimit  int64
+
+	// Free space on the filesystem of the active file, if it could be
+	// measured.
+	FreeBytes uint64
+	FreeError error
+}
+
+// Status reports the state of the writer. It measures free space, so it
+// costs a system call.
+func (rf *Writer) Status() Status {
+	rf.mu.Lock()
+	s := Status{
+		Closed:        rf.closed,
+		Degraded:      rf.degraded(),
+		WriteError:    rf.writeErr,
+		WriteErrorAt:  rf.writeErrAt,
+		LastRotation:  rf.rotatedAt,
+		RotationError: rf.rotateErr,
+
+		MaintenanceQueued: int(rf.archiveQueue.Load() + rf.compress.queued.Load()),
+		LastMaintenance:   rf.maintainedAt,
+		MaintenanceTook:   rf.maintainTook,
+	}
+	dir := ""
+	if rf.f != nil {
+		s.Path = rf.f.Name()
+		dir = path.Dir(s.Path)
+	} else if p, err := rf.layout.activePath(rf.clock.Now()); err == nil {
+		dir = path.Dir(p)
+	}
+	rf.mu.Unlock()
+
+	if q := rf.async; q != nil {
+		s.QueuedBytes, s.QueueLimit = q.queued.Load(), q.limit
+	}
+	s.FreeBytes, s.FreeError = freeBytes(dir)
+	return s
+}
+
+// Health returns nil if the writer is working: it is open, its last write
+// succeeded, its last rotation went through, its queue has room and its
+// disk has space, above Config.MinFreeBytes when that is set. Otherwise it
+// returns an error saying what is wrong.
+func (rf *Writer) Health() error {
+	s := rf.Status()
+	if s.Closed {
+		return ErrClosed
+	}
+	var errs []error
+	if s.WriteError != nil {
+		errs = append(errs, fmt.Errorf("rollinglog: writes failing since %s: %w", s.WriteErrorAt.Format(time.RFC3339), s.WriteError))
+	} else if s.Degraded {
+		errs = append(errs, errors.New("rollinglog: writes are going to the fallback"))
+	}
+	if s.RotationError != nil {
+		errs = append(errs, fmt.Errorf("rollinglog: last rotation failed: %w", s.RotationError))
+	}
+	if s.QueueLimit > 0 && s.QueuedBytes >= s.QueueLimit {
+		errs = append(errs, errors.New("rollinglog: async queue is full"))
+	}
+	if s.FreeError == nil {
+		if s.FreeBytes == 0 || s.FreeBytes < rf.config.MinFreeBytes {
+			errs = append(errs, fmt.Errorf("rollinglog: only %d bytes free for %s", s.FreeBytes, s.Path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Remember a failure to write or open a file for Status. Called with rf.mu
+// held.
+func (rf *Writer) noteWriteError(err error) {
+	if rf.writeErr == nil {
+		rf.writeErrAt = rf.clock.Now()
+	}
+	rf.writeErr = err
+	rf.pressure.failing.Store(true)
+}
+
+// Forget the last failure once a write succeeds or a new file is opened.
+// Called with rf.mu held.
+func (rf *Writer) clearWriteError() {
+	rf.writeErr = nil
+	rf.pressure.failing.Store(false)
+}
+
+// Remember the outcome of a rotation for Status.
+func (rf *Writer) noteRotation(err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.rotatedAt, rf.rotateErr = rf.clock.Now(), err
+}
@@ -0,0 +1,83 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"os"
+)
+
+// SyncMode selects whether writes reach stable storage before Write
+// returns, through the flags the log files are opened with.
+type SyncMode int
+
+const (
+	// SyncNone leaves flushing to the operating system.
+	SyncNone SyncMode = iota
+	// SyncData opens files with O_DSYNC, waiting for the data and the
+	// metadata needed to read it back, such as the size. Where O_DSYNC is
+	// not available it is the same as SyncFull.
+	SyncData
+	// SyncFull opens files with O_SYNC, also waiting for the remaining
+	// metadata such as timestamps.
+	SyncFull
+)
+
+func (s SyncMode) MarshalText() ([]byte, error) {
+	switch s {
+	case SyncNone:
+		return []byte("none"), nil
+	case SyncData:
+		return []byte("data"), nil
+	case SyncFull:
+		return []byte("full"), nil
+	}
+	return nil, fmt.Errorf("rollinglog: unknown sync mode %d", int(s))
+}
+
+func (s *SyncMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "none", "":
+		*s = SyncNone
+	case "data":
+		*s = SyncData
+	case "full":
+		*s = SyncFull
+	default:
+		return fmt.Errorf("rollinglog: unknown sync mode %q", text)
+	}
+	return nil
+}
+
+// The open flags for the mode.
+func (s SyncMode) flag() int {
+	switch s {
+	case SyncData:
+		return oDSYNC
+	case SyncFull:
+		return os.O_SYNC
+	}
+	return 0
+}
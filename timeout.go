@@ -0,0 +1,133 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWriteTimeout is returned by writes to a log file that took longer
+// than Config.WriteTimeout, and by every later write to the file until
+// the one stuck has returned.
+var ErrWriteTimeout = errors.New("rollinglog: write to log file timed out")
+
+// A timedFile hands writes to a goroutine of its own and stops waiting
+// for one after a timeout, so a hung mount holds the writer's lock no
+// longer than that. The abandoned write goes on in the background, and
+// whatever it manages to write is not counted in the file's size.
+type timedFile struct {
+	f       File
+	timeout time.Duration
+	timer   *time.Timer
+	buf     []byte // copy of the data being written
+	req     chan []byte
+	done    chan timedResult
+
+	mu        sync.Mutex
+	abandoned bool // a timed out write has not returned yet
+}
+
+type timedResult struct {
+	n   int
+	err error
+}
+
+func newTimedFile(f File, timeout time.Duration) *timedFile {
+	tf := &timedFile{
+		f:       f,
+		timeout: timeout,
+		timer:   time.NewTimer(timeout),
+		req:     make(chan []byte, 1),
+		done:    make(chan timedResult, 1),
+	}
+	tf.timer.Stop()
+	go tf.run()
+	return tf
+}
+
+func (tf *timedFile) run() {
+	for p := range tf.req {
+		n, err := tf.f.Write(p)
+		tf.mu.Lock()
+		if tf.abandoned {
+			tf.abandoned = false
+		} else {
+			tf.done <- timedResult{n, err}
+		}
+		tf.mu.Unlock()
+	}
+}
+
+func (tf *timedFile) Write(p []byte) (int, error) {
+	tf.mu.Lock()
+	stuck := tf.abandoned
+	tf.mu.Unlock()
+	if stuck {
+		return 0, ErrWriteTimeout
+	}
+
+	// the caller may reuse p once we have given up on the write
+	tf.buf = append(tf.buf[:0], p...)
+	tf.req <- tf.buf
+	start := time.Now()
+	tf.timer.Reset(tf.timeout)
+	for {
+		select {
+		case r := <-tf.done:
+			tf.timer.Stop()
+			tf.release()
+			return r.n, r.err
+		case <-tf.timer.C:
+			if time.Since(start) < tf.timeout {
+				continue // left over from an earlier write
+			}
+			tf.mu.Lock()
+			defer tf.mu.Unlock()
+			select {
+			case r := <-tf.done:
+				tf.release()
+				return r.n, r.err
+			default:
+			}
+			tf.abandoned = true
+			tf.buf = nil // still being written
+			return 0, ErrWriteTimeout
+		}
+	}
+}
+
+// Let go of a copy too large to be worth keeping for the next write.
+func (tf *timedFile) release() {
+	if cap(tf.buf) > maxFilterBuf {
+		tf.buf = nil
+	}
+}
+
+// Stop the goroutine once any write in progress has returned.
+func (tf *timedFile) stop() {
+	close(tf.req)
+}
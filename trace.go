@@ -0,0 +1,45 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import "context"
+
+// A Tracer observes the writer's background operations: "rotate" covers
+// retiring a file and opening its replacement, "archive" covers a call to
+// Config.Archiver. Start is called as an operation begins on path and
+// returns a context for it, which the archiver receives, and a function
+// to call with the outcome. The otelrollinglog package provides an
+// OpenTelemetry implementation.
+type Tracer interface {
+	Start(ctx context.Context, op, path string) (context.Context, func(err error))
+}
+
+// Begin tracing op on path with Config.Tracer, if any.
+func (rf *Writer) trace(ctx context.Context, op, path string) (context.Context, func(err error)) {
+	if rf.config.Tracer == nil {
+		return ctx, func(error) {}
+	}
+	return rf.config.Tracer.Start(ctx, op, path)
+}
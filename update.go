@@ -0,0 +1,165 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Update applies the naming, scheduling and retention settings of config
+// to a running writer, so that a long-running daemon can change its log
+// layout without restarting: FilepathPattern, PatternSyntax, NameTemplate,
+// Namer, Mode, DirMode, RotateAt, RotateCron, RotateEvery, RotatePeriod,
+// WeekStart, RotateJitter, MaxSize, MaxFiles, MaxTotalBytes, MaxBackups
+// and CompressFrom. Other fields keep the values the writer was created
+// with. The result is validated as by
+// New and nothing changes on error. If the active file would now be named
+// differently it is rotated with RotateReconfigured, returning without
+// waiting for that; otherwise the new schedule and retention apply to it
+// from now on. The fields changed, with their old and new values, are
+// reported on Errors and in the journal, so that changes to logging can be
+// audited.
+func (rf *Writer) Update(config Config) error {
+	rf.mu.Lock()
+	if rf.closed {
+		rf.mu.Unlock()
+		return ErrClosed
+	}
+	next := rf.config
+	rf.mu.Unlock()
+
+	setUpdatable(&next, &config)
+	l, err := newLayout(&next)
+	if err != nil {
+		return err
+	}
+
+	rf.stepMu.Lock()
+	defer rf.stepMu.Unlock()
+	rf.mu.Lock()
+	if rf.closed {
+		rf.mu.Unlock()
+		return ErrClosed
+	}
+	changes := configChanges(&rf.config, &next)
+	setUpdatable(&rf.config, &next)
+	l.config = &rf.config
+	rf.layout = l
+	rename := false
+	if f := rf.f; f != nil {
+		p, err := l.name(f.stamp, 0)
+		rename = err == nil && p != f.base
+	}
+	if rename {
+		rf.requestRotation(RotateReconfigured)
+	}
+	if len(changes) > 0 {
+		desc := strings.Join(changes, ", ")
+		rf.logf("updated %s", desc)
+		var name string
+		if rf.f != nil {
+			name = rf.f.Name()
+		}
+		rf.journal.event(journalInfo, name, "updated %s", desc)
+	}
+	rf.mu.Unlock()
+
+	rs := &rf.rot
+	if !rename && rs.started && rs.current != nil {
+		rf.schedule(rf.clock.Now())
+		rf.prune(l, rs.current.Name())
+	}
+	rf.poke()
+	return nil
+}
+
+// The fields of Config that Update may change.
+var updatableFields = []string{
+	"FilepathPattern", "PatternSyntax", "NameTemplate", "Namer", "Mode", "DirMode",
+	"RotateAt", "RotateCron", "RotateEvery", "RotatePeriod", "WeekStart", "RotateJitter",
+	"MaxSize", "MaxFiles", "MaxTotalBytes", "MaxBackups", "CompressFrom",
+}
+
+// Copy the fields Update may change from src to dst.
+func setUpdatable(dst, src *Config) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for _, name := range updatableFields {
+		d.FieldByName(name).Set(s.FieldByName(name))
+	}
+}
+
+// Describe the fields Update may change that differ between old and new,
+// such as `MaxSize 0 → 1048576`. Hooks, which have no value worth
+// showing, are described as set, cleared or replaced.
+func configChanges(old, new *Config) []string {
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	var changes []string
+	for _, name := range updatableFields {
+		a, b := o.FieldByName(name), n.FieldByName(name)
+		switch a.Kind() {
+		case reflect.Func, reflect.Interface, reflect.Pointer:
+			switch {
+			case sameValue(a, b):
+			case a.IsNil():
+				changes = append(changes, name+" set")
+			case b.IsNil():
+				changes = append(changes, name+" cleared")
+			default:
+				changes = append(changes, name+" replaced")
+			}
+		default:
+			if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+				changes = append(changes, fmt.Sprintf("%s %s → %s", name, describeValue(a), describeValue(b)))
+			}
+		}
+	}
+	return changes
+}
+
+// Reports whether the hooks a and b are the same one.
+func sameValue(a, b reflect.Value) bool {
+	if a.IsNil() || b.IsNil() {
+		return a.IsNil() && b.IsNil()
+	}
+	if a.Kind() == reflect.Interface {
+		if a, b = a.Elem(), b.Elem(); a.Type() != b.Type() {
+			return false
+		}
+	}
+	switch a.Kind() {
+	case reflect.Func, reflect.Pointer, reflect.Map, reflect.Slice, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	}
+	return a.Comparable() && a.Equal(b)
+}
+
+func describeValue(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,138 @@
+// Copyright 2013 Matthew Endsley
+// All rights reserved
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted providing that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED.  IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS
+// OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+// HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+// IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package rollinglog_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mendsley/rollinglog"
+	"github.com/mendsley/rollinglog/rollinglogtest"
+)
+
+// Configurations whose steady-state Write must not allocate.
+var writeConfigs = []struct {
+	name   string
+	config rollinglog.Config
+}{
+	{"plain", rollinglog.Config{}},
+	{"PrefixTimestamps", rollinglog.Config{PrefixTimestamps: true}},
+	{"filters", rollinglog.Config{
+		StripANSI:        true,
+		MaxLineBytes:     16,
+		RepeatWindow:     time.Second,
+		PrefixTimestamps: true,
+	}},
+	{"JSONEnvelope", rollinglog.Config{StripANSI: true, JSONEnvelope: true}},
+	{"Async", rollinglog.Config{Async: true, AsyncQueue: 64, PrefixTimestamps: true, StripANSI: true}},
+}
+
+var record = []byte("\x1b[31mrecord\x1b[0m written in one call\n")
+
+func newWriter(tb testing.TB, config rollinglog.Config) *rollinglog.Writer {
+	tb.Helper()
+	config.FilepathPattern = filepath.Join(tb.TempDir(), "{2006-01-02}.log")
+	w, err := rollinglog.New(config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { w.Close() })
+	return w
+}
+
+func TestWriteAllocs(t *testing.T) {
+	for _, tc := range writeConfigs {
+		t.Run(tc.name, func(t *testing.T) {
+			w := newWriter(t, tc.config)
+			for i := 0; i < 100; i++ {
+				w.Write(record) // fill the buffers kept between records
+			}
+			allocs := testing.AllocsPerRun(1000, func() {
+				if _, err := w.Write(record); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("Write allocates %v times", allocs)
+			}
+		})
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	for _, tc := range writeConfigs {
+		b.Run(tc.name, func(b *testing.B) {
+			w := newWriter(b, tc.config)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(record)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Write(record)
+			}
+		})
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	east := time.FixedZone("UTC+2", 2*60*60)
+	clock := rollinglogtest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 500, east))
+	fsys := rollinglogtest.NewMemFS()
+	config := rollinglog.Config{FilepathPattern: "logs/app.log", FS: fsys, JSONEnvelope: true}
+	rollinglogtest.Record(&config, clock)
+	w := rollinglog.NewMust(config)
+	w.Write([]byte("said \"hi\"\n"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rollinglogtest.ExpectFile(t, fsys, "logs/app.log",
+		`{"msg":"said \"hi\"","stream":"stdout","ts":"2024-01-01T10:00:00.000000500Z"}`+"\n")
+}
+
+// Records for the JSON envelope, whose cost is escaping them.
+var envelopeRecords = []struct {
+	name   string
+	record []byte
+}{
+	{"plain", []byte("GET /index.html 200 0.004s\n")},
+	{"escapes", []byte("\tquoted \"value\" in C:\\path\r\x01\n")},
+	{"utf8", []byte("données reçues: ☃ 完了\n")},
+	{"long", []byte(strings.Repeat("a long line of captured output ", 128) + "\n")},
+}
+
+func BenchmarkEnvelope(b *testing.B) {
+	for _, tc := range envelopeRecords {
+		b.Run(tc.name, func(b *testing.B) {
+			w := newWriter(b, rollinglog.Config{JSONEnvelope: true})
+			b.ReportAllocs()
+			b.SetBytes(int64(len(tc.record)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Write(tc.record)
+			}
+		})
+	}
+}